@@ -0,0 +1,410 @@
+package chestnut
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+	jsoniter "github.com/json-iterator/go"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// archiveMagic identifies a chestnut archive.
+	archiveMagic = "chestnut-archive"
+
+	// archiveVersion is the current archive format version.
+	archiveVersion = 1
+
+	// archiveRecord marks a record in the archive.
+	archiveRecord byte = 'r'
+
+	// archiveEnd marks the sealed manifest at the end of the archive.
+	archiveEnd byte = 'm'
+
+	// maxArchiveField is the largest record name, value, or manifest in an archive.
+	maxArchiveField = 1 << 30
+)
+
+var archiveDigestInfo = []byte("chestnut archive digest")
+
+// ErrInvalidArchive is returned when an archive is corrupt, truncated, has
+// been tampered with, or was not exported with the same encryptor and secret.
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// archiveManifest is sealed with the encryptor at the end of an archive. Digest is the
+// HMAC-SHA256 of the record checksums using a key derived from the archive secret and
+// Salt, so records cannot be added, removed, reordered, or changed without knowing the
+// secret. The encryptor alone is not enough, since a public key encryptor can seal a
+// manifest without any secret key material.
+type archiveManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Records uint64    `json:"records"`
+	Salt    []byte    `json:"salt"`
+	Digest  []byte    `json:"digest"`
+}
+
+// digest returns the HMAC of the hash of the record checksums.
+func (m *archiveManifest) digest(secret crypto.Secret, sum []byte) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	kdf := hkdf.New(sha256.New, secret.Open(), m.Salt, archiveDigestInfo)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(sum)
+	return mac.Sum(nil), nil
+}
+
+// validArchiveSecret returns an error if the archive secret is missing or empty.
+func validArchiveSecret(secret crypto.Secret) error {
+	if secret == nil || len(secret.Open()) <= 0 {
+		return errors.New("archive secret cannot be empty")
+	}
+	return nil
+}
+
+// ExportArchive writes the storage chest to w as a portable archive that can be
+// restored with ImportArchive into a storage chest using any store. The values are
+// written as they are stored and the namespaces and keys are sealed with the
+// encryptor, so the archive can be kept on untrusted media. The secret signs the
+// archive and must be passed to ImportArchive to restore it. Expired records are not
+// exported. If the store supports transactions, the records are read inside of a
+// single transaction.
+//
+// The archive starts with the "chestnut-archive" magic and a version byte, followed
+// by the records and a sealed manifest. Each record is the sealed namespace and key,
+// and the value, each prefixed with its length as a uvarint, and followed by its
+// SHA-256 checksum. The manifest is encrypted with the encryptor, and holds the number
+// of records and a digest of the checksums keyed with the secret.
+//
+// Unlike Export, which only takes a path, ExportArchive and ImportArchive also take the
+// secret in addition to the writer and reader. It is required to sign the archive,
+// since the encryptor alone cannot (SEE: archiveManifest).
+func (cn *Chestnut) ExportArchive(w io.Writer, secret crypto.Secret) error {
+	cn.log.Debug("export archive")
+	if err := validArchiveSecret(secret); err != nil {
+		return cn.logError("export archive", err)
+	}
+	keyMap, err := cn.store.ListAll()
+	if err != nil {
+		return cn.logError("export archive", err)
+	}
+	names := make([]string, 0, len(keyMap))
+	for name := range keyMap {
+		if name != rekeyNamespace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var n uint64
+	exportArchive := func(tx storage.Tx) error {
+		aw := newArchiveWriter(w)
+		for _, name := range names {
+			for _, key := range keyMap[name] {
+				// the key was deleted or expired by the store after it was listed
+				if has, _ := tx.Has(name, key); !has {
+					continue
+				}
+				value, err := tx.Get(name, key)
+				if err != nil {
+					return err
+				}
+				if _, expiry := decodeExpiry(value); expired(expiry) {
+					continue
+				}
+				id, err := cn.encrypt(encodeArchiveID(name, key))
+				if err != nil {
+					return err
+				}
+				if err = aw.writeRecord(id, value); err != nil {
+					return err
+				}
+			}
+		}
+		n = aw.n
+		return cn.closeArchive(aw, secret)
+	}
+	if store, ok := cn.store.(storage.Transactional); ok {
+		err = store.View(exportArchive)
+	} else {
+		err = exportArchive(cn.store)
+	}
+	if err != nil {
+		return cn.logError("export archive", err)
+	}
+	cn.log.Debugf("export archive: exported %d keys", n)
+	return nil
+}
+
+// ImportArchive restores an archive written by ExportArchive with the same secret.
+// The mode sets how the keys that are already in the storage chest are handled
+// (SEE: Import). The archive is read into a temporary file and verified before
+// anything is written. If a checksum or the manifest does not match, an error
+// wrapping ErrInvalidArchive is returned. The secret is required in addition to
+// the reader to verify the archive (SEE: ExportArchive).
+func (cn *Chestnut) ImportArchive(r io.Reader, secret crypto.Secret, mode ImportMode) error {
+	cn.log.Infof("import archive: mode: %s", mode)
+	if err := cn.writable("import archive"); err != nil {
+		return err
+	}
+	// the conflicts and existing keys are checked before the import, so
+	// other writes wait until the import returns like they do for Rekey.
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	if err := validArchiveSecret(secret); err != nil {
+		return cn.logError("import archive", err)
+	}
+	tmp, err := os.CreateTemp("", "chestnut-archive-")
+	if err != nil {
+		return cn.logError("import archive", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if err = cn.readArchive(io.TeeReader(r, tmp), secret, nil); err != nil {
+		return cn.logError("import archive", err)
+	}
+	read := func(fn storage.BackupFunc) error {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return cn.readArchive(tmp, secret, fn)
+	}
+	n, err := cn.importBackup(read, mode)
+	if err != nil {
+		return cn.logError("import archive", err)
+	}
+	cn.log.Infof("import archive: imported %d keys", n)
+	return nil
+}
+
+// archiveWriter writes the records of an archive.
+type archiveWriter struct {
+	w   *bufio.Writer
+	sum hash.Hash
+	n   uint64
+	err error
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	aw := &archiveWriter{
+		w:   bufio.NewWriter(w),
+		sum: sha256.New(),
+	}
+	_, aw.err = aw.w.WriteString(archiveMagic)
+	if aw.err == nil {
+		aw.err = aw.w.WriteByte(archiveVersion)
+	}
+	return aw
+}
+
+// encodeArchiveID encodes the namespace and key of a record to be sealed.
+func encodeArchiveID(name string, key []byte) []byte {
+	id := binary.AppendUvarint(nil, uint64(len(name)))
+	id = append(id, name...)
+	return append(id, key...)
+}
+
+// decodeArchiveID decodes the namespace and key of a record.
+func decodeArchiveID(id []byte) (string, []byte, error) {
+	r := bytes.NewReader(id)
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, err
+	}
+	n := len(id) - r.Len()
+	if size > uint64(r.Len()) {
+		return "", nil, errors.New("invalid record name")
+	}
+	end := n + int(size)
+	return string(id[n:end]), id[end:], nil
+}
+
+// writeRecord writes the record and its checksum.
+func (aw *archiveWriter) writeRecord(id []byte, value []byte) error {
+	if aw.err != nil {
+		return aw.err
+	}
+	record := []byte{archiveRecord}
+	for _, field := range [][]byte{id, value} {
+		record = binary.AppendUvarint(record, uint64(len(field)))
+		record = append(record, field...)
+	}
+	checksum := sha256.Sum256(record)
+	aw.sum.Write(checksum[:])
+	if _, aw.err = aw.w.Write(record); aw.err != nil {
+		return aw.err
+	}
+	if _, aw.err = aw.w.Write(checksum[:]); aw.err != nil {
+		return aw.err
+	}
+	aw.n++
+	return nil
+}
+
+// closeArchive writes the sealed manifest and flushes the archive.
+func (cn *Chestnut) closeArchive(aw *archiveWriter, secret crypto.Secret) error {
+	if aw.err != nil {
+		return aw.err
+	}
+	m := &archiveManifest{
+		Version: archiveVersion,
+		Created: time.Now().UTC(),
+		Records: aw.n,
+		Salt:    make([]byte, sha256.Size),
+	}
+	if _, err := rand.Read(m.Salt); err != nil {
+		return err
+	}
+	digest, err := m.digest(secret, aw.sum.Sum(nil))
+	if err != nil {
+		return err
+	}
+	m.Digest = digest
+	b, err := jsoniter.Marshal(m)
+	if err != nil {
+		return err
+	}
+	sealed, err := cn.encrypt(b)
+	if err != nil {
+		return err
+	}
+	buf := binary.AppendUvarint([]byte{archiveEnd}, uint64(len(sealed)))
+	if _, err = aw.w.Write(append(buf, sealed...)); err != nil {
+		return err
+	}
+	return aw.w.Flush()
+}
+
+// readArchive verifies the archive in r and calls fn for each record if fn is not nil.
+// fn is called before the manifest is verified, so the archive must have already been
+// verified once if the records are being imported.
+func (cn *Chestnut) readArchive(r io.Reader, secret crypto.Secret, fn storage.BackupFunc) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(archiveMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return fmt.Errorf("%w: header not found", ErrInvalidArchive)
+	}
+	if v := header[len(archiveMagic)]; v != archiveVersion {
+		return fmt.Errorf("%w: unsupported version: %d", ErrInvalidArchive, v)
+	}
+	sum := sha256.New()
+	var n uint64
+	for {
+		tag, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: manifest not found: %s", ErrInvalidArchive, err)
+		}
+		if tag == archiveEnd {
+			return cn.verifyArchive(br, secret, sum.Sum(nil), n)
+		} else if tag != archiveRecord {
+			return fmt.Errorf("%w: record %d: unknown tag: %x", ErrInvalidArchive, n+1, tag)
+		}
+		record := []byte{tag}
+		fields := make([][]byte, 2)
+		for i := range fields {
+			if fields[i], err = readArchiveField(br); err != nil {
+				return fmt.Errorf("%w: record %d: %s", ErrInvalidArchive, n+1, err)
+			}
+			record = binary.AppendUvarint(record, uint64(len(fields[i])))
+			record = append(record, fields[i]...)
+		}
+		checksum := make([]byte, sha256.Size)
+		if _, err = io.ReadFull(br, checksum); err != nil {
+			return fmt.Errorf("%w: record %d: %s", ErrInvalidArchive, n+1, err)
+		}
+		if expected := sha256.Sum256(record); !hmac.Equal(checksum, expected[:]) {
+			return fmt.Errorf("%w: record %d: checksum mismatch", ErrInvalidArchive, n+1)
+		}
+		sum.Write(checksum)
+		n++
+		// the names are opened on every pass so a bad record fails before the import
+		id, err := cn.decryptSealed(fields[0])
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %s", ErrInvalidArchive, n, err)
+		}
+		name, key, err := decodeArchiveID(id)
+		if err != nil {
+			return fmt.Errorf("%w: record %d: %s", ErrInvalidArchive, n, err)
+		}
+		if fn == nil {
+			continue
+		}
+		if err = fn(name, key, fields[1]); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyArchive reads the sealed manifest and checks it against the records.
+func (cn *Chestnut) verifyArchive(br *bufio.Reader, secret crypto.Secret, sum []byte, n uint64) error {
+	sealed, err := readArchiveField(br)
+	if err != nil {
+		return fmt.Errorf("%w: manifest: %s", ErrInvalidArchive, err)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		return fmt.Errorf("%w: data after manifest", ErrInvalidArchive)
+	}
+	b, err := cn.decryptSealed(sealed)
+	if err != nil {
+		return fmt.Errorf("%w: manifest: %s", ErrInvalidArchive, err)
+	}
+	m := new(archiveManifest)
+	if err = jsoniter.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("%w: manifest: %s", ErrInvalidArchive, err)
+	}
+	if m.Version != archiveVersion {
+		return fmt.Errorf("%w: unsupported manifest version: %d", ErrInvalidArchive, m.Version)
+	}
+	if m.Records != n {
+		return fmt.Errorf("%w: expected %d records, found %d", ErrInvalidArchive, m.Records, n)
+	}
+	digest, err := m.digest(secret, sum)
+	if err != nil {
+		return fmt.Errorf("%w: manifest: %s", ErrInvalidArchive, err)
+	}
+	if !hmac.Equal(m.Digest, digest) {
+		return fmt.Errorf("%w: digest mismatch", ErrInvalidArchive)
+	}
+	return nil
+}
+
+// readArchiveField reads a uvarint length prefixed field.
+func readArchiveField(br *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxArchiveField {
+		return nil, fmt.Errorf("field too large: %d", size)
+	}
+	// the size is read from the archive before it is verified, so the field
+	// is only allocated as it is read instead of all at once up front.
+	field := &bytes.Buffer{}
+	n, err := field.ReadFrom(io.LimitReader(br, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(n) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return field.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package chestnut
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"strings"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+const archiveName = "archive-namespace"
+
+var archiveSecret = crypto.TextSecret("i-am-an-archive-secret")
+
+func (ts *ChestnutTestSuite) exportArchive() []byte {
+	err := ts.cn.Put(archiveName, []byte("a"), []byte(testValue))
+	ts.Require().NoError(err)
+	err = ts.cn.Save(archiveName, []byte("b"), &objectSrc)
+	ts.Require().NoError(err)
+	err = ts.cn.PutStream(archiveName, []byte("stream"), strings.NewReader(lorumIpsum))
+	ts.Require().NoError(err)
+	err = ts.cn.Put(archiveName, []byte("expired"), []byte(testValue), WithTTL(1))
+	ts.Require().NoError(err)
+	buf := &bytes.Buffer{}
+	err = ts.cn.ExportArchive(buf, nil)
+	ts.Error(err)
+	err = ts.cn.ExportArchive(buf, crypto.TextSecret(""))
+	ts.Error(err)
+	err = ts.cn.ExportArchive(buf, archiveSecret)
+	ts.Require().NoError(err)
+	ts.True(bytes.HasPrefix(buf.Bytes(), []byte(archiveMagic)))
+	ts.False(bytes.Contains(buf.Bytes(), []byte(testValue)))
+	ts.False(bytes.Contains(buf.Bytes(), []byte(archiveName)))
+	return buf.Bytes()
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Archive() {
+	archive := ts.exportArchive()
+	expected, err := ts.cn.ListAll()
+	ts.Require().NoError(err)
+	for _, storeFunc := range []StoreFunc{nutsStore, boltStore} {
+		store := storeFunc(ts.T(), ts.T().TempDir())
+		cn := NewChestnut(store, encryptorOpt)
+		err = cn.Open()
+		ts.Require().NoError(err)
+		err = cn.ImportArchive(bytes.NewReader(archive), archiveSecret, ImportMerge)
+		ts.NoError(err)
+		keyMap, err := cn.ListAll()
+		ts.NoError(err)
+		ts.Equal(len(expected), len(keyMap))
+		for name, keys := range expected {
+			ts.ElementsMatch(toStrings(keys), toStrings(keyMap[name]), name)
+		}
+		value, err := cn.Get(archiveName, []byte("a"))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+		obj := &TObject{}
+		err = cn.Load(archiveName, []byte("b"), obj)
+		ts.NoError(err)
+		ts.Equal(&objOut, obj)
+		buf := &bytes.Buffer{}
+		err = cn.GetStream(archiveName, []byte("stream"), buf)
+		ts.NoError(err)
+		ts.Equal(lorumIpsum, buf.String())
+		err = cn.Close()
+		ts.NoError(err)
+	}
+	// stores without transactions
+	store := &nonIterableStore{ts.storeFunc(ts.T(), ts.T().TempDir())}
+	cn := NewChestnut(store, encryptorOpt)
+	err = cn.Open()
+	ts.Require().NoError(err)
+	defer func() {
+		err = cn.Close()
+		ts.NoError(err)
+	}()
+	err = cn.ImportArchive(bytes.NewReader(archive), archiveSecret, ImportMerge)
+	ts.NoError(err)
+	value, err := cn.Get(archiveName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	buf := &bytes.Buffer{}
+	err = cn.ExportArchive(buf, archiveSecret)
+	ts.NoError(err)
+	err = ts.cn.Put(archiveName, []byte("c"), []byte(testValue))
+	ts.NoError(err)
+	err = ts.cn.ImportArchive(buf, archiveSecret, ImportReplace)
+	ts.NoError(err)
+	has, err := ts.cn.Has(archiveName, []byte("c"))
+	ts.NoError(err)
+	ts.False(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ArchiveInvalid() {
+	archive := ts.exportArchive()
+	err := ts.cn.Delete(archiveName, []byte("a"))
+	ts.Require().NoError(err)
+	flip := func(i int) []byte {
+		b := append([]byte{}, archive...)
+		b[i] ^= 0xff
+		return b
+	}
+	tests := map[string][]byte{
+		"empty":     {},
+		"header":    flip(0),
+		"version":   flip(len(archiveMagic)),
+		"record":    flip(len(archiveMagic) + 8),
+		"manifest":  flip(len(archive) - 1),
+		"truncated": archive[:len(archive)/2],
+		"no end":    archive[:bytes.LastIndexByte(archive, archiveEnd)],
+		"trailing":  append(append([]byte{}, archive...), 0),
+	}
+	for name, test := range tests {
+		err = ts.cn.ImportArchive(bytes.NewReader(test), archiveSecret, ImportMerge)
+		ts.ErrorIs(err, ErrInvalidArchive, name)
+	}
+	// a large field size is not allocated before the field is read
+	huge := append([]byte(archiveMagic), archiveVersion, archiveRecord)
+	huge = binary.AppendUvarint(huge, maxArchiveField)
+	huge = append(huge, make([]byte, 1024)...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = ts.cn.ImportArchive(bytes.NewReader(huge), archiveSecret, ImportMerge)
+	runtime.ReadMemStats(&after)
+	ts.ErrorIs(err, ErrInvalidArchive)
+	ts.Less(after.TotalAlloc-before.TotalAlloc, uint64(maxArchiveField/16))
+	secret := crypto.TextSecret("i-am-a-different-secret")
+	cn := NewChestnut(ts.cn.store, WithEncryptor(encryptor.NewAESEncryptor(crypto.Key256, aes.CFB, secret)))
+	err = cn.ImportArchive(bytes.NewReader(archive), archiveSecret, ImportMerge)
+	ts.ErrorIs(err, ErrInvalidArchive)
+	err = ts.cn.ImportArchive(bytes.NewReader(archive), secret, ImportMerge)
+	ts.ErrorIs(err, ErrInvalidArchive)
+	err = ts.cn.ImportArchive(bytes.NewReader(archive), nil, ImportMerge)
+	ts.Error(err)
+	// nothing was imported
+	has, err := ts.cn.Has(archiveName, []byte("a"))
+	ts.NoError(err)
+	ts.False(has)
+	err = ts.cn.ImportArchive(bytes.NewReader(archive), archiveSecret, ImportMode(-1))
+	ts.Error(err)
+	err = ts.cn.ImportArchive(bytes.NewReader(archive), archiveSecret, ImportMerge)
+	ts.NoError(err)
+	has, err = ts.cn.Has(archiveName, []byte("a"))
+	ts.NoError(err)
+	ts.True(has)
+}
@@ -0,0 +1,207 @@
+package chestnut
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// CacheStats are the statistics of the decrypted value cache. SEE: WithCache.
+type CacheStats struct {
+	// Hits is the number of calls to Get that were answered from the cache.
+	Hits uint64
+	// Misses is the number of calls to Get that had to decrypt the value.
+	Misses uint64
+	// Evictions is the number of values removed to stay within the limits of the cache.
+	Evictions uint64
+	// Entries is the number of values in the cache.
+	Entries int
+	// Bytes is the size of the values in the cache.
+	Bytes int
+}
+
+// cacheEntry is a decrypted value in the cache.
+type cacheEntry struct {
+	key    string
+	value  []byte
+	expiry time.Time
+}
+
+// valueCache is an LRU cache of decrypted values. The values are copied in and
+// out of the cache, so a cached value is zeroed when it is removed. A nil
+// valueCache is disabled and never finds a value.
+type valueCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	lru        *list.List
+	items      map[string]*list.Element
+	size       int
+	// gen is incremented each time values are removed, so a value that was
+	// decrypted before it was removed is not added to the cache after.
+	gen   uint64
+	stats CacheStats
+}
+
+func newValueCache(maxEntries int, maxBytes int, ttl time.Duration) *valueCache {
+	return &valueCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		lru:        list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// cacheKey returns the cache key of name.key.
+func cacheKey(name string, key []byte) string {
+	ck := binary.AppendUvarint(nil, uint64(len(name)))
+	ck = append(ck, name...)
+	return string(append(ck, key...))
+}
+
+// get returns a copy of the value at name.key if it is in the cache.
+func (c *valueCache) get(name string, key []byte) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[cacheKey(name, key)]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if expired(entry.expiry) {
+		c.removeElement(el)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return append([]byte{}, entry.value...), true
+}
+
+// generation returns the current generation of the cache. It should
+// be called before the value that will be added is read from the store.
+func (c *valueCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add adds a copy of the value at name.key to the cache. The value expires at
+// expiry or after the ttl of the cache, whichever is first. If any values were
+// removed since gen, the value may be stale and it is not added.
+func (c *valueCache) add(name string, key []byte, value []byte, expiry time.Time, gen uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen || (c.maxBytes > 0 && len(value) > c.maxBytes) {
+		return
+	}
+	if c.ttl > 0 {
+		if ttlExpiry := time.Now().Add(c.ttl); expiry.IsZero() || ttlExpiry.Before(expiry) {
+			expiry = ttlExpiry
+		}
+	}
+	ck := cacheKey(name, key)
+	if el, ok := c.items[ck]; ok {
+		c.removeElement(el)
+	}
+	entry := &cacheEntry{key: ck, value: append([]byte{}, value...), expiry: expiry}
+	c.items[ck] = c.lru.PushFront(entry)
+	c.size += len(entry.value)
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.size > c.maxBytes) {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes the value at name.key from the cache.
+func (c *valueCache) remove(name string, key []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[cacheKey(name, key)]; ok {
+		c.removeElement(el)
+	}
+}
+
+// purge removes all the values from the cache.
+func (c *valueCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for el := c.lru.Back(); el != nil; el = c.lru.Back() {
+		c.removeElement(el)
+	}
+}
+
+// statistics returns the statistics of the cache.
+func (c *valueCache) statistics() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.size
+	return stats
+}
+
+// removeElement removes the element from the cache and zeroes its value.
+func (c *valueCache) removeElement(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= len(entry.value)
+	clear(entry.value)
+	entry.value = nil
+}
+
+// CacheStats returns the statistics of the decrypted value cache. If the
+// cache is not enabled (SEE: WithCache), the statistics are empty.
+func (cn *Chestnut) CacheStats() CacheStats {
+	return cn.cache.statistics()
+}
+
+// getCached returns the plaintext at key from the cache. If the plaintext is not in the
+// cache, the ciphertext is decrypted and the plaintext is added to the cache.
+func (cn *Chestnut) getCached(name string, key []byte) ([]byte, error) {
+	if plaintext, ok := cn.cache.get(name, key); ok {
+		cn.log.Debugf("get: cached plaintext at key: %s", key)
+		return plaintext, nil
+	}
+	gen := cn.cache.generation()
+	plaintext, expiry, err := cn.getPlaintext(cn.store, name, key)
+	if err != nil {
+		return nil, err
+	}
+	cn.cache.add(name, key, plaintext, expiry, gen)
+	return plaintext, nil
+}
+
+// publish removes the values of the events from the cache
+// and publishes the events to the subscribers.
+func (cn *Chestnut) publish(events ...watchEvent) {
+	for _, e := range events {
+		cn.cache.remove(e.Namespace, e.Key)
+	}
+	cn.watch.publish(events...)
+}
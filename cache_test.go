@@ -0,0 +1,127 @@
+package chestnut
+
+import (
+	"time"
+)
+
+const cacheName = "cache-namespace"
+
+func (ts *ChestnutTestSuite) cacheGet(cn *Chestnut, key string) string {
+	value, err := cn.Get(cacheName, []byte(key))
+	ts.NoError(err)
+	return string(value)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Cache() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithCache(10, 0, 0))
+	ts.Equal(CacheStats{}, cn.CacheStats())
+	err := cn.Put(cacheName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	ts.Equal(testValue, ts.cacheGet(cn, "a"))
+	value, err := cn.Get(cacheName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	ts.Equal(CacheStats{Hits: 1, Misses: 1, Entries: 1, Bytes: len(testValue)}, cn.CacheStats())
+	// the cached value is a copy
+	value[0] = 'x'
+	ts.Equal(testValue, ts.cacheGet(cn, "a"))
+	// writes remove the value from the cache
+	err = cn.Put(cacheName, []byte("a"), []byte("changed"))
+	ts.NoError(err)
+	ts.Equal("changed", ts.cacheGet(cn, "a"))
+	err = cn.Update(func(tx *Tx) error {
+		return tx.Put(cacheName, []byte("a"), []byte(testValue))
+	})
+	ts.NoError(err)
+	ts.Equal(testValue, ts.cacheGet(cn, "a"))
+	err = cn.Delete(cacheName, []byte("a"))
+	ts.NoError(err)
+	_, err = cn.Get(cacheName, []byte("a"))
+	ts.Error(err)
+	stats := cn.CacheStats()
+	ts.Equal(uint64(2), stats.Hits)
+	ts.Equal(uint64(4), stats.Misses)
+	ts.Equal(0, stats.Entries)
+	// writes made by another storage chest are not seen
+	err = cn.Put(cacheName, []byte("b"), []byte(testValue))
+	ts.NoError(err)
+	ts.Equal(testValue, ts.cacheGet(cn, "b"))
+	err = ts.cn.Put(cacheName, []byte("b"), []byte("changed"))
+	ts.NoError(err)
+	ts.Equal(testValue, ts.cacheGet(cn, "b"))
+	// values are not cached without the option
+	ts.Equal("changed", ts.cacheGet(ts.cn, "b"))
+	ts.Equal(CacheStats{}, ts.cn.CacheStats())
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CacheEviction() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithCache(2, 0, 0))
+	for _, key := range []string{"a", "b", "c"} {
+		err := cn.Put(cacheName, []byte(key), []byte(testValue))
+		ts.NoError(err)
+	}
+	ts.cacheGet(cn, "a")
+	ts.cacheGet(cn, "b")
+	ts.cacheGet(cn, "a")
+	evicted := cn.cache.items[cacheKey(cacheName, []byte("b"))].Value.(*cacheEntry).value
+	// b is the least recently used
+	ts.cacheGet(cn, "c")
+	ts.Equal(make([]byte, len(testValue)), evicted)
+	stats := cn.CacheStats()
+	ts.Equal(uint64(1), stats.Evictions)
+	ts.Equal(2, stats.Entries)
+	ts.cacheGet(cn, "a")
+	ts.Equal(stats.Hits+1, cn.CacheStats().Hits)
+	ts.cacheGet(cn, "b")
+	ts.Equal(stats.Misses+1, cn.CacheStats().Misses)
+	// the size of the values is limited
+	cn = NewChestnut(ts.cn.store, encryptorOpt, WithCache(0, len(testValue)*2, 0))
+	for _, key := range []string{"a", "b", "c"} {
+		ts.cacheGet(cn, key)
+	}
+	stats = cn.CacheStats()
+	ts.Equal(2, stats.Entries)
+	ts.Equal(len(testValue)*2, stats.Bytes)
+	ts.Equal(uint64(1), stats.Evictions)
+	// values larger than the cache are not cached
+	err := cn.Put(cacheName, []byte("d"), []byte(lorumIpsum))
+	ts.NoError(err)
+	ts.Equal(lorumIpsum, ts.cacheGet(cn, "d"))
+	ts.Equal(lorumIpsum, ts.cacheGet(cn, "d"))
+	stats.Misses += 2
+	ts.Equal(stats, cn.CacheStats())
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CacheExpiry() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithCache(10, 0, 10*time.Millisecond))
+	err := cn.Put(cacheName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	ts.cacheGet(cn, "a")
+	ts.cacheGet(cn, "a")
+	time.Sleep(20 * time.Millisecond)
+	ts.cacheGet(cn, "a")
+	ts.Equal(uint64(2), cn.CacheStats().Misses)
+	// values expire from the cache with the record
+	cn = NewChestnut(ts.cn.store, encryptorOpt, WithCache(10, 0, 0))
+	err = cn.Put(cacheName, []byte("b"), []byte(testValue), WithTTL(testTTL))
+	ts.NoError(err)
+	ts.cacheGet(cn, "b")
+	time.Sleep(testTTL)
+	_, err = cn.Get(cacheName, []byte("b"))
+	ts.ErrorIs(err, ErrNotFound)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CacheInvalid() {
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithCache(-1, 0, 0))
+	})
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithCache(0, -1, 0))
+	})
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithCache(0, 0, 0))
+	})
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithCache(1, 0, -1))
+	})
+}
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jrapoport/chestnut/encoding/compress"
 	"github.com/jrapoport/chestnut/encoding/compress/zstd"
 	"github.com/jrapoport/chestnut/encoding/json"
 	"github.com/jrapoport/chestnut/encoding/json/encoders/secure"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
 	"github.com/jrapoport/chestnut/log"
 	"github.com/jrapoport/chestnut/storage"
 	"github.com/jrapoport/chestnut/value"
@@ -18,9 +22,20 @@ import (
 // as chained encryption, independently secured secrets, sparse encryption, and hashing.
 // For more detail, SEE: https://github.com/jrapoport/chestnut/blob/master/README.md
 type Chestnut struct {
-	opts  ChestOptions
-	store storage.Storage
-	log   log.Logger
+	opts     ChestOptions
+	store    storage.Storage
+	log      log.Logger
+	reaper   chan struct{}
+	reaperWG sync.WaitGroup
+	watch    *watcher
+	index    []byte
+	cache    *valueCache
+	// writeMu is held for reading by writes, and for writing by Rekey.
+	writeMu sync.RWMutex
+	// cryptMu guards opts.encryptor and rekeyFrom.
+	cryptMu sync.RWMutex
+	// rekeyFrom holds the encryptors of an incomplete rekey, newest first.
+	rekeyFrom []crypto.Encryptor
 }
 
 // NewChestnut is used to create a new chestnut encrypted store.
@@ -29,11 +44,31 @@ func NewChestnut(store storage.Storage, opt ...ChestOption) *Chestnut {
 	//logger := storage.LoggerFromStore(store, logName)
 	opts := applyOptions(DefaultChestOptions, opt...)
 	logger := log.Named(opts.log, logName)
-	cn := &Chestnut{opts, store, logger}
+	cn := &Chestnut{opts: opts, store: store, log: logger}
 	if err := cn.validConfig(); err != nil {
 		logger.Panic(err)
 		return nil
 	}
+	cn.watch = newWatcher(opts.watchHistory)
+	if opts.obfuscation != nil {
+		store, err := newObfuscatedStore(store, opts.obfuscation, logger)
+		if err != nil {
+			logger.Panic(err)
+			return nil
+		}
+		cn.store = store
+	}
+	if opts.index != nil {
+		index, err := newIndexKey(opts.index)
+		if err != nil {
+			logger.Panic(err)
+			return nil
+		}
+		cn.index = index
+	}
+	if opts.cache {
+		cn.cache = newValueCache(opts.cacheEntries, opts.cacheBytes, opts.cacheTTL)
+	}
 	return cn
 }
 
@@ -56,6 +91,24 @@ func (cn *Chestnut) validConfig() error {
 	if !cn.opts.compression.Valid() {
 		return errors.New("invalid compression format")
 	}
+	if cn.opts.reapInterval < 0 {
+		return errors.New("invalid reaper interval")
+	}
+	if cn.opts.chunkSize < 0 {
+		return errors.New("invalid chunk size")
+	}
+	if cn.opts.watchHistory < 0 {
+		return errors.New("invalid watch history size")
+	}
+	if cn.opts.cache && (cn.opts.cacheEntries < 0 || cn.opts.cacheBytes < 0) {
+		return errors.New("invalid cache size")
+	}
+	if cn.opts.cache && cn.opts.cacheEntries == 0 && cn.opts.cacheBytes == 0 {
+		return errors.New("cache size required")
+	}
+	if cn.opts.cache && cn.opts.cacheTTL < 0 {
+		return errors.New("invalid cache ttl")
+	}
 	return nil
 }
 
@@ -80,60 +133,135 @@ func (cn *Chestnut) Open() error {
 	if !cn.opts.overwrites {
 		cn.log.Info("overwrites are disabled")
 	}
+	if cn.opts.readOnly {
+		cn.log.Info("storage chest is read-only")
+	}
+	if err := cn.openRekey(); err != nil {
+		_ = cn.store.Close()
+		return cn.logError("open", err)
+	}
+	cn.startReaper()
 	return nil
 }
 
 // Put encrypts the plaintext and stores it at key.
-func (cn *Chestnut) Put(name string, key []byte, plaintext []byte) error {
+func (cn *Chestnut) Put(name string, key []byte, plaintext []byte, opt ...PutOption) error {
+	if err := cn.writable("put"); err != nil {
+		return err
+	}
+	defer cn.lockWrites()()
+	var ciphertext []byte
+	err := cn.indexed(func(tx storage.Tx) (err error) {
+		ciphertext, err = cn.put(tx, name, key, plaintext, opt...)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	cn.publish(putEvent(name, key, ciphertext))
+	return nil
+}
+
+// put encrypts the plaintext and stores it at key in tx. put returns the stored ciphertext.
+func (cn *Chestnut) put(tx storage.Tx, name string, key []byte, plaintext []byte,
+	opt ...PutOption) ([]byte, error) {
 	cn.log.Debugf("put: %d plaintext bytes to key: %s", len(plaintext), key)
 	// the store will make these same checks, but encryption
 	// is expensive, so we are going to do them upfront here.
 	if err := storage.ValidKey(name, key); err != nil {
-		return cn.logError("put", err)
+		return nil, cn.logError("put", err)
 	} else if len(plaintext) <= 0 {
 		err = errors.New("plaintext cannot be empty")
-		return cn.logError("put", err)
-	} else if err = cn.CanPut(name, key); err != nil {
-		return cn.logError("put", err)
+		return nil, cn.logError("put", err)
+	} else if err = cn.canPut(tx, name, key); err != nil {
+		return nil, cn.logError("put", err)
 	}
 	if cn.opts.compression != compress.None {
 		var err error
 		if plaintext, err = cn.compress(plaintext); err != nil {
-			return cn.logError("put", err)
+			return nil, cn.logError("put", err)
 		}
 	}
+	expiry := applyPutOptions(PutOptions{}, opt...).expiry()
 	cn.log.Debugf("put: encrypt %d bytes", len(plaintext))
-	cipherText, err := cn.encrypt(plaintext)
+	cipherText, err := cn.encrypt(sealExpiry(plaintext, expiry))
 	if err != nil {
-		return cn.logError("put", err)
+		return nil, cn.logError("put", err)
 	}
 	cn.log.Debugf("put: encrypted %d bytes", len(cipherText))
-	return cn.logError("", cn.store.Put(name, key, cipherText))
+	// the plaintext replaces a stream
+	if err = cn.deleteStream(tx, name, key); err != nil {
+		return nil, cn.logError("put", err)
+	}
+	if err = cn.putExpiry(tx, name, key, cipherText, expiry); err != nil {
+		return nil, cn.logError("", err)
+	}
+	// the plaintext replaces an indexed struct
+	if err = cn.unindex(tx, name, key); err != nil {
+		return nil, cn.logError("put", err)
+	}
+	return cipherText, nil
 }
 
-// Get decrypts the ciphertext at key and returns the plaintext.
+// Get decrypts the ciphertext at key and returns the plaintext. If the cache
+// is enabled (SEE: WithCache), the plaintext is returned from the cache.
 func (cn *Chestnut) Get(name string, key []byte) ([]byte, error) {
+	if cn.cache != nil {
+		return cn.getCached(name, key)
+	}
+	return cn.get(cn.store, name, key)
+}
+
+// get decrypts the ciphertext at key in tx and returns the plaintext.
+func (cn *Chestnut) get(tx storage.Tx, name string, key []byte) ([]byte, error) {
+	plaintext, _, err := cn.getPlaintext(tx, name, key)
+	return plaintext, err
+}
+
+// getPlaintext decrypts the ciphertext at key in tx and returns
+// the plaintext and its expiry time.
+func (cn *Chestnut) getPlaintext(tx storage.Tx, name string, key []byte) ([]byte, time.Time, error) {
 	cn.log.Debugf("get: ciphertext at key: %s", key)
-	ciphertext, err := cn.store.Get(name, key)
+	ciphertext, expiry, err := cn.getExpiry(tx, name, key)
 	if err != nil {
-		return nil, cn.logError("", err)
+		return nil, expiry, cn.logError("", err)
+	}
+	if isStream(ciphertext) {
+		err = errors.New("value is a stream, use GetStream")
+		return nil, expiry, cn.logError("get", err)
 	}
 	cn.log.Debugf("get: decrypt %d bytes", len(ciphertext))
 	plaintext, err := cn.decrypt(ciphertext)
 	if err != nil {
-		return nil, cn.logError("get", err)
+		return nil, expiry, cn.logError("get", err)
 	}
 	cn.log.Debugf("put: decrypted %d bytes", len(plaintext))
 	// decompress will check to see if the data is compressed.
 	// if sit is not compressed, it returns the buffer.
 	if plaintext, err = cn.decompress(plaintext); err != nil {
-		return nil, cn.logError("get", err)
+		return nil, expiry, cn.logError("get", err)
 	}
-	return plaintext, nil
+	return plaintext, expiry, nil
 }
 
 // Save encrypts the struct in v and stores the encoded result at key.
-func (cn *Chestnut) Save(name string, key []byte, v interface{}) error {
+func (cn *Chestnut) Save(name string, key []byte, v interface{}, opt ...PutOption) error {
+	if err := cn.writable("save"); err != nil {
+		return err
+	}
+	defer cn.lockWrites()()
+	err := cn.indexed(func(tx storage.Tx) error {
+		return cn.save(tx, name, key, v, opt...)
+	})
+	if err != nil {
+		return err
+	}
+	cn.publish(putEvent(name, key, nil))
+	return nil
+}
+
+// save encrypts the struct in v and stores the encoded result at key in tx.
+func (cn *Chestnut) save(tx storage.Tx, name string, key []byte, v interface{}, opt ...PutOption) error {
 	cn.log.Debugf("save: %v value to key: %s", reflect.TypeOf(v), key)
 	// the store will make these same checks, but encryption
 	// is expensive, so we are going to do them upfront here.
@@ -142,16 +270,24 @@ func (cn *Chestnut) Save(name string, key []byte, v interface{}) error {
 	} else if v == nil {
 		err = errors.New("value cannot be nil")
 		return cn.logError("save", err)
-	} else if err = cn.CanPut(name, key); err != nil {
+	} else if err = cn.canPut(tx, name, key); err != nil {
 		return cn.logError("save", err)
 	}
+	expiry := applyPutOptions(PutOptions{}, opt...).expiry()
 	cn.log.Debugf("save: encrypt %v value", reflect.TypeOf(v))
-	ciphertext, err := cn.marshal(v)
+	ciphertext, err := cn.marshal(v, expiry)
 	if err != nil {
 		return cn.logError("save", err)
 	}
 	cn.log.Debugf("save: put %d encrypted bytes", len(ciphertext))
-	if err = cn.store.Put(name, key, ciphertext); err != nil {
+	// the struct replaces a stream
+	if err = cn.deleteStream(tx, name, key); err != nil {
+		return cn.logError("save", err)
+	}
+	if err = cn.putExpiry(tx, name, key, ciphertext, expiry); err != nil {
+		return cn.logError("save", err)
+	}
+	if err = cn.indexStruct(tx, name, key, v); err != nil {
 		return cn.logError("save", err)
 	}
 	cn.log.Debugf("save: encrypted %v value", reflect.TypeOf(v))
@@ -161,7 +297,7 @@ func (cn *Chestnut) Save(name string, key []byte, v interface{}) error {
 // Load decrypts the struct at key and returns the decoded result in v.
 func (cn *Chestnut) Load(name string, key []byte, v interface{}) error {
 	cn.log.Debugf("load: %v value at key: %s", reflect.TypeOf(v), key)
-	if err := cn.load(name, key, v, false); err != nil {
+	if err := cn.load(cn.store, name, key, v, false); err != nil {
 		return cn.logError("load", err)
 	}
 	cn.log.Debugf("load: decrypted %v value", reflect.TypeOf(v))
@@ -175,7 +311,7 @@ func (cn *Chestnut) Load(name string, key []byte, v interface{}) error {
 // have been saved with secure fields to be loaded as sparse structs by Sparse.
 func (cn *Chestnut) Sparse(name string, key []byte, v interface{}) error {
 	cn.log.Debugf("sparse: %v value at key: %s", reflect.TypeOf(v), key)
-	if err := cn.load(name, key, v, true); err != nil {
+	if err := cn.load(cn.store, name, key, v, true); err != nil {
 		return cn.logError("sparse", err)
 	}
 	cn.log.Debugf("sparse: decrypted sparse %v value", reflect.TypeOf(v))
@@ -222,8 +358,21 @@ func (cn *Chestnut) SparseKeyed(v value.Keyed) error {
 // Has checks for a key in the storage chest. Has returns true
 // if the key is found, otherwise false.
 func (cn *Chestnut) Has(name string, key []byte) (bool, error) {
+	return cn.has(cn.store, name, key)
+}
+
+// has checks for a key in tx.
+func (cn *Chestnut) has(tx storage.Tx, name string, key []byte) (bool, error) {
 	cn.log.Debugf("has: key: %s", key)
-	has, err := cn.store.Has(name, key)
+	has, err := tx.Has(name, key)
+	if has {
+		// an expired key is not found
+		_, err = cn.getExpiring(tx, name, key)
+		has = err == nil
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	}
 	cn.log.Debugf("has: key %s: %t", key, has)
 	return has, cn.logError("", err)
 }
@@ -231,6 +380,11 @@ func (cn *Chestnut) Has(name string, key []byte) (bool, error) {
 // CanPut returns nil if writing to key is ok. If overwrites
 // are disabled and the key exists, ErrForbidden is returned.
 func (cn *Chestnut) CanPut(name string, key []byte) error {
+	return cn.canPut(cn.store, name, key)
+}
+
+// canPut returns nil if writing to key in tx is ok.
+func (cn *Chestnut) canPut(tx storage.Tx, name string, key []byte) error {
 	cn.log.Debugf("can put: key: %s", key)
 	if err := storage.ValidKey(name, key); err != nil {
 		return cn.logError("can put", err)
@@ -245,7 +399,7 @@ func (cn *Chestnut) CanPut(name string, key []byte) error {
 	// if the key was invalid, but since we already check that above
 	// we can safely assume that's not the error. since we expect an error
 	// when the key is not found has Has will log it, we can ignore it.
-	if has, _ := cn.Has(name, key); has {
+	if has, _ := cn.has(tx, name, key); has {
 		return cn.logError("can put", ErrForbidden)
 	}
 	// we didn't find the key and there is no error, this is not an overwrite.
@@ -253,18 +407,82 @@ func (cn *Chestnut) CanPut(name string, key []byte) error {
 	return nil
 }
 
-// Delete removes a key from the storage chest.
+// Delete removes a key from the storage chest. If the key is a stream,
+// the chunks of the stream are removed too.
 func (cn *Chestnut) Delete(name string, key []byte) error {
 	cn.log.Debugf("delete: key: %s", key)
-	return cn.logError("", cn.store.Delete(name, key))
+	if err := cn.writable("delete"); err != nil {
+		return err
+	}
+	defer cn.lockWrites()()
+	var stream *streamManifest
+	if value, err := cn.store.Get(name, key); err == nil {
+		if value, _ = decodeExpiry(value); isStream(value) {
+			stream, _ = cn.decodeStreamManifest(value)
+		}
+	}
+	err := cn.indexed(func(tx storage.Tx) error {
+		if err := tx.Delete(name, key); err != nil {
+			return err
+		}
+		return cn.unindex(tx, name, key)
+	})
+	if err != nil {
+		return cn.logError("", err)
+	}
+	if stream != nil {
+		cn.deleteChunks(stream)
+	}
+	cn.publish(deleteEvent(name, key))
+	return nil
 }
 
 // List returns a list of keys in the namespace.
 func (cn *Chestnut) List(namespace string) ([][]byte, error) {
+	return cn.list(cn.store, namespace)
+}
+
+// list returns a list of keys in the namespace in tx.
+func (cn *Chestnut) list(tx storage.Tx, namespace string) ([][]byte, error) {
 	cn.log.Infof("list: all keys")
-	keys, err := cn.store.List(namespace)
-	cn.log.Debugf("list: found %d keys: %s", len(keys), keys)
-	return keys, cn.logError("", err)
+	keys, err := tx.List(namespace)
+	if err != nil {
+		return nil, cn.logError("", err)
+	}
+	list := cn.unexpired(tx, namespace, keys)
+	cn.log.Debugf("list: found %d keys: %s", len(list), list)
+	return list, nil
+}
+
+// ListAll returns a mapped list of all keys in the storage chest. The
+// namespaces reserved by the storage chest are not included.
+func (cn *Chestnut) ListAll() (map[string][][]byte, error) {
+	cn.log.Infof("list all: all keys")
+	keyMap, err := cn.store.ListAll()
+	if err != nil {
+		return nil, cn.logError("list all", err)
+	}
+	for name, keys := range keyMap {
+		if isReserved(name) {
+			delete(keyMap, name)
+			continue
+		}
+		keyMap[name] = cn.unexpired(cn.store, name, keys)
+	}
+	cn.log.Debugf("list all: found %d namespaces", len(keyMap))
+	return keyMap, nil
+}
+
+// unexpired removes the expired keys from keys.
+func (cn *Chestnut) unexpired(tx storage.Tx, namespace string, keys [][]byte) [][]byte {
+	list := keys[:0]
+	for _, key := range keys {
+		if _, err := cn.getExpiring(tx, namespace, key); errors.Is(err, ErrNotFound) {
+			continue
+		}
+		list = append(list, key)
+	}
+	return list
 }
 
 // Export saves a copy of the storage chest to directory at path.
@@ -276,6 +494,9 @@ func (cn *Chestnut) Export(path string) error {
 // Close the storage chest
 func (cn *Chestnut) Close() error {
 	cn.log.Info("closing storage chest")
+	cn.stopReaper()
+	cn.watch.close()
+	cn.cache.purge()
 	if err := cn.store.Close(); err != nil {
 		return cn.logError("close", err)
 	}
@@ -296,22 +517,53 @@ func (cn *Chestnut) SetLogger(l log.Logger) {
 	cn.log = l
 }
 
-// load decrypts the secure or sparse value at key and stores the result in v.
-func (cn *Chestnut) load(name string, key []byte, v interface{}, sparse bool) error {
+// load decrypts the secure or sparse value at key in tx and stores the result in v.
+func (cn *Chestnut) load(tx storage.Tx, name string, key []byte, v interface{}, sparse bool) error {
 	if v == nil {
 		return errors.New("value cannot be nil")
 	}
-	ciphertext, err := cn.store.Get(name, key)
+	ciphertext, err := cn.getExpiring(tx, name, key)
 	if err != nil {
 		return err
 	}
 	return cn.unmarshal(ciphertext, v, sparse)
 }
 
+// encryptor returns the encryptor of the storage chest.
+func (cn *Chestnut) encryptor() crypto.Encryptor {
+	cn.cryptMu.RLock()
+	defer cn.cryptMu.RUnlock()
+	return cn.opts.encryptor
+}
+
+// openCiphertext decrypts the ciphertext with the encryptor. If the storage chest
+// is being rekeyed, or a rekey did not complete, the ciphertext is decrypted with
+// the encryptors it was rekeyed from if the encryptor cannot decrypt it.
+func (cn *Chestnut) openCiphertext(ciphertext []byte) ([]byte, error) {
+	cn.cryptMu.RLock()
+	e, from := cn.opts.encryptor, cn.rekeyFrom
+	cn.cryptMu.RUnlock()
+	plaintext, err := e.Decrypt(ciphertext)
+	for i := 0; err != nil && i < len(from); i++ {
+		var fromErr error
+		if plaintext, fromErr = from[i].Decrypt(ciphertext); fromErr == nil {
+			err = nil
+		}
+	}
+	return plaintext, err
+}
+
+// lockWrites blocks while the storage chest is being rekeyed or imported, and returns
+// the func that releases the lock.
+func (cn *Chestnut) lockWrites() func() {
+	cn.writeMu.RLock()
+	return cn.writeMu.RUnlock
+}
+
 // encrypt returns the plaintext data as ciphertext.
 func (cn *Chestnut) encrypt(plaintext []byte) (ciphertext []byte, err error) {
 	cn.log.Debugf("encrypt: encrypting %d bytes", len(plaintext))
-	ciphertext, err = cn.opts.encryptor.Encrypt(plaintext)
+	ciphertext, err = cn.encryptor().Encrypt(plaintext)
 	if err != nil {
 		err = cn.logError("encrypt", err)
 		return
@@ -320,10 +572,26 @@ func (cn *Chestnut) encrypt(plaintext []byte) (ciphertext []byte, err error) {
 	return
 }
 
-// decrypt returns the ciphertext data as plaintext.
-func (cn *Chestnut) decrypt(ciphertext []byte) (plaintext []byte, err error) {
+// decrypt returns the ciphertext data as plaintext. If the plaintext has a sealed
+// expiry time it is removed, and if the expiry time has passed an error wrapping
+// ErrNotFound is returned.
+func (cn *Chestnut) decrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := cn.decryptSealed(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, expiry := openExpiry(plaintext)
+	if expired(expiry) {
+		err = fmt.Errorf("%w: value expired", ErrNotFound)
+		return nil, cn.logError("decrypt", err)
+	}
+	return plaintext, nil
+}
+
+// decryptSealed returns the ciphertext data as plaintext with its sealed expiry time.
+func (cn *Chestnut) decryptSealed(ciphertext []byte) (plaintext []byte, err error) {
 	cn.log.Debugf("decrypt: decrypting %d bytes", len(ciphertext))
-	plaintext, err = cn.opts.encryptor.Decrypt(ciphertext)
+	plaintext, err = cn.openCiphertext(ciphertext)
 	if err != nil {
 		err = cn.logError("decrypt", err)
 		return
@@ -332,14 +600,17 @@ func (cn *Chestnut) decrypt(ciphertext []byte) (plaintext []byte, err error) {
 	return
 }
 
-// marshal returns the JSON encoding of v as ciphertext.
-func (cn *Chestnut) marshal(v interface{}) (ciphertext []byte, err error) {
+// marshal returns the JSON encoding of v as ciphertext sealed with the expiry time.
+func (cn *Chestnut) marshal(v interface{}, expiry time.Time) (ciphertext []byte, err error) {
 	if v == nil {
 		err = errors.New("value cannot be nil")
 		return nil, cn.logError("marshal", err)
 	}
 	cn.log.Debugf("marshal: %v value", reflect.TypeOf(v))
-	ciphertext, err = json.SecureMarshal(v, cn.encrypt, secure.WithLogger(cn.log))
+	encrypt := func(plaintext []byte) ([]byte, error) {
+		return cn.encrypt(sealExpiry(plaintext, expiry))
+	}
+	ciphertext, err = json.SecureMarshal(v, encrypt, secure.WithLogger(cn.log))
 	if err != nil {
 		err = cn.logError("marshal", err)
 		return
@@ -444,5 +715,27 @@ func (cn *Chestnut) logError(name string, err error) error {
 	return err
 }
 
+// writable returns ErrReadOnly if the storage chest is read-only.
+func (cn *Chestnut) writable(name string) error {
+	if cn.opts.readOnly {
+		return cn.logError(name, ErrReadOnly)
+	}
+	return nil
+}
+
+// reservedPrefix is the prefix of the namespaces reserved by the storage chest.
+const reservedPrefix = "__chestnut_"
+
+// isReserved returns true if the namespace is reserved by the storage chest.
+func isReserved(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix)
+}
+
 // ErrForbidden the storage operation is forbidden
 var ErrForbidden = errors.New("forbidden")
+
+// ErrNotFound the record was not found
+var ErrNotFound = errors.New("not found")
+
+// ErrReadOnly the storage chest is read-only
+var ErrReadOnly = errors.New("read-only")
@@ -1,12 +1,15 @@
 package chestnut
 
 import (
+	"context"
 	"errors"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/google/uuid"
 	"github.com/jrapoport/chestnut/encoding/compress"
 	"github.com/jrapoport/chestnut/encoding/compress/zstd"
@@ -483,6 +486,41 @@ func (ts *ChestnutTestSuite) testOptionDisableOverwrites(enabled bool) {
 	assertErr(ts.T(), err)
 }
 
+func (ts *ChestnutTestSuite) TestChestnut_ReadOnly() {
+	readOnlyKey := "b"
+	cn := NewChestnut(ts.cn.store, encryptorOpt, ReadOnly())
+	ts.True(cn.opts.readOnly)
+	value, err := cn.Get(testName, []byte(readOnlyKey))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	keys, err := cn.List(testName)
+	ts.NoError(err)
+	ts.NotEmpty(keys)
+	// writes fail before anything is encrypted
+	cn = NewChestnut(ts.cn.store, WithEncryptor(&badEncryptor{}), ReadOnly())
+	err = cn.Put(testName, []byte(readOnlyKey), []byte(testValue))
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.Save(testName, []byte(readOnlyKey), &objectSrc)
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.SaveKeyed(keyedObj)
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.Delete(testName, []byte(readOnlyKey))
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.Import(ts.T().TempDir(), ImportMerge)
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.PutStream(testName, []byte(readOnlyKey), strings.NewReader(testValue))
+	ts.ErrorIs(err, ErrReadOnly)
+	err = cn.Update(func(tx *Tx) error {
+		return nil
+	})
+	ts.ErrorIs(err, ErrReadOnly)
+	_, err = cn.Reap()
+	ts.ErrorIs(err, ErrReadOnly)
+	has, err := ts.cn.Has(testName, []byte(readOnlyKey))
+	ts.NoError(err)
+	ts.True(has)
+}
+
 func (ts *ChestnutTestSuite) TestChestnut_ChainedEncryptor() {
 	var operation = "encrypting"
 	// initialize a keystore with a chained encryptor
@@ -667,6 +705,39 @@ func (b badEncryptor) Decrypt([]byte) ([]byte, error) {
 
 var _ crypto.Encryptor = (*badEncryptor)(nil)
 
+func (ts *ChestnutTestSuite) TestChestnut_AgeEncryptor() {
+	id, err := age.GenerateX25519Identity()
+	ts.Require().NoError(err)
+	identity := crypto.NewManagedSecret(uuid.New().String(), id.String())
+	store := ts.storeFunc(ts.T(), ts.T().TempDir())
+	// writers only need the recipient
+	writer := NewChestnut(store, WithEncryptor(encryptor.NewAgeEncryptor(nil, id.Recipient().String())))
+	err = writer.Open()
+	ts.NoError(err)
+	defer func() {
+		err = writer.Close()
+		ts.NoError(err)
+	}()
+	err = writer.Put(testName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	err = writer.Save(testName, []byte("b"), &TObject{"a", 1})
+	ts.NoError(err)
+	_, err = writer.Get(testName, []byte("a"))
+	ts.Error(err)
+	reader := NewChestnut(store, WithEncryptor(encryptor.NewAgeEncryptor(identity)))
+	v, err := reader.Get(testName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	obj := &TObject{}
+	err = reader.Load(testName, []byte("b"), obj)
+	ts.NoError(err)
+	ts.Equal(&TObject{"a", 1}, obj)
+	report, err := reader.Verify(context.Background())
+	ts.NoError(err)
+	ts.True(report.OK())
+	ts.Len(report.Results, 2)
+}
+
 func (ts *ChestnutTestSuite) TestChestnut_BadEncryptor() {
 	var testGood = []byte("test-good")
 	var testBad = []byte("test-bad")
@@ -0,0 +1,207 @@
+package chestnut
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/log"
+	"github.com/jrapoport/chestnut/storage"
+	"github.com/jrapoport/chestnut/value"
+)
+
+// KeyFunc is the prototype for the function that returns the key of a value in a Collection.
+type KeyFunc[T any] func(v *T) []byte
+
+// Collection stores structs of type T in a namespace of the storage chest. The
+// structs are encrypted and decrypted the same way as Save and Load.
+type Collection[T any] struct {
+	cn      *Chestnut
+	name    string
+	keyFunc KeyFunc[T]
+}
+
+// NewCollection returns a Collection of T in the namespace name. The key of each
+// value is returned by keyFunc. If keyFunc is nil, *T must implement value.Keyed
+// and the key of each value is returned by its Key method. If name is empty, the
+// namespace is returned by the Namespace method of the zero value of T.
+func NewCollection[T any](cn *Chestnut, name string, keyFunc KeyFunc[T]) *Collection[T] {
+	if cn == nil {
+		log.Log.Panic("collection: storage chest required")
+		return nil
+	}
+	_, keyed := any(new(T)).(value.Keyed)
+	if keyFunc == nil && !keyed {
+		err := fmt.Errorf("collection: %T must implement value.Keyed or use a key func", new(T))
+		cn.log.Panic(err)
+		return nil
+	}
+	if name == "" && keyed {
+		name = any(new(T)).(value.Keyed).Namespace()
+	}
+	if err := storage.ValidKey(name, []byte(name)); err != nil {
+		cn.log.Panic(fmt.Errorf("collection: %w", err))
+		return nil
+	} else if isReserved(name) {
+		err = fmt.Errorf("collection: %w: namespace is reserved: %s", ErrForbidden, name)
+		cn.log.Panic(err)
+		return nil
+	}
+	return &Collection[T]{cn: cn, name: name, keyFunc: keyFunc}
+}
+
+// Namespace returns the namespace of the collection.
+func (c *Collection[T]) Namespace() string {
+	return c.name
+}
+
+// Insert saves v to the collection. If a value already exists
+// at the key of v, an error wrapping ErrForbidden is returned.
+func (c *Collection[T]) Insert(v *T, opt ...PutOption) error {
+	if err := c.cn.writable("insert"); err != nil {
+		return err
+	}
+	key, err := c.key(v)
+	if err != nil {
+		return c.cn.logError("insert", err)
+	}
+	err = c.update(func(tx *Tx) error {
+		// has returns an error if the key is not found
+		if has, _ := tx.Has(c.name, key); has {
+			return fmt.Errorf("%w: key exists: %s", ErrForbidden, key)
+		}
+		return tx.Save(c.name, key, v, opt...)
+	})
+	return c.cn.logError("insert", err)
+}
+
+// Get loads the value at key from the collection.
+func (c *Collection[T]) Get(key []byte) (*T, error) {
+	v := new(T)
+	if err := c.cn.Load(c.name, key, v); err != nil {
+		return nil, c.cn.logError("get", err)
+	}
+	return v, nil
+}
+
+// GetSparse sparsely loads the value at key from the collection. SEE: Sparse.
+func (c *Collection[T]) GetSparse(key []byte) (*T, error) {
+	v := new(T)
+	if err := c.cn.Sparse(c.name, key, v); err != nil {
+		return nil, c.cn.logError("get sparse", err)
+	}
+	return v, nil
+}
+
+// Update replaces the value at the key of v with v. If there is no value
+// at the key of v, an error wrapping ErrNotFound is returned. If overwrites
+// are forbidden, ErrForbidden is returned.
+func (c *Collection[T]) Update(v *T, opt ...PutOption) error {
+	if err := c.cn.writable("update"); err != nil {
+		return err
+	}
+	key, err := c.key(v)
+	if err != nil {
+		return c.cn.logError("update", err)
+	}
+	err = c.update(func(tx *Tx) error {
+		if has, _ := tx.Has(c.name, key); !has {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return tx.Save(c.name, key, v, opt...)
+	})
+	return c.cn.logError("update", err)
+}
+
+// Delete removes the value at key from the collection.
+func (c *Collection[T]) Delete(key []byte) error {
+	if err := c.cn.writable("delete"); err != nil {
+		return err
+	}
+	return c.cn.logError("delete", c.cn.Delete(c.name, key))
+}
+
+// All loads every value in the collection.
+func (c *Collection[T]) All() ([]*T, error) {
+	var all []*T
+	err := c.view(func(tx *Tx) error {
+		keys, err := tx.List(c.name)
+		if err != nil {
+			return err
+		}
+		all = make([]*T, 0, len(keys))
+		for _, key := range keys {
+			v := new(T)
+			if err = tx.Load(c.name, key, v); err != nil {
+				return err
+			}
+			all = append(all, v)
+		}
+		return nil
+	})
+	if err != nil && c.missing() {
+		return []*T{}, nil
+	} else if err != nil {
+		return nil, c.cn.logError("all", err)
+	}
+	return all, nil
+}
+
+// Count returns the number of values in the collection.
+func (c *Collection[T]) Count() (int, error) {
+	keys, err := c.cn.List(c.name)
+	if err != nil && c.missing() {
+		return 0, nil
+	} else if err != nil {
+		return 0, c.cn.logError("count", err)
+	}
+	return len(keys), nil
+}
+
+// key returns the key of v.
+func (c *Collection[T]) key(v *T) ([]byte, error) {
+	if v == nil {
+		return nil, errors.New("value cannot be nil")
+	}
+	if c.keyFunc != nil {
+		return c.keyFunc(v), nil
+	}
+	keyed := any(v).(value.Keyed)
+	if err := keyed.ValidKey(); err != nil {
+		return nil, err
+	}
+	return keyed.Key(), nil
+}
+
+// missing returns true if the namespace of the collection does not exist
+// in the store. Stores return an error when listing a missing namespace.
+func (c *Collection[T]) missing() bool {
+	keyMap, err := c.cn.store.ListAll()
+	if err != nil {
+		return false
+	}
+	_, ok := keyMap[c.name]
+	return !ok
+}
+
+// update calls fn inside of a read-write transaction if the store supports them.
+// Otherwise, fn is called while holding the write lock.
+func (c *Collection[T]) update(fn func(tx *Tx) error) error {
+	if _, err := c.cn.transactional(); err == nil {
+		return c.cn.Update(fn)
+	}
+	defer c.cn.lockWrites()()
+	t := &Tx{cn: c.cn, tx: c.cn.store}
+	if err := fn(t); err != nil {
+		return err
+	}
+	c.cn.publish(t.events...)
+	return nil
+}
+
+// view calls fn inside of a read-only transaction if the store supports them.
+func (c *Collection[T]) view(fn func(tx *Tx) error) error {
+	if _, err := c.cn.transactional(); err == nil {
+		return c.cn.View(fn)
+	}
+	return fn(&Tx{cn: c.cn, tx: c.cn.store})
+}
@@ -0,0 +1,168 @@
+package chestnut
+
+import (
+	"time"
+
+	"github.com/jrapoport/chestnut/storage"
+	"github.com/jrapoport/chestnut/value"
+)
+
+const collectionName = "collection-namespace"
+
+type TUser struct {
+	value.ID
+	Name   string `json:"name"`
+	Secret string `json:"secret,secure"`
+}
+
+// Namespace overrides the namespace of value.ID.
+func (u *TUser) Namespace() string {
+	return "users"
+}
+
+func objectKey(v *TObject) []byte {
+	return []byte(v.ValueA)
+}
+
+func (ts *ChestnutTestSuite) testCollection(cn *Chestnut) {
+	c := NewCollection[TObject](cn, collectionName, objectKey)
+	ts.Require().NotNil(c)
+	ts.Equal(collectionName, c.Namespace())
+	n, err := c.Count()
+	ts.NoError(err)
+	ts.Equal(0, n)
+	all, err := c.All()
+	ts.NoError(err)
+	ts.Empty(all)
+	a := &TObject{ValueA: "a", ValueB: 1}
+	b := &TObject{ValueA: "b", ValueB: 2}
+	err = c.Insert(a)
+	ts.NoError(err)
+	err = c.Insert(b)
+	ts.NoError(err)
+	err = c.Insert(a)
+	ts.ErrorIs(err, ErrForbidden)
+	err = c.Insert(nil)
+	ts.Error(err)
+	v, err := c.Get([]byte("a"))
+	ts.NoError(err)
+	ts.Equal(a, v)
+	_, err = c.Get([]byte("c"))
+	ts.Error(err)
+	a.ValueB = 3
+	err = c.Update(a)
+	ts.NoError(err)
+	v, err = c.Get([]byte("a"))
+	ts.NoError(err)
+	ts.Equal(3, v.ValueB)
+	err = c.Update(&TObject{ValueA: "c"})
+	ts.ErrorIs(err, ErrNotFound)
+	n, err = c.Count()
+	ts.NoError(err)
+	ts.Equal(2, n)
+	all, err = c.All()
+	ts.NoError(err)
+	ts.ElementsMatch([]*TObject{a, b}, all)
+	err = c.Delete([]byte("a"))
+	ts.NoError(err)
+	n, err = c.Count()
+	ts.NoError(err)
+	ts.Equal(1, n)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Collection() {
+	ts.testCollection(ts.cn)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CollectionNonTransactional() {
+	ts.testCollection(NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CollectionKeyed() {
+	c := NewCollection[TUser](ts.cn, "", nil)
+	ts.Require().NotNil(c)
+	ts.Equal("users", c.Namespace())
+	u := &TUser{ID: value.ID{ID: "user-1"}, Name: "a user", Secret: testValue}
+	err := c.Insert(u)
+	ts.NoError(err)
+	err = c.Insert(&TUser{})
+	ts.Error(err)
+	v, err := c.Get(u.Key())
+	ts.NoError(err)
+	ts.Equal(u, v)
+	v, err = c.GetSparse(u.Key())
+	ts.NoError(err)
+	ts.Equal(u.Name, v.Name)
+	ts.Empty(v.Secret)
+	// the keyed value can be loaded by the storage chest
+	loaded := &TUser{ID: u.ID}
+	err = ts.cn.LoadKeyed(loaded)
+	ts.NoError(err)
+	ts.Equal(u, loaded)
+	// the namespace can be set for keyed values
+	sc := NewCollection[value.Secure](ts.cn, collectionName, nil)
+	err = sc.Insert(keyedObj)
+	ts.NoError(err)
+	sv, err := sc.Get(keyedObj.Key())
+	ts.NoError(err)
+	ts.Equal(keyedObj.Data, sv.Data)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CollectionInvalid() {
+	ts.Panics(func() {
+		NewCollection[TObject](nil, collectionName, objectKey)
+	})
+	ts.Panics(func() {
+		NewCollection[TObject](ts.cn, collectionName, nil)
+	})
+	ts.Panics(func() {
+		NewCollection[TObject](ts.cn, "", objectKey)
+	})
+	ts.Panics(func() {
+		NewCollection[TObject](ts.cn, streamNamespace, objectKey)
+	})
+	// the namespace of the zero value is empty
+	ts.Panics(func() {
+		NewCollection[value.Secure](ts.cn, "", nil)
+	})
+	// overwrites are forbidden
+	cn := NewChestnut(ts.cn.store, encryptorOpt, OverwritesForbidden())
+	c := NewCollection[TObject](cn, collectionName, objectKey)
+	err := c.Insert(&objectSrc)
+	ts.NoError(err)
+	err = c.Update(&objectSrc)
+	ts.ErrorIs(err, ErrForbidden)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CollectionReadOnly() {
+	for _, store := range []storage.Storage{ts.cn.store, &nonIterableStore{ts.cn.store}} {
+		cn := NewChestnut(store, WithEncryptor(&badEncryptor{}), ReadOnly())
+		c := NewCollection[TObject](cn, collectionName, objectKey)
+		err := c.Insert(&objectSrc)
+		ts.ErrorIs(err, ErrReadOnly)
+		err = c.Update(&objectSrc)
+		ts.ErrorIs(err, ErrReadOnly)
+		err = c.Delete([]byte(objectSrc.ValueA))
+		ts.ErrorIs(err, ErrReadOnly)
+	}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_CollectionWriteLock() {
+	cn := NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt)
+	c := NewCollection[TObject](cn, collectionName, objectKey)
+	// writes block while the storage chest is rekeyed or imported
+	cn.writeMu.Lock()
+	done := make(chan error)
+	go func() {
+		done <- c.Insert(&objectSrc)
+	}()
+	select {
+	case <-done:
+		cn.writeMu.Unlock()
+		ts.Fail("insert did not block")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+	cn.writeMu.Unlock()
+	ts.NoError(<-done)
+}
@@ -125,6 +125,10 @@ func (p *Package) validateData() error {
 	return nil
 }
 
+// ErrUnsupportedVersion is returned when a package
+// was encoded with a newer version of the package fmt.
+var ErrUnsupportedVersion = errors.New("unsupported package version")
+
 // the currently supported package ver
 var currentVer = version.Must(version.NewVersion(Version))
 
@@ -137,7 +141,7 @@ func (p *Package) checkVersion() error {
 		return err
 	}
 	if ver.GreaterThan(currentVer) {
-		return fmt.Errorf("supported ver %s", ver)
+		return fmt.Errorf("%w: %s", ErrUnsupportedVersion, ver)
 	}
 	return nil
 }
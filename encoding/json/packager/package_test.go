@@ -209,6 +209,28 @@ func (ts *PackageTestSuite) TestPackage_Decode() {
 	}
 }
 
+func (ts *PackageTestSuite) TestPackage_UnsupportedVersion() {
+	testPkg := &Package{
+		Version:   badVer,
+		Format:    Secure,
+		EncoderID: id,
+		Cipher:    sec,
+	}
+	b := bytes.Buffer{}
+	e := gob.NewEncoder(&b)
+	err := e.Encode(testPkg)
+	ts.NoError(err)
+	pkg, err := DecodePackage(b.Bytes())
+	ts.ErrorIs(err, ErrUnsupportedVersion)
+	ts.Nil(pkg)
+	testPkg.Version = "0"
+	b.Reset()
+	err = gob.NewEncoder(&b).Encode(testPkg)
+	ts.NoError(err)
+	_, err = DecodePackage(b.Bytes())
+	ts.NotErrorIs(err, ErrUnsupportedVersion)
+}
+
 func (ts *PackageTestSuite) TestPackage() {
 	for _, test := range tests {
 		bytes, err := EncodePackage(test.id, test.token, test.sec, test.enc, test.comp)
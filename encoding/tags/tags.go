@@ -16,6 +16,9 @@ const (
 	// HashOption is the tag option to hash a struct field of type string. Defaults to SHA256.
 	HashOption = "hash"
 
+	// IndexOption is the tag option to add a struct field of type string to a blind index.
+	IndexOption = "index"
+
 	jsonSeparator  = ","
 	jsonNameIgnore = "-"
 )
@@ -81,3 +84,8 @@ func HashName(opts []string) Hash {
 func IsSecure(opts []string) bool {
 	return HasOption(opts, SecureOption)
 }
+
+// IsIndexed checks to see if the index option is set.
+func IsIndexed(opts []string) bool {
+	return HasOption(opts, IndexOption)
+}
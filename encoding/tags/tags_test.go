@@ -103,3 +103,19 @@ func TestIsSecure(t *testing.T) {
 		assert.Equal(t, test.is, is)
 	}
 }
+
+func TestIsIndexed(t *testing.T) {
+	tests := []struct {
+		opts []string
+		is   bool
+	}{
+		{nil, false},
+		{[]string{}, false},
+		{[]string{SecureOption}, false},
+		{[]string{SecureOption, IndexOption}, true},
+	}
+	for _, test := range tests {
+		is := IsIndexed(test.opts)
+		assert.Equal(t, test.is, is)
+	}
+}
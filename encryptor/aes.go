@@ -12,21 +12,88 @@ import (
 // 	- AES128-CFB, AES192-CFB, AES256-CFB
 // 	- AES128-CTR, AES192-CTR, AES256-CTR
 // 	- AES128-GCM, AES192-GCM, AES256-GCM
+// 	- AES128-SIV, AES192-SIV, AES256-SIV
+//
+// The cipher keys are derived from the secret with a crypto.KeySchedule, so the
+// expensive KDF only runs once for each master key. An AESEncryptor should be
+// reused for as long as the secret is used.
+//
+// AES-CFB and AES-CTR data is authenticated with HMAC-SHA256 (encrypt-then-MAC).
+// AES-SIV can be made deterministic with the WithDeterministic option.
 type AESEncryptor struct {
-	secret crypto.Secret
-	keyLen crypto.KeyLen
-	mode   crypto.Mode
+	secret        crypto.Secret
+	keyLen        crypto.KeyLen
+	mode          crypto.Mode
+	kdf           crypto.KDF
+	keys          *crypto.KeySchedule
+	deterministic bool
+	legacy        bool
 }
 
 var _ crypto.Encryptor = (*AESEncryptor)(nil)
 
+// An AESOption sets options such as the KDF of an AESEncryptor.
+type AESOption interface {
+	apply(*AESEncryptor)
+}
+
+// aesFuncOption wraps a function that modifies an AESEncryptor
+// into an implementation of the AESOption interface.
+type aesFuncOption struct {
+	f func(*AESEncryptor)
+}
+
+// apply applies an AESOption to an AESEncryptor.
+func (fdo *aesFuncOption) apply(do *AESEncryptor) {
+	fdo.f(do)
+}
+
+func newAESFuncOption(f func(*AESEncryptor)) *aesFuncOption {
+	return &aesFuncOption{
+		f: f,
+	}
+}
+
+// WithKDF returns an AESOption that sets the KDF used to derive the master key from
+// the secret for new data. If kdf is nil, crypto.DefaultKDF is used. Data is always
+// decrypted with the KDF recorded in its header.
+func WithKDF(kdf crypto.KDF) AESOption {
+	return newAESFuncOption(func(e *AESEncryptor) {
+		e.kdf = kdf
+	})
+}
+
+// WithDeterministic returns an AESOption that makes AES-SIV encryption deterministic,
+// so the same plaintext is always encrypted to the same ciphertext with the secret and
+// KDF. This allows exact-match lookups on encrypted values, such as identifiers, but
+// it also reveals which values are equal. Encryption fails if the mode is not aes.SIV.
+func WithDeterministic() AESOption {
+	return newAESFuncOption(func(e *AESEncryptor) {
+		e.deterministic = true
+	})
+}
+
+// WithLegacyUnauthenticated returns an AESOption that decrypts AES-CFB and AES-CTR data
+// that was encrypted without a MAC. New data is always encrypted with a MAC (encrypt-then-MAC),
+// so this option is only needed to read (or rekey) data encrypted by an older version.
+func WithLegacyUnauthenticated() AESOption {
+	return newAESFuncOption(func(e *AESEncryptor) {
+		e.legacy = true
+	})
+}
+
 // NewAESEncryptor returns a new AESEncryptor configured
 // with an AES keyLen length and mode for a secret.
-func NewAESEncryptor(keyLen crypto.KeyLen, mode crypto.Mode, secret crypto.Secret) *AESEncryptor {
+func NewAESEncryptor(keyLen crypto.KeyLen, mode crypto.Mode, secret crypto.Secret,
+	opt ...AESOption) *AESEncryptor {
 	ae := new(AESEncryptor)
 	ae.secret = secret
 	ae.keyLen = keyLen
 	ae.mode = mode
+	for _, o := range opt {
+		o.apply(ae)
+	}
+	ae.keys = crypto.NewKeySchedule(ae.kdf)
 	return ae
 }
 
@@ -44,32 +111,58 @@ func (e *AESEncryptor) Name() string {
 
 // Encrypt returns the plain data encrypted with the configured cipher mode and secret.
 func (e *AESEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
-	var encryptCall aes.CipherCall
-	switch e.mode {
+	encryptCall, err := aesEncryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	opts := []aes.Option{aes.WithSecretID(e.secret.ID()), aes.WithKeySchedule(e.keys)}
+	if e.deterministic {
+		opts = append(opts, aes.WithDeterministic())
+	}
+	return encryptCall(e.keyLen, e.secret.Open(), plaintext, opts...)
+}
+
+// Decrypt returns the cipher data decrypted with the configured cipher mode and secret.
+func (e *AESEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	decryptCall, err := aesDecryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	opts := []aes.Option{aes.WithKeySchedule(e.keys)}
+	if e.legacy {
+		opts = append(opts, aes.WithLegacyUnauthenticated())
+	}
+	return decryptCall(e.keyLen, e.secret.Open(), ciphertext, opts...)
+}
+
+// aesEncryptCall returns the AES encryption call for the cipher mode.
+func aesEncryptCall(mode crypto.Mode) (aes.CipherCall, error) {
+	switch mode {
 	case aes.CFB:
-		encryptCall = aes.EncryptCFB
+		return aes.EncryptCFB, nil
 	case aes.CTR:
-		encryptCall = aes.EncryptCTR
+		return aes.EncryptCTR, nil
 	case aes.GCM:
-		encryptCall = aes.EncryptGCM
+		return aes.EncryptGCM, nil
+	case aes.SIV:
+		return aes.EncryptSIV, nil
 	default:
-		return nil, fmt.Errorf("unsupported encryption cipher mode: %s", e.mode)
+		return nil, fmt.Errorf("unsupported encryption cipher mode: %s", mode)
 	}
-	return encryptCall(e.keyLen, e.secret.Open(), plaintext)
 }
 
-// Decrypt returns the cipher data decrypted with the configured cipher mode and secret.
-func (e *AESEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-	var decryptCall aes.CipherCall
-	switch e.mode {
+// aesDecryptCall returns the AES decryption call for the cipher mode.
+func aesDecryptCall(mode crypto.Mode) (aes.CipherCall, error) {
+	switch mode {
 	case aes.CFB:
-		decryptCall = aes.DecryptCFB
+		return aes.DecryptCFB, nil
 	case aes.CTR:
-		decryptCall = aes.DecryptCTR
+		return aes.DecryptCTR, nil
 	case aes.GCM:
-		decryptCall = aes.DecryptGCM
+		return aes.DecryptGCM, nil
+	case aes.SIV:
+		return aes.DecryptSIV, nil
 	default:
-		return nil, fmt.Errorf("unsupported decryption cipher mode: %s", e.mode)
+		return nil, fmt.Errorf("unsupported decryption cipher mode: %s", mode)
 	}
-	return decryptCall(e.keyLen, e.secret.Open(), ciphertext)
 }
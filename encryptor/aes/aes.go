@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"errors"
+	"fmt"
 
 	"github.com/jrapoport/chestnut/encryptor/crypto"
 )
@@ -13,35 +14,66 @@ const (
 	CFB crypto.Mode = "cfb"
 	CTR             = "ctr"
 	GCM             = "gcm"
+	SIV             = "siv"
 )
 
 // CipherCall is function the prototype for the encryption and decryption.
-type CipherCall func(length crypto.KeyLen, secret, data []byte) ([]byte, error)
+type CipherCall func(length crypto.KeyLen, secret, data []byte, opt ...Option) ([]byte, error)
 
 // cipherTransform preforms the encryption or decryption and returns the result.
-type cipherTransform func(header crypto.Header, block cipher.Block, data []byte) ([]byte, error)
+type cipherTransform func(header crypto.Header, key []byte, data []byte) ([]byte, error)
+
+// CipherKeyLen returns the length of the cipher key for an AES key length and mode.
+// AES-SIV uses two keys of the key length, one for S2V and one for CTR (RFC 5297).
+func CipherKeyLen(keyLen crypto.KeyLen, mode crypto.Mode) crypto.KeyLen {
+	if mode == SIV {
+		return keyLen * 2
+	}
+	return keyLen
+}
+
+// newBlock returns an AES cipher block for the key.
+func newBlock(key []byte) (cipher.Block, error) {
+	return aes.NewCipher(key)
+}
 
 // encrypt is a generalized AES decryption function that takes plaintext and return a serialized Entry.
-func encrypt(keyLen crypto.KeyLen, secret, plaintext []byte, header crypto.Header, encryptT cipherTransform) ([]byte, error) {
+func encrypt(keyLen crypto.KeyLen, secret, plaintext []byte, header crypto.Header,
+	encryptT cipherTransform, opts Options) ([]byte, error) {
 	if plaintext == nil || len(plaintext) <= 0 {
 		return nil, errors.New("invalid plain data")
 	}
-	// create the cipher key
-	key, err := crypto.NewCipherKey(keyLen, secret, header.Salt)
-	if err != nil {
-		return nil, err
+	if opts.deterministic && header.Mode != SIV {
+		return nil, fmt.Errorf("deterministic encryption is not supported by %s", header.Name())
 	}
-	// create the cipher block
-	block, err := aes.NewCipher(key)
+	header.SecretID = opts.secretID
+	if opts.keys != nil && !opts.cipherKey {
+		if err := opts.keys.SetHeader(&header); err != nil {
+			return nil, err
+		}
+		if opts.deterministic {
+			header.KDFSalt = deterministicSalt
+		}
+	}
+	// create the cipher key
+	key, err := cipherKey(CipherKeyLen(keyLen, header.Mode), secret, header, opts)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext, err := encryptT(header, block, plaintext)
+	ciphertext, err := encryptT(header, key, plaintext)
 	if err != nil {
 		return nil, err
 	}
 	// check the result
 	data := crypto.NewData(header, ciphertext)
+	data.WrappedKey = opts.wrappedKey
+	data.Recipients = opts.recipients
+	// authenticate the unauthenticated modes (encrypt-then-MAC)
+	if isStreamMode(header.Mode) {
+		if data.MAC, err = DataMAC(key, data); err != nil {
+			return nil, err
+		}
+	}
 	if err = isDataValid(data); err != nil {
 		return nil, err
 	}
@@ -50,7 +82,7 @@ func encrypt(keyLen crypto.KeyLen, secret, plaintext []byte, header crypto.Heade
 }
 
 // decrypt is a generalized AES decryption function that takes a serialized Entry and returns plaintext.
-func decrypt(keyLen crypto.KeyLen, secret, ciphertext []byte, decryptT cipherTransform) ([]byte, error) {
+func decrypt(keyLen crypto.KeyLen, secret, ciphertext []byte, decryptT cipherTransform, opts Options) ([]byte, error) {
 	if ciphertext == nil || len(ciphertext) <= 0 {
 		return nil, errors.New("invalid cipher data")
 	}
@@ -64,17 +96,29 @@ func decrypt(keyLen crypto.KeyLen, secret, ciphertext []byte, decryptT cipherTra
 		return nil, err
 	}
 	// get the cipher key
-	key, err := crypto.NewCipherKey(keyLen, secret, data.Salt)
+	key, err := cipherKey(CipherKeyLen(keyLen, data.Mode), secret, data.Header, opts)
 	if err != nil {
 		return nil, err
 	}
-	// get the cipher block
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+	// authenticate the data before it is decrypted
+	if isStreamMode(data.Mode) {
+		if err = verifyMAC(key, data, opts); err != nil {
+			return nil, err
+		}
 	}
 	// decrypt the data
-	return decryptT(data.Header, block, data.Bytes)
+	return decryptT(data.Header, key, data.Bytes)
+}
+
+// cipherKey returns the cipher key for the secret and header.
+func cipherKey(keyLen crypto.KeyLen, secret []byte, header crypto.Header, opts Options) ([]byte, error) {
+	if !opts.cipherKey {
+		return opts.keys.CipherKey(keyLen, secret, header)
+	}
+	if len(secret) != int(keyLen) {
+		return nil, fmt.Errorf("invalid cipher key length %d != %d", len(secret), keyLen)
+	}
+	return secret, nil
 }
 
 func isDataValid(data crypto.Data) error {
@@ -87,3 +131,33 @@ func isDataValid(data crypto.Data) error {
 	}
 	return nil
 }
+
+// isStreamMode returns true if the mode is an unauthenticated stream mode.
+func isStreamMode(mode crypto.Mode) bool {
+	return mode == CFB || mode == CTR
+}
+
+// DataMAC returns the MAC of the data with a MAC key derived from the cipher key.
+func DataMAC(key []byte, data crypto.Data) ([]byte, error) {
+	macKey, err := crypto.NewMACKey(key, data.Header)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewDataMAC(macKey, data)
+}
+
+// verifyMAC returns an error if the MAC of the data does not match. If the data does not
+// have a MAC, it is only accepted with the WithLegacyUnauthenticated option.
+func verifyMAC(key []byte, data crypto.Data, opts Options) error {
+	if len(data.MAC) <= 0 {
+		if opts.legacy {
+			return nil
+		}
+		return errors.New("unauthenticated data requires the legacy option")
+	}
+	macKey, err := crypto.NewMACKey(key, data.Header)
+	if err != nil {
+		return err
+	}
+	return crypto.VerifyDataMAC(macKey, data)
+}
@@ -17,6 +17,7 @@ func TestAllCiphers(t *testing.T) {
 		{CFB, EncryptCFB, DecryptCFB},
 		{CTR, EncryptCTR, DecryptCTR},
 		{GCM, EncryptGCM, DecryptGCM},
+		{SIV, EncryptSIV, DecryptSIV},
 	}
 	for _, cipher := range ciphers {
 		t.Run(cipher.name.String(), func(t *testing.T) {
@@ -28,6 +29,7 @@ func TestAllCiphers(t *testing.T) {
 func testCipher(t *testing.T, encryptCall, decryptCall CipherCall) {
 	const (
 		secret    = "i-am-a-good-secret"
+		secretID  = "i-am-a-secret-id"
 		plaintext = "Lorem ipsum dolor sit amet"
 	)
 	lens := []crypto.KeyLen{
@@ -51,8 +53,49 @@ func testCipher(t *testing.T, encryptCall, decryptCall CipherCall) {
 			assert.Equal(t, plaintext, string(decrypted))
 		})
 	}
+	// secret id
+	encrypted, err := encryptCall(crypto.Key256, []byte(secret), []byte(plaintext), WithSecretID(secretID))
+	assert.NoError(t, err)
+	data, err := crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, secretID, data.SecretID)
+	// cipher key
+	key, err := crypto.MakeRand(uint(CipherKeyLen(crypto.Key256, data.Mode)))
+	assert.NoError(t, err)
+	wrapped := []byte("i-am-a-wrapped-key")
+	encrypted, err = encryptCall(crypto.Key256, key, []byte(plaintext), WithCipherKey(), WithWrappedKey(wrapped))
+	assert.NoError(t, err)
+	data, err = crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, wrapped, data.WrappedKey)
+	decrypted, err := decryptCall(crypto.Key256, key, encrypted, WithCipherKey())
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	_, err = encryptCall(crypto.Key256, []byte(secret), []byte(plaintext), WithCipherKey())
+	assert.Error(t, err)
+	// key schedule
+	ks := crypto.NewKeySchedule(crypto.ScryptKDF{N: 1024, R: 8, P: 1})
+	encrypted, err = encryptCall(crypto.Key256, []byte(secret), []byte(plaintext), WithKeySchedule(ks))
+	assert.NoError(t, err)
+	data, err = crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, ks.KDF().ID(), data.KDF)
+	assert.NotEmpty(t, data.KDFSalt)
+	decrypted, err = decryptCall(crypto.Key256, []byte(secret), encrypted, WithKeySchedule(ks))
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	// the kdf is recorded in the header
+	decrypted, err = decryptCall(crypto.Key256, []byte(secret), encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	// legacy data decrypts with a key schedule
+	encrypted, err = encryptCall(crypto.Key256, []byte(secret), []byte(plaintext))
+	assert.NoError(t, err)
+	decrypted, err = decryptCall(crypto.Key256, []byte(secret), encrypted, WithKeySchedule(ks))
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
 	// bad plain data
-	_, err := encryptCall(crypto.Key256, []byte(secret), nil)
+	_, err = encryptCall(crypto.Key256, []byte(secret), nil)
 	assert.Error(t, err)
 	// mismatch
 	e, _ := encryptCall(crypto.Key256, []byte(secret), []byte(plaintext))
@@ -12,13 +12,15 @@ var (
 )
 
 // EncryptCFB supports AES128-CFB, AES192-CFB, and AES256-CFB encryption.
-func EncryptCFB(length crypto.KeyLen, secret, plaintext []byte) ([]byte, error) {
+func EncryptCFB(length crypto.KeyLen, secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// encrypt the data
-	return xorStreamEncrypt(length, CFB, secret, plaintext, cipher.NewCFBEncrypter)
+	return xorStreamEncrypt(length, CFB, secret, plaintext, cipher.NewCFBEncrypter, opts)
 }
 
 // DecryptCFB supports AES128-CFB, AES192-CFB, and AES256-CFB decryption.
-func DecryptCFB(length crypto.KeyLen, secret, ciphertext []byte) ([]byte, error) {
+func DecryptCFB(length crypto.KeyLen, secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// decrypt the data
-	return xorStreamDecrypt(length, CFB, secret, ciphertext, cipher.NewCFBDecrypter)
+	return xorStreamDecrypt(length, CFB, secret, ciphertext, cipher.NewCFBDecrypter, opts)
 }
@@ -1,7 +1,22 @@
 package aes
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestCipherCFB(t *testing.T) {
 	testCipher(t, EncryptCFB, DecryptCFB)
 }
+
+func TestCipherCFB_InvalidIV(t *testing.T) {
+	const secret = "i-am-a-good-secret"
+	encrypted, err := EncryptGCM(crypto.Key256, []byte(secret), []byte(secret))
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, err = DecryptCFB(crypto.Key256, []byte(secret), encrypted)
+	})
+	assert.Error(t, err)
+}
@@ -12,13 +12,15 @@ var (
 )
 
 // EncryptCTR supports AES128-CTR, AES192-CTR, and AES256-CTR encryption.
-func EncryptCTR(length crypto.KeyLen, secret, plaintext []byte) ([]byte, error) {
+func EncryptCTR(length crypto.KeyLen, secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// encrypt the data
-	return xorStreamEncrypt(length, CTR, secret, plaintext, cipher.NewCTR)
+	return xorStreamEncrypt(length, CTR, secret, plaintext, cipher.NewCTR, opts)
 }
 
 // DecryptCTR supports AES128-CTR, AES192-CTR, and AES256-CTR decryption.
-func DecryptCTR(length crypto.KeyLen, secret, ciphertext []byte) ([]byte, error) {
+func DecryptCTR(length crypto.KeyLen, secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// decrypt the data
-	return xorStreamDecrypt(length, CTR, secret, ciphertext, cipher.NewCTR)
+	return xorStreamDecrypt(length, CTR, secret, ciphertext, cipher.NewCTR, opts)
 }
@@ -1,7 +1,22 @@
 package aes
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestCipherCTR(t *testing.T) {
 	testCipher(t, EncryptCTR, DecryptCTR)
 }
+
+func TestCipherCTR_InvalidIV(t *testing.T) {
+	const secret = "i-am-a-good-secret"
+	encrypted, err := EncryptGCM(crypto.Key256, []byte(secret), []byte(secret))
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, err = DecryptCTR(crypto.Key256, []byte(secret), encrypted)
+	})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package aes
 
 import (
 	"crypto/cipher"
+	"errors"
 
 	"github.com/jrapoport/chestnut/encryptor/crypto"
 )
@@ -25,14 +26,19 @@ func newGMCHeader(keyLen crypto.KeyLen) (crypto.Header, error) {
 }
 
 // EncryptGCM supports AES128-GCM, AES192-GCM, and AES256-GCM encryption.
-func EncryptGCM(keyLen crypto.KeyLen, secret, plaintext []byte) ([]byte, error) {
+func EncryptGCM(keyLen crypto.KeyLen, secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// create the header
 	header, err := newGMCHeader(keyLen)
 	if err != nil {
 		return nil, err
 	}
 	// seal the data with gcms
-	sealData := func(_ crypto.Header, block cipher.Block, _ []byte) ([]byte, error) {
+	sealData := func(_ crypto.Header, key []byte, _ []byte) ([]byte, error) {
+		block, blockErr := newBlock(key)
+		if blockErr != nil {
+			return nil, blockErr
+		}
 		// create the AHEAD
 		gcm, gcmErr := cipher.NewGCM(block)
 		if gcmErr != nil {
@@ -41,20 +47,29 @@ func EncryptGCM(keyLen crypto.KeyLen, secret, plaintext []byte) ([]byte, error)
 		// encrypt the data
 		return gcm.Seal(nil, header.Nonce, plaintext, nil), nil
 	}
-	return encrypt(keyLen, secret, plaintext, header, sealData)
+	return encrypt(keyLen, secret, plaintext, header, sealData, opts)
 }
 
 // DecryptGCM supports AES128-GCM, AES192-GCM, and AES256-GCM decryption.
-func DecryptGCM(keyLen crypto.KeyLen, secret, ciphertext []byte) ([]byte, error) {
+func DecryptGCM(keyLen crypto.KeyLen, secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
 	// open the data with gcm
-	openData := func(header crypto.Header, block cipher.Block, data []byte) ([]byte, error) {
+	openData := func(header crypto.Header, key []byte, data []byte) ([]byte, error) {
+		block, err := newBlock(key)
+		if err != nil {
+			return nil, err
+		}
 		// create the AHEAD
 		gcm, err := cipher.NewGCM(block)
 		if err != nil {
 			return nil, err
 		}
+		// data encrypted with another mode does not have a gcm nonce
+		if len(header.Nonce) != gcm.NonceSize() {
+			return nil, errors.New("invalid gcm nonce")
+		}
 		// decrypt the data
 		return gcm.Open(nil, header.Nonce, data, nil)
 	}
-	return decrypt(keyLen, secret, ciphertext, openData)
+	return decrypt(keyLen, secret, ciphertext, openData, opts)
 }
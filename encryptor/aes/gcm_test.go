@@ -1,7 +1,22 @@
 package aes
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestCipherGCM(t *testing.T) {
 	testCipher(t, EncryptGCM, DecryptGCM)
 }
+
+func TestCipherGCM_InvalidNonce(t *testing.T) {
+	const secret = "i-am-a-good-secret"
+	encrypted, err := EncryptCFB(crypto.Key256, []byte(secret), []byte(secret))
+	assert.NoError(t, err)
+	assert.NotPanics(t, func() {
+		_, err = DecryptGCM(crypto.Key256, []byte(secret), encrypted)
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package aes
+
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipherMAC(t *testing.T) {
+	const (
+		secret    = "i-am-a-good-secret"
+		plaintext = "Lorem ipsum dolor sit amet"
+	)
+	ciphers := []struct {
+		name        crypto.Mode
+		encryptCall CipherCall
+		decryptCall CipherCall
+	}{
+		{CFB, EncryptCFB, DecryptCFB},
+		{CTR, EncryptCTR, DecryptCTR},
+	}
+	encode := func(data crypto.Data) []byte {
+		b, err := crypto.EncodeData(data)
+		assert.NoError(t, err)
+		return b
+	}
+	for _, cipher := range ciphers {
+		t.Run(cipher.name.String(), func(t *testing.T) {
+			encrypted, err := cipher.encryptCall(crypto.Key256, []byte(secret), []byte(plaintext))
+			assert.NoError(t, err)
+			data, err := crypto.DecodeData(encrypted)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, data.MAC)
+			// the header and the bytes are authenticated
+			tampered := data
+			tampered.IV = append([]byte{}, data.IV...)
+			tampered.IV[0] ^= 1
+			_, err = cipher.decryptCall(crypto.Key256, []byte(secret), encode(tampered))
+			assert.ErrorIs(t, err, crypto.ErrMACMismatch)
+			tampered = data
+			tampered.SecretID = "i-am-a-secret-id"
+			_, err = cipher.decryptCall(crypto.Key256, []byte(secret), encode(tampered))
+			assert.ErrorIs(t, err, crypto.ErrMACMismatch)
+			tampered = data
+			tampered.Bytes = append([]byte{}, data.Bytes...)
+			tampered.Bytes[0] ^= 1
+			_, err = cipher.decryptCall(crypto.Key256, []byte(secret), encode(tampered))
+			assert.ErrorIs(t, err, crypto.ErrMACMismatch)
+			// the legacy option does not skip the mac
+			_, err = cipher.decryptCall(crypto.Key256, []byte(secret), encode(tampered), WithLegacyUnauthenticated())
+			assert.ErrorIs(t, err, crypto.ErrMACMismatch)
+			// unauthenticated data requires the legacy option
+			legacy := data
+			legacy.MAC = nil
+			_, err = cipher.decryptCall(crypto.Key256, []byte(secret), encode(legacy))
+			assert.Error(t, err)
+			decrypted, err := cipher.decryptCall(crypto.Key256, []byte(secret), encode(legacy), WithLegacyUnauthenticated())
+			assert.NoError(t, err)
+			assert.Equal(t, plaintext, string(decrypted))
+		})
+	}
+	// authenticated modes do not have a mac
+	encrypted, err := EncryptGCM(crypto.Key256, []byte(secret), []byte(plaintext))
+	assert.NoError(t, err)
+	data, err := crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.Empty(t, data.MAC)
+}
@@ -0,0 +1,121 @@
+package aes
+
+import "github.com/jrapoport/chestnut/encryptor/crypto"
+
+// Options provides the options for AES encryption and decryption.
+type Options struct {
+	// secretID is recorded in the header of encrypted data.
+	secretID string
+
+	// cipherKey uses the secret as the cipher key without key derivation.
+	cipherKey bool
+
+	// wrappedKey is the wrapped data encryption key for envelope encryption.
+	wrappedKey []byte
+
+	// recipients are the wrapped data encryption keys for multiple recipients.
+	recipients []crypto.Recipient
+
+	// keys derives the cipher keys from the secret.
+	keys *crypto.KeySchedule
+
+	// deterministic encrypts the same plaintext to the same ciphertext.
+	deterministic bool
+
+	// legacy decrypts data encrypted with CFB or CTR without a MAC.
+	legacy bool
+}
+
+// An Option sets options such as the secret id.
+type Option interface {
+	apply(*Options)
+}
+
+// funcOption wraps a function that modifies Options
+// into an implementation of the Option interface.
+type funcOption struct {
+	f func(*Options)
+}
+
+// apply applies an Option to Options.
+func (fdo *funcOption) apply(do *Options) {
+	fdo.f(do)
+}
+
+func newFuncOption(f func(*Options)) *funcOption {
+	return &funcOption{
+		f: f,
+	}
+}
+
+// applyOptions accepts a Options struct and applies the Option(s) to it.
+func applyOptions(opts Options, opt ...Option) Options {
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	return opts
+}
+
+// WithSecretID returns an Option that records the id of
+// the secret in the header of the encrypted data.
+func WithSecretID(id string) Option {
+	return newFuncOption(func(o *Options) {
+		o.secretID = id
+	})
+}
+
+// WithCipherKey returns an Option that uses the secret as the cipher key. The
+// secret must be a random key of the same length as the cipher key length. This
+// skips the (expensive) key derivation and is intended for envelope encryption
+// where each block is encrypted with its own random data encryption key.
+func WithCipherKey() Option {
+	return newFuncOption(func(o *Options) {
+		o.cipherKey = true
+	})
+}
+
+// WithWrappedKey returns an Option that stores the wrapped data encryption
+// key alongside the encrypted data for envelope encryption.
+func WithWrappedKey(wrapped []byte) Option {
+	return newFuncOption(func(o *Options) {
+		o.wrappedKey = wrapped
+	})
+}
+
+// WithRecipients returns an Option that stores the data encryption key wrapped
+// for each recipient alongside the encrypted data for multi-recipient encryption.
+func WithRecipients(recipients []crypto.Recipient) Option {
+	return newFuncOption(func(o *Options) {
+		o.recipients = recipients
+	})
+}
+
+// WithKeySchedule returns an Option that derives the cipher keys from the secret with
+// the key schedule. New data is encrypted with a cipher key derived from a master key
+// (SEE: crypto.KeySchedule), which is much faster than deriving each cipher key from
+// the secret. Without a key schedule, the legacy key derivation is used for new data.
+// Data is decrypted with the key derivation recorded in its header either way.
+func WithKeySchedule(ks *crypto.KeySchedule) Option {
+	return newFuncOption(func(o *Options) {
+		o.keys = ks
+	})
+}
+
+// WithDeterministic returns an Option that makes AES-SIV encryption deterministic: the
+// same plaintext encrypted with the same secret always results in the same ciphertext.
+// This allows exact-match lookups on the encrypted data, but it also reveals which
+// values are equal. It is only supported by AES-SIV (SEE: EncryptSIV).
+func WithDeterministic() Option {
+	return newFuncOption(func(o *Options) {
+		o.deterministic = true
+	})
+}
+
+// WithLegacyUnauthenticated returns an Option that decrypts AES-CFB and AES-CTR data
+// that was encrypted without a MAC, i.e. before encrypt-then-MAC was added. Without
+// this option, decrypting that data fails. Data with a MAC is always authenticated.
+func WithLegacyUnauthenticated() Option {
+	return newFuncOption(func(o *Options) {
+		o.legacy = true
+	})
+}
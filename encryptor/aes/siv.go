@@ -0,0 +1,216 @@
+package aes
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+var (
+	_ CipherCall = EncryptSIV // EncryptSIV conforms to CipherCall
+	_ CipherCall = DecryptSIV // DecryptSIV conforms to CipherCall
+)
+
+// sivSize is the size of the synthetic iv.
+const sivSize = 16
+
+// deterministicSalt is the fixed salt of deterministic data. Deterministic data cannot
+// have a random salt, so its cipher key is derived from the secret and this salt.
+var deterministicSalt = []byte("chestnut aes-siv deterministic salt")
+
+// newSIVHeader returns a header suitable for a siv cipher. If the header is deterministic, it has
+// a fixed salt and no nonce. Otherwise, it has a random salt and a random nonce that is used as
+// associated data, so the same plaintext is encrypted to a different ciphertext (RFC 5297).
+func newSIVHeader(keyLen crypto.KeyLen, deterministic bool) (crypto.Header, error) {
+	if deterministic {
+		return crypto.NewHeader("aes", keyLen, SIV, deterministicSalt, nil, nil)
+	}
+	salt, err := crypto.MakeSalt()
+	if err != nil {
+		return crypto.Header{}, err
+	}
+	nonce, err := crypto.MakeNonce()
+	if err != nil {
+		return crypto.Header{}, err
+	}
+	return crypto.NewHeader("aes", keyLen, SIV, salt, nil, nonce)
+}
+
+// EncryptSIV supports AES128-SIV, AES192-SIV, and AES256-SIV encryption (RFC 5297). AES-SIV
+// is nonce-misuse resistant: a repeated nonce only reveals whether the plaintexts are equal.
+// By default, a random nonce is used. If the WithDeterministic option is set, no nonce is used
+// and the same plaintext and secret always result in the same ciphertext.
+func EncryptSIV(keyLen crypto.KeyLen, secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	// create the header
+	header, err := newSIVHeader(keyLen, opts.deterministic)
+	if err != nil {
+		return nil, err
+	}
+	// seal the data with siv
+	sealData := func(h crypto.Header, key []byte, _ []byte) ([]byte, error) {
+		return sivSeal(key, plaintext, sivAD(h)...)
+	}
+	return encrypt(keyLen, secret, plaintext, header, sealData, opts)
+}
+
+// DecryptSIV supports AES128-SIV, AES192-SIV, and AES256-SIV decryption (RFC 5297).
+func DecryptSIV(keyLen crypto.KeyLen, secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	// open the data with siv
+	openData := func(header crypto.Header, key []byte, data []byte) ([]byte, error) {
+		// data encrypted with another mode does not have a synthetic iv
+		if header.Mode != SIV {
+			return nil, fmt.Errorf("invalid siv mode: %s", header.Mode)
+		}
+		return sivOpen(key, data, sivAD(header)...)
+	}
+	return decrypt(keyLen, secret, ciphertext, openData, opts)
+}
+
+// sivAD returns the associated data of the header.
+func sivAD(h crypto.Header) [][]byte {
+	if h.Nonce == nil {
+		return nil
+	}
+	return [][]byte{h.Nonce}
+}
+
+// sivSeal returns the synthetic iv followed by the plaintext encrypted with AES-SIV. The
+// first half of the key is used for S2V and the second half is used for CTR.
+func sivSeal(key, plaintext []byte, ad ...[]byte) ([]byte, error) {
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	v := s2v(mac, append(ad, plaintext)...)
+	ciphertext := make([]byte, sivSize+len(plaintext))
+	copy(ciphertext, v[:])
+	sivCTR(ctr, v).XORKeyStream(ciphertext[sivSize:], plaintext)
+	return ciphertext, nil
+}
+
+// sivOpen returns the plaintext of data encrypted with sivSeal.
+func sivOpen(key, ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < sivSize {
+		return nil, errors.New("invalid siv data")
+	}
+	mac, ctr, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+	var v [sivSize]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-sivSize)
+	sivCTR(ctr, v).XORKeyStream(plaintext, ciphertext[sivSize:])
+	t := s2v(mac, append(ad, plaintext)...)
+	if subtle.ConstantTimeCompare(v[:], t[:]) != 1 {
+		return nil, errors.New("siv: message authentication failed")
+	}
+	return plaintext, nil
+}
+
+// sivCiphers returns the S2V and CTR cipher blocks of the key.
+func sivCiphers(key []byte) (cipher.Block, cipher.Block, error) {
+	if len(key)%2 != 0 {
+		return nil, nil, fmt.Errorf("invalid siv key length %d", len(key))
+	}
+	mac, err := newBlock(key[:len(key)/2])
+	if err != nil {
+		return nil, nil, err
+	}
+	ctr, err := newBlock(key[len(key)/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return mac, ctr, nil
+}
+
+// sivCTR returns the CTR stream for the synthetic iv. The 31st and 63rd bits
+// (from the right) of the iv are cleared to allow for 32 and 64-bit counters.
+func sivCTR(block cipher.Block, v [sivSize]byte) cipher.Stream {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	return cipher.NewCTR(block, v[:])
+}
+
+// s2v is the S2V construction of RFC 5297 with AES-CMAC. The last input is the plaintext.
+func s2v(block cipher.Block, inputs ...[]byte) [sivSize]byte {
+	var zero [sivSize]byte
+	d := cmac(block, zero[:])
+	last := inputs[len(inputs)-1]
+	for _, in := range inputs[:len(inputs)-1] {
+		d = dbl(d)
+		xorBlock(&d, cmac(block, in))
+	}
+	var t []byte
+	if len(last) >= sivSize {
+		// xorend
+		t = append([]byte{}, last...)
+		for i := 0; i < sivSize; i++ {
+			t[len(t)-sivSize+i] ^= d[i]
+		}
+	} else {
+		d = dbl(d)
+		var padded [sivSize]byte
+		copy(padded[:], last)
+		padded[len(last)] = 0x80
+		xorBlock(&d, padded)
+		t = d[:]
+	}
+	return cmac(block, t)
+}
+
+// cmac returns the AES-CMAC of the message (RFC 4493).
+func cmac(block cipher.Block, msg []byte) [sivSize]byte {
+	var l [sivSize]byte
+	block.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+	k2 := dbl(k1)
+	n := (len(msg) + sivSize - 1) / sivSize
+	complete := n > 0 && len(msg)%sivSize == 0
+	if n == 0 {
+		n = 1
+	}
+	var x [sivSize]byte
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < sivSize; j++ {
+			x[j] ^= msg[i*sivSize+j]
+		}
+		block.Encrypt(x[:], x[:])
+	}
+	var last [sivSize]byte
+	copy(last[:], msg[(n-1)*sivSize:])
+	if complete {
+		xorBlock(&last, k1)
+	} else {
+		last[len(msg)-(n-1)*sivSize] = 0x80
+		xorBlock(&last, k2)
+	}
+	xorBlock(&x, last)
+	block.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl multiplies the block by x in GF(2^128).
+func dbl(b [sivSize]byte) [sivSize]byte {
+	var r [sivSize]byte
+	for i := 0; i < sivSize-1; i++ {
+		r[i] = b[i]<<1 | b[i+1]>>7
+	}
+	r[sivSize-1] = b[sivSize-1] << 1
+	if b[0]&0x80 != 0 {
+		r[sivSize-1] ^= 0x87
+	}
+	return r
+}
+
+// xorBlock xors b into a.
+func xorBlock(a *[sivSize]byte, b [sivSize]byte) {
+	for i := range a {
+		a[i] ^= b[i]
+	}
+}
@@ -0,0 +1,135 @@
+package aes
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	assert.NoError(t, err)
+	return b
+}
+
+func TestCipherSIV(t *testing.T) {
+	testCipher(t, EncryptSIV, DecryptSIV)
+}
+
+// test vectors from RFC 4493 and RFC 5297
+func TestCipherSIV_Vectors(t *testing.T) {
+	block, err := newBlock(unhex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c"))
+	assert.NoError(t, err)
+	cmacTests := []struct {
+		msg string
+		mac string
+	}{
+		{"", "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{"6bc1bee2 2e409f96 e93d7e11 7393172a", "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{"6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 30c81c46 a35ce411",
+			"dfa66747 de9ae630 30ca3261 1497c827"},
+	}
+	for _, test := range cmacTests {
+		mac := cmac(block, unhex(t, test.msg))
+		assert.Equal(t, unhex(t, test.mac), mac[:])
+	}
+	sivTests := []struct {
+		key        string
+		ad         []string
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+			[]string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+			"11223344 55667788 99aabbcc ddee",
+			"85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+		},
+		{
+			"7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+			[]string{
+				"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+				"10203040 50607080 90a0",
+				"09f91102 9d74e35b d84156c5 635688c0",
+			},
+			"74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+			"7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17 dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+		},
+	}
+	for _, test := range sivTests {
+		var ad [][]byte
+		for _, a := range test.ad {
+			ad = append(ad, unhex(t, a))
+		}
+		key := unhex(t, test.key)
+		ciphertext, err := sivSeal(key, unhex(t, test.plaintext), ad...)
+		assert.NoError(t, err)
+		assert.Equal(t, unhex(t, test.ciphertext), ciphertext)
+		plaintext, err := sivOpen(key, ciphertext, ad...)
+		assert.NoError(t, err)
+		assert.Equal(t, unhex(t, test.plaintext), plaintext)
+		// the associated data is authenticated
+		_, err = sivOpen(key, ciphertext)
+		assert.Error(t, err)
+		ciphertext[len(ciphertext)-1] ^= 1
+		_, err = sivOpen(key, ciphertext, ad...)
+		assert.Error(t, err)
+	}
+	_, err = sivOpen(unhex(t, sivTests[0].key), []byte("short"))
+	assert.Error(t, err)
+	_, err = sivSeal([]byte("odd"), []byte("data"))
+	assert.Error(t, err)
+}
+
+func TestCipherSIV_Deterministic(t *testing.T) {
+	const (
+		secret    = "i-am-a-good-secret"
+		plaintext = "Lorem ipsum dolor sit amet"
+	)
+	ks := crypto.NewKeySchedule(crypto.ScryptKDF{N: 1024, R: 8, P: 1})
+	encryptTests := [][]Option{
+		{WithDeterministic()},
+		{WithDeterministic(), WithKeySchedule(ks)},
+	}
+	for _, opts := range encryptTests {
+		e1, err := EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext), opts...)
+		assert.NoError(t, err)
+		e2, err := EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext), opts...)
+		assert.NoError(t, err)
+		assert.Equal(t, e1, e2)
+		d, err := DecryptSIV(crypto.Key256, []byte(secret), e1)
+		assert.NoError(t, err)
+		assert.Equal(t, plaintext, string(d))
+		e3, err := EncryptSIV(crypto.Key256, []byte(secret), []byte("another plaintext"), opts...)
+		assert.NoError(t, err)
+		assert.NotEqual(t, e1, e3)
+	}
+	// deterministic encryption is the same for another key schedule with the same kdf
+	e1, err := EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext),
+		WithDeterministic(), WithKeySchedule(ks))
+	assert.NoError(t, err)
+	ks2 := crypto.NewKeySchedule(ks.KDF())
+	e2, err := EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext),
+		WithDeterministic(), WithKeySchedule(ks2))
+	assert.NoError(t, err)
+	assert.Equal(t, e1, e2)
+	// encryption is not deterministic by default
+	e1, err = EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext))
+	assert.NoError(t, err)
+	e2, err = EncryptSIV(crypto.Key256, []byte(secret), []byte(plaintext))
+	assert.NoError(t, err)
+	assert.NotEqual(t, e1, e2)
+	// other modes do not support deterministic encryption
+	_, err = EncryptGCM(crypto.Key256, []byte(secret), []byte(plaintext), WithDeterministic())
+	assert.Error(t, err)
+	_, err = EncryptCTR(crypto.Key256, []byte(secret), []byte(plaintext), WithDeterministic())
+	assert.Error(t, err)
+	// data encrypted with another mode is not decrypted
+	e1, err = EncryptGCM(crypto.Key256, []byte(secret), []byte(plaintext))
+	assert.NoError(t, err)
+	_, err = DecryptSIV(crypto.Key256, []byte(secret), e1)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package aes
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 
 	"github.com/jrapoport/chestnut/encryptor/crypto"
 )
@@ -24,32 +25,43 @@ func newStreamHeader(keyLen crypto.KeyLen, mode crypto.Mode) (crypto.Header, err
 
 // xorStreamEncrypt is a generic function for AES XOR stream encryption ciphers.
 func xorStreamEncrypt(keyLen crypto.KeyLen, mode crypto.Mode, secret,
-	plaintext []byte, newEncryptor streamCipher) ([]byte, error) {
+	plaintext []byte, newEncryptor streamCipher, opts Options) ([]byte, error) {
 	// create the header
 	header, err := newStreamHeader(keyLen, mode)
 	if err != nil {
 		return nil, err
 	}
 	// encrypt the data
-	encryptStream := func(_ crypto.Header, block cipher.Block, _ []byte) ([]byte, error) {
+	encryptStream := func(_ crypto.Header, key []byte, _ []byte) ([]byte, error) {
+		block, blockErr := newBlock(key)
+		if blockErr != nil {
+			return nil, blockErr
+		}
 		ciphertext := make([]byte, len(plaintext))
 		stream := newEncryptor(block, header.IV)
 		stream.XORKeyStream(ciphertext, plaintext)
 		return ciphertext, nil
 	}
-	return encrypt(keyLen, secret, plaintext, header, encryptStream)
+	return encrypt(keyLen, secret, plaintext, header, encryptStream, opts)
 }
 
 // xorStreamDecrypt is a generic function for AES XOR stream decryption ciphers.
 func xorStreamDecrypt(keyLen crypto.KeyLen, _ crypto.Mode, secret,
-	ciphertext []byte, newDecrypter streamCipher) ([]byte, error) {
+	ciphertext []byte, newDecrypter streamCipher, opts Options) ([]byte, error) {
 	// decrypt the data
-	var decryptStream = func(header crypto.Header, block cipher.Block, data []byte) ([]byte, error) {
+	var decryptStream = func(header crypto.Header, key []byte, data []byte) ([]byte, error) {
+		block, err := newBlock(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(header.IV) != block.BlockSize() {
+			return nil, errors.New("invalid iv")
+		}
 		plaintext := make([]byte, len(data))
 		stream := newDecrypter(block, header.IV)
 		stream.XORKeyStream(plaintext, data)
 		// return the plain data
 		return plaintext, nil
 	}
-	return decrypt(keyLen, secret, ciphertext, decryptStream)
+	return decrypt(keyLen, secret, ciphertext, decryptStream, opts)
 }
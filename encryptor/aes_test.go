@@ -21,7 +21,7 @@ var (
 )
 
 func testAESEncryptor(t *testing.T, secret crypto.Secret, keyLen crypto.KeyLen, mode crypto.Mode) {
-	ae := &AESEncryptor{secret, keyLen, mode}
+	ae := NewAESEncryptor(keyLen, mode, secret)
 	assert.Equal(t, secret.ID(), ae.ID())
 	assert.Equal(t, crypto.CipherName("aes", keyLen, mode), ae.Name())
 	e, err := ae.Encrypt([]byte(testPlainText))
@@ -46,6 +46,7 @@ func TestAESEncryptor(t *testing.T) {
 		aes.CFB,
 		aes.CTR,
 		aes.GCM,
+		aes.SIV,
 	}
 	keyLens := []crypto.KeyLen{
 		crypto.Key128,
@@ -73,7 +74,7 @@ func TestAESEncryptor(t *testing.T) {
 	}
 	// load a bad cipher
 	const invalidMode = "Invalid_Cipher_Mode"
-	ae := &AESEncryptor{textSecret, crypto.Key128, invalidMode}
+	ae := NewAESEncryptor(crypto.Key128, invalidMode, textSecret)
 	t.Run(fmt.Sprintf("%s_%s", invalidMode, "Encrypt"), func(t *testing.T) {
 		// try to encrypt with a bad cipher
 		_, err := ae.Encrypt([]byte(testPlainText))
@@ -85,3 +86,125 @@ func TestAESEncryptor(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestAESEncryptor_KDF(t *testing.T) {
+	kdf := crypto.ScryptKDF{N: 1024, R: 8, P: 1}
+	ae := NewAESEncryptor(crypto.Key256, aes.GCM, textSecret, WithKDF(kdf))
+	e, err := ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	header, err := crypto.DecodeHeader(e)
+	assert.NoError(t, err)
+	assert.Equal(t, kdf.ID(), header.KDF)
+	// data is decrypted with the kdf in its header
+	d, err := NewAESEncryptor(crypto.Key256, aes.GCM, textSecret).Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// legacy data still decrypts
+	legacy, err := aes.EncryptGCM(crypto.Key256, textSecret.Open(), []byte(testPlainText))
+	assert.NoError(t, err)
+	d, err = ae.Decrypt(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// the secret must match
+	_, err = NewAESEncryptor(crypto.Key256, aes.GCM, managedSecret, WithKDF(kdf)).Decrypt(e)
+	assert.Error(t, err)
+	// data written with other kdfs and parameters still decrypts
+	kdfs := []crypto.KDF{
+		crypto.ScryptKDF{N: 2048, R: 8, P: 1},
+		crypto.PBKDF2KDF{Iterations: 1000},
+		crypto.Argon2idKDF{Time: 1, Memory: 64, Threads: 2},
+	}
+	for _, k := range kdfs {
+		e2, err := NewAESEncryptor(crypto.Key256, aes.GCM, textSecret, WithKDF(k)).
+			Encrypt([]byte(testPlainText))
+		assert.NoError(t, err)
+		header, err = crypto.DecodeHeader(e2)
+		assert.NoError(t, err)
+		assert.Equal(t, k.ID(), header.KDF)
+		d, err = ae.Decrypt(e2)
+		assert.NoError(t, err)
+		assert.Equal(t, testPlainText, string(d))
+	}
+	// an invalid kdf fails
+	ae = NewAESEncryptor(crypto.Key256, aes.GCM, textSecret, WithKDF(crypto.ScryptKDF{N: 3}))
+	_, err = ae.Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+}
+
+func TestAESEncryptor_Deterministic(t *testing.T) {
+	kdf := crypto.ScryptKDF{N: 1024, R: 8, P: 1}
+	ae := NewAESEncryptor(crypto.Key256, aes.SIV, textSecret, WithKDF(kdf), WithDeterministic())
+	e1, err := ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	// the ciphertext is the same for another encryptor with the same secret and kdf
+	e2, err := NewAESEncryptor(crypto.Key256, aes.SIV, textSecret, WithKDF(kdf), WithDeterministic()).
+		Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	assert.Equal(t, e1, e2)
+	d, err := NewAESEncryptor(crypto.Key256, aes.SIV, textSecret).Decrypt(e1)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// the ciphertext depends on the secret
+	e2, err = NewAESEncryptor(crypto.Key256, aes.SIV, managedSecret, WithKDF(kdf), WithDeterministic()).
+		Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	assert.NotEqual(t, e1, e2)
+	// encryption is not deterministic without the option
+	ae = NewAESEncryptor(crypto.Key256, aes.SIV, textSecret, WithKDF(kdf))
+	e1, err = ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	e2, err = ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	assert.NotEqual(t, e1, e2)
+	// only aes-siv supports deterministic encryption
+	_, err = NewAESEncryptor(crypto.Key256, aes.GCM, textSecret, WithDeterministic()).
+		Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+}
+
+func TestAESEncryptor_Legacy(t *testing.T) {
+	ae := NewAESEncryptor(crypto.Key256, aes.CTR, textSecret)
+	e, err := ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	data, err := crypto.DecodeData(e)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data.MAC)
+	// data encrypted without a mac
+	data.MAC = nil
+	legacy, err := crypto.EncodeData(data)
+	assert.NoError(t, err)
+	_, err = ae.Decrypt(legacy)
+	assert.Error(t, err)
+	d, err := NewAESEncryptor(crypto.Key256, aes.CTR, textSecret, WithLegacyUnauthenticated()).Decrypt(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+}
+
+func BenchmarkAESEncryptor(b *testing.B) {
+	ae := NewAESEncryptor(crypto.Key256, aes.GCM, textSecret)
+	plaintext := []byte(testPlainText)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e, err := ae.Encrypt(plaintext)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = ae.Decrypt(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAESEncryptor_Legacy(b *testing.B) {
+	secret := textSecret.Open()
+	plaintext := []byte(testPlainText)
+	for i := 0; i < b.N; i++ {
+		e, err := aes.EncryptGCM(crypto.Key256, secret, plaintext)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = aes.DecryptGCM(crypto.Key256, secret, e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
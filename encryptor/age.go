@@ -0,0 +1,117 @@
+package encryptor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// AgeX25519 is the mode of an AgeEncryptor.
+const AgeX25519 crypto.Mode = "x25519"
+
+// AgeEncryptor is a public-key encryptor that encrypts data to one or more age X25519
+// recipients (https://age-encryption.org/v1). The data can only be decrypted with the
+// identity (private key) of one of the recipients, so a writer that only knows the
+// recipients cannot read the data it encrypted. The encrypted data is an age file,
+// which means it can also be decrypted with the age command line tool:
+//
+//	age --decrypt -i key.txt value.age
+//
+// Recipients are age public keys e.g. "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p".
+// The identity is a crypto.Secret that opens to an age secret key, e.g. "AGE-SECRET-KEY-1...".
+type AgeEncryptor struct {
+	id         string
+	identity   crypto.Secret
+	recipients []age.Recipient
+	err        error
+}
+
+var _ crypto.Encryptor = (*AgeEncryptor)(nil)
+
+// NewAgeEncryptor returns a new AgeEncryptor that encrypts to the recipients and decrypts
+// with the identity. If the identity is nil, the AgeEncryptor can only encrypt. Otherwise,
+// the data is also encrypted to the recipient of the identity. If any recipient or the
+// identity is not valid, Encrypt and Decrypt return an error.
+func NewAgeEncryptor(identity crypto.Secret, recipients ...string) *AgeEncryptor {
+	ae := new(AgeEncryptor)
+	ae.identity = identity
+	if identity != nil {
+		id, err := age.ParseX25519Identity(string(identity.Open()))
+		if err != nil {
+			ae.err = fmt.Errorf("invalid age identity: %w", err)
+			return ae
+		}
+		recipients = append([]string{id.Recipient().String()}, recipients...)
+	}
+	ids := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			ae.err = fmt.Errorf("invalid age recipient %s: %w", r, err)
+			return ae
+		}
+		ae.recipients = append(ae.recipients, recipient)
+		ids = append(ids, recipient.String())
+	}
+	if len(ae.recipients) == 0 {
+		ae.err = errors.New("age recipient required")
+	}
+	ae.id = strings.Join(ids, ",")
+	return ae
+}
+
+// ID returns a list of the recipients that the data is encrypted to separated by commas.
+func (e *AgeEncryptor) ID() string {
+	return e.id
+}
+
+// Name returns the name of the age cipher "age128-x25519".
+func (e *AgeEncryptor) Name() string {
+	return crypto.CipherName("age", crypto.Key128, AgeX25519)
+}
+
+// Encrypt returns the plain data encrypted to the recipients as an age file.
+func (e *AgeEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if len(plaintext) <= 0 {
+		return nil, errors.New("invalid plain data")
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, e.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt returns the age file decrypted with the identity.
+func (e *AgeEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.identity == nil {
+		return nil, errors.New("age identity required to decrypt")
+	}
+	identity, err := age.ParseX25519Identity(string(e.identity.Open()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %w", err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
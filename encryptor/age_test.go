@@ -0,0 +1,81 @@
+package encryptor
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/google/uuid"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAgeIdentity(t *testing.T) (*age.X25519Identity, crypto.Secret) {
+	id, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	return id, crypto.NewManagedSecret(uuid.New().String(), id.String())
+}
+
+func TestAgeEncryptor(t *testing.T) {
+	id1, secret1 := newAgeIdentity(t)
+	id2, secret2 := newAgeIdentity(t)
+	_, secret3 := newAgeIdentity(t)
+	ae := NewAgeEncryptor(secret1, id2.Recipient().String())
+	assert.Equal(t, id1.Recipient().String()+","+id2.Recipient().String(), ae.ID())
+	assert.Equal(t, "age128-x25519", ae.Name())
+	e, err := ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	d, err := ae.Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// the data can be decrypted by any recipient
+	d, err = NewAgeEncryptor(secret2).Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	_, err = NewAgeEncryptor(secret3).Decrypt(e)
+	assert.Error(t, err)
+	// the data is an age file
+	r, err := age.Decrypt(bytes.NewReader(e), id2)
+	assert.NoError(t, err)
+	d, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// writers without an identity cannot decrypt
+	writer := NewAgeEncryptor(nil, id1.Recipient().String())
+	assert.Equal(t, id1.Recipient().String(), writer.ID())
+	e, err = writer.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	_, err = writer.Decrypt(e)
+	assert.Error(t, err)
+	d, err = ae.Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// chained encryption
+	chain := NewChainEncryptor(NewAESEncryptor(crypto.Key256, aes.GCM, textSecret), ae)
+	e, err = chain.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	d, err = chain.Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// bad data
+	_, err = ae.Encrypt(nil)
+	assert.Error(t, err)
+	_, err = ae.Decrypt([]byte("bad"))
+	assert.Error(t, err)
+}
+
+func TestAgeEncryptor_Invalid(t *testing.T) {
+	invalid := []*AgeEncryptor{
+		NewAgeEncryptor(nil),
+		NewAgeEncryptor(nil, "age1-bad-recipient"),
+		NewAgeEncryptor(textSecret),
+	}
+	for _, ae := range invalid {
+		_, err := ae.Encrypt([]byte(testPlainText))
+		assert.Error(t, err)
+		_, err = ae.Decrypt([]byte(testPlainText))
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,124 @@
+package encryptor
+
+import (
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encryptor/chacha"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// ChaChaEncryptor is an encryptor that supports the following ciphers:
+//   - ChaCha20-Poly1305 (chacha.ChaCha20) with a 12 byte nonce
+//   - XChaCha20-Poly1305 (chacha.XChaCha20) with a 24 byte nonce
+//
+// ChaCha20-Poly1305 is fast without AES hardware acceleration, and unlike
+// AES-CFB and AES-CTR, it authenticates the data. The random 24 byte nonce of
+// XChaCha20-Poly1305 can safely be used for any number of values. Cipher keys
+// are derived the same way as an AESEncryptor (SEE: crypto.KeySchedule).
+type ChaChaEncryptor struct {
+	secret crypto.Secret
+	cipher string
+	kdf    crypto.KDF
+	keys   *crypto.KeySchedule
+}
+
+var _ crypto.Encryptor = (*ChaChaEncryptor)(nil)
+
+// A ChaChaOption sets options such as the KDF of a ChaChaEncryptor.
+type ChaChaOption interface {
+	apply(*ChaChaEncryptor)
+}
+
+// chachaFuncOption wraps a function that modifies a ChaChaEncryptor
+// into an implementation of the ChaChaOption interface.
+type chachaFuncOption struct {
+	f func(*ChaChaEncryptor)
+}
+
+// apply applies a ChaChaOption to a ChaChaEncryptor.
+func (fdo *chachaFuncOption) apply(do *ChaChaEncryptor) {
+	fdo.f(do)
+}
+
+func newChaChaFuncOption(f func(*ChaChaEncryptor)) *chachaFuncOption {
+	return &chachaFuncOption{
+		f: f,
+	}
+}
+
+// WithChaChaKDF returns a ChaChaOption that sets the KDF used to derive the master key
+// from the secret for new data. If kdf is nil, crypto.DefaultKDF is used. Data is always
+// decrypted with the KDF recorded in its header.
+func WithChaChaKDF(kdf crypto.KDF) ChaChaOption {
+	return newChaChaFuncOption(func(e *ChaChaEncryptor) {
+		e.kdf = kdf
+	})
+}
+
+// NewChaChaEncryptor returns a new ChaChaEncryptor configured with
+// a cipher (chacha.ChaCha20 or chacha.XChaCha20) for a secret.
+func NewChaChaEncryptor(cipher string, secret crypto.Secret, opt ...ChaChaOption) *ChaChaEncryptor {
+	ce := new(ChaChaEncryptor)
+	ce.secret = secret
+	ce.cipher = cipher
+	for _, o := range opt {
+		o.apply(ce)
+	}
+	ce.keys = crypto.NewKeySchedule(ce.kdf)
+	return ce
+}
+
+// ID returns the id of the encryptor (secret) that
+// was used to encrypt the data (for tracking).
+func (e *ChaChaEncryptor) ID() string {
+	return e.secret.ID()
+}
+
+// Name returns the name of the configured cipher in following
+// format "[cipher][key length]-[mode]" e.g. "xchacha256-poly1305".
+func (e *ChaChaEncryptor) Name() string {
+	return crypto.CipherName(e.cipher, chacha.KeyLen, chacha.Poly1305)
+}
+
+// Encrypt returns the plain data encrypted with the configured cipher and secret.
+func (e *ChaChaEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	encryptCall, err := chachaEncryptCall(e.cipher)
+	if err != nil {
+		return nil, err
+	}
+	return encryptCall(e.secret.Open(), plaintext,
+		chacha.WithSecretID(e.secret.ID()), chacha.WithKeySchedule(e.keys))
+}
+
+// Decrypt returns the cipher data decrypted with the configured cipher and secret.
+func (e *ChaChaEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	decryptCall, err := chachaDecryptCall(e.cipher)
+	if err != nil {
+		return nil, err
+	}
+	return decryptCall(e.secret.Open(), ciphertext, chacha.WithKeySchedule(e.keys))
+}
+
+// chachaEncryptCall returns the encryption call for the cipher.
+func chachaEncryptCall(cipher string) (chacha.CipherCall, error) {
+	switch cipher {
+	case chacha.ChaCha20:
+		return chacha.EncryptChaCha20, nil
+	case chacha.XChaCha20:
+		return chacha.EncryptXChaCha20, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption cipher: %s", cipher)
+	}
+}
+
+// chachaDecryptCall returns the decryption call for the cipher.
+func chachaDecryptCall(cipher string) (chacha.CipherCall, error) {
+	switch cipher {
+	case chacha.ChaCha20:
+		return chacha.DecryptChaCha20, nil
+	case chacha.XChaCha20:
+		return chacha.DecryptXChaCha20, nil
+	default:
+		return nil, fmt.Errorf("unsupported decryption cipher: %s", cipher)
+	}
+}
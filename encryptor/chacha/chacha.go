@@ -0,0 +1,156 @@
+package chacha
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// currently supported ciphers
+const (
+	ChaCha20  = "chacha"  // ChaCha20-Poly1305 with a 12 byte nonce
+	XChaCha20 = "xchacha" // XChaCha20-Poly1305 with a 24 byte nonce
+)
+
+// Poly1305 is the mode of the supported ciphers.
+const Poly1305 crypto.Mode = "poly1305"
+
+// KeyLen is the key length of the supported ciphers.
+const KeyLen crypto.KeyLen = chacha20poly1305.KeySize
+
+// CipherCall is function the prototype for the encryption and decryption.
+type CipherCall func(secret, data []byte, opt ...Option) ([]byte, error)
+
+var (
+	_ CipherCall = EncryptChaCha20  // EncryptChaCha20 conforms to CipherCall
+	_ CipherCall = DecryptChaCha20  // DecryptChaCha20 conforms to CipherCall
+	_ CipherCall = EncryptXChaCha20 // EncryptXChaCha20 conforms to CipherCall
+	_ CipherCall = DecryptXChaCha20 // DecryptXChaCha20 conforms to CipherCall
+)
+
+// EncryptChaCha20 supports ChaCha20-Poly1305 encryption.
+func EncryptChaCha20(secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	return encrypt(ChaCha20, secret, plaintext, opts)
+}
+
+// DecryptChaCha20 supports ChaCha20-Poly1305 decryption.
+func DecryptChaCha20(secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	return decrypt(ChaCha20, secret, ciphertext, opts)
+}
+
+// EncryptXChaCha20 supports XChaCha20-Poly1305 encryption.
+func EncryptXChaCha20(secret, plaintext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	return encrypt(XChaCha20, secret, plaintext, opts)
+}
+
+// DecryptXChaCha20 supports XChaCha20-Poly1305 decryption.
+func DecryptXChaCha20(secret, ciphertext []byte, opt ...Option) ([]byte, error) {
+	opts := applyOptions(Options{}, opt...)
+	return decrypt(XChaCha20, secret, ciphertext, opts)
+}
+
+// newAEAD returns the AEAD of the cipher for the key.
+func newAEAD(cipherName string, key []byte) (cipher.AEAD, error) {
+	switch cipherName {
+	case ChaCha20:
+		return chacha20poly1305.New(key)
+	case XChaCha20:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %s", cipherName)
+	}
+}
+
+// nonceSize returns the nonce size of the cipher.
+func nonceSize(cipherName string) uint {
+	if cipherName == XChaCha20 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return chacha20poly1305.NonceSize
+}
+
+// newHeader returns a header containing a nonce suitable for the cipher.
+func newHeader(cipherName string) (crypto.Header, error) {
+	salt, err := crypto.MakeSalt()
+	if err != nil {
+		return crypto.Header{}, err
+	}
+	nonce, err := crypto.MakeRand(nonceSize(cipherName))
+	if err != nil {
+		return crypto.Header{}, err
+	}
+	return crypto.NewHeader(cipherName, KeyLen, Poly1305, salt, nil, nonce)
+}
+
+// encrypt is a generalized ChaCha20-Poly1305 encryption function that takes plaintext and return a serialized Entry.
+func encrypt(cipherName string, secret, plaintext []byte, opts Options) ([]byte, error) {
+	if plaintext == nil || len(plaintext) <= 0 {
+		return nil, errors.New("invalid plain data")
+	}
+	// create the header
+	header, err := newHeader(cipherName)
+	if err != nil {
+		return nil, err
+	}
+	header.SecretID = opts.secretID
+	if opts.keys != nil {
+		if err = opts.keys.SetHeader(&header); err != nil {
+			return nil, err
+		}
+	}
+	// create the cipher key
+	key, err := opts.keys.CipherKey(KeyLen, secret, header)
+	if err != nil {
+		return nil, err
+	}
+	// create the AEAD
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+	// encrypt the data
+	data := crypto.NewData(header, aead.Seal(nil, header.Nonce, plaintext, nil))
+	// encode the encrypted data and return the result
+	return crypto.EncodeData(data)
+}
+
+// decrypt is a generalized ChaCha20-Poly1305 decryption function that takes a serialized Entry and returns plaintext.
+func decrypt(cipherName string, secret, ciphertext []byte, opts Options) ([]byte, error) {
+	if ciphertext == nil || len(ciphertext) <= 0 {
+		return nil, errors.New("invalid cipher data")
+	}
+	// decode the encrypted data
+	data, err := crypto.DecodeData(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	// check the encoding
+	if err = data.Valid(); err != nil {
+		return nil, err
+	}
+	// data encrypted with another cipher cannot be decrypted
+	if data.Cipher != cipherName || data.Mode != Poly1305 || data.KeyLen != KeyLen {
+		return nil, fmt.Errorf("invalid cipher: %s", data.Name())
+	}
+	if len(data.Nonce) != int(nonceSize(cipherName)) {
+		return nil, fmt.Errorf("invalid %s nonce", cipherName)
+	}
+	// get the cipher key
+	key, err := opts.keys.CipherKey(KeyLen, secret, data.Header)
+	if err != nil {
+		return nil, err
+	}
+	// get the AEAD
+	aead, err := newAEAD(cipherName, key)
+	if err != nil {
+		return nil, err
+	}
+	// decrypt the data
+	return aead.Open(nil, data.Nonce, data.Bytes, nil)
+}
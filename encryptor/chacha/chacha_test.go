@@ -0,0 +1,93 @@
+package chacha
+
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllCiphers(t *testing.T) {
+	ciphers := []struct {
+		name        string
+		nonceSize   int
+		encryptCall CipherCall
+		decryptCall CipherCall
+	}{
+		{ChaCha20, 12, EncryptChaCha20, DecryptChaCha20},
+		{XChaCha20, 24, EncryptXChaCha20, DecryptXChaCha20},
+	}
+	for _, cipher := range ciphers {
+		t.Run(cipher.name, func(t *testing.T) {
+			testCipher(t, cipher.name, cipher.nonceSize, cipher.encryptCall, cipher.decryptCall)
+		})
+	}
+	// data encrypted with another cipher is not decrypted
+	const secret = "i-am-a-good-secret"
+	encrypted, err := EncryptChaCha20([]byte(secret), []byte(secret))
+	assert.NoError(t, err)
+	_, err = DecryptXChaCha20([]byte(secret), encrypted)
+	assert.Error(t, err)
+	encrypted, err = aes.EncryptGCM(crypto.Key256, []byte(secret), []byte(secret))
+	assert.NoError(t, err)
+	_, err = DecryptChaCha20([]byte(secret), encrypted)
+	assert.Error(t, err)
+}
+
+func testCipher(t *testing.T, name string, nonceSize int, encryptCall, decryptCall CipherCall) {
+	const (
+		secret    = "i-am-a-good-secret"
+		secretID  = "i-am-a-secret-id"
+		plaintext = "Lorem ipsum dolor sit amet"
+	)
+	encrypted, err := encryptCall([]byte(secret), []byte(plaintext), WithSecretID(secretID))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, encrypted)
+	data, err := crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.NoError(t, data.Valid())
+	assert.Equal(t, name, data.Cipher)
+	assert.Equal(t, KeyLen, data.KeyLen)
+	assert.Equal(t, Poly1305, data.Mode)
+	assert.Len(t, data.Nonce, nonceSize)
+	assert.Equal(t, secretID, data.SecretID)
+	assert.Empty(t, data.KDF)
+	decrypted, err := decryptCall([]byte(secret), encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	// key schedule
+	ks := crypto.NewKeySchedule(crypto.ScryptKDF{N: 1024, R: 8, P: 1})
+	encrypted, err = encryptCall([]byte(secret), []byte(plaintext), WithKeySchedule(ks))
+	assert.NoError(t, err)
+	data, err = crypto.DecodeData(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, ks.KDF().ID(), data.KDF)
+	decrypted, err = decryptCall([]byte(secret), encrypted, WithKeySchedule(ks))
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	decrypted, err = decryptCall([]byte(secret), encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, string(decrypted))
+	// the data is authenticated
+	_, err = decryptCall([]byte("i-am-a-bad-secret"), encrypted)
+	assert.Error(t, err)
+	data.Bytes[0] ^= 0xff
+	tampered, err := crypto.EncodeData(data)
+	assert.NoError(t, err)
+	_, err = decryptCall([]byte(secret), tampered)
+	assert.Error(t, err)
+	// bad plain data
+	_, err = encryptCall([]byte(secret), nil)
+	assert.Error(t, err)
+	// bad cipher data
+	badData := [][]byte{
+		nil,
+		[]byte(""),
+		[]byte("bad"),
+	}
+	for _, bd := range badData {
+		_, err = decryptCall([]byte(secret), bd)
+		assert.Error(t, err)
+	}
+}
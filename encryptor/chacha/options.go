@@ -0,0 +1,60 @@
+package chacha
+
+import "github.com/jrapoport/chestnut/encryptor/crypto"
+
+// Options provides the options for ChaCha20-Poly1305 encryption and decryption.
+type Options struct {
+	// secretID is recorded in the header of encrypted data.
+	secretID string
+
+	// keys derives the cipher keys from the secret.
+	keys *crypto.KeySchedule
+}
+
+// An Option sets options such as the secret id.
+type Option interface {
+	apply(*Options)
+}
+
+// funcOption wraps a function that modifies Options
+// into an implementation of the Option interface.
+type funcOption struct {
+	f func(*Options)
+}
+
+// apply applies an Option to Options.
+func (fdo *funcOption) apply(do *Options) {
+	fdo.f(do)
+}
+
+func newFuncOption(f func(*Options)) *funcOption {
+	return &funcOption{
+		f: f,
+	}
+}
+
+// applyOptions accepts a Options struct and applies the Option(s) to it.
+func applyOptions(opts Options, opt ...Option) Options {
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	return opts
+}
+
+// WithSecretID returns an Option that records the id of
+// the secret in the header of the encrypted data.
+func WithSecretID(id string) Option {
+	return newFuncOption(func(o *Options) {
+		o.secretID = id
+	})
+}
+
+// WithKeySchedule returns an Option that derives the cipher keys from the secret with
+// the key schedule (SEE: crypto.KeySchedule). Without a key schedule, the legacy key
+// derivation is used for new data. Data is decrypted with the key derivation recorded
+// in its header either way.
+func WithKeySchedule(ks *crypto.KeySchedule) Option {
+	return newFuncOption(func(o *Options) {
+		o.keys = ks
+	})
+}
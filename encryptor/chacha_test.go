@@ -0,0 +1,85 @@
+package encryptor
+
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/chacha"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaChaEncryptor(t *testing.T) {
+	secrets := []struct {
+		name string
+		crypto.Secret
+	}{
+		{"TextSecret", textSecret},
+		{"ManagedSecret", managedSecret},
+		{"SecureSecret", secureSecret},
+	}
+	for _, cipher := range []string{chacha.ChaCha20, chacha.XChaCha20} {
+		for _, secret := range secrets {
+			t.Run(cipher+"/"+secret.name, func(t *testing.T) {
+				ce := NewChaChaEncryptor(cipher, secret)
+				assert.Equal(t, secret.ID(), ce.ID())
+				assert.Equal(t, crypto.CipherName(cipher, crypto.Key256, "poly1305"), ce.Name())
+				e, err := ce.Encrypt([]byte(testPlainText))
+				assert.NoError(t, err)
+				assert.NotEmpty(t, e)
+				d, err := ce.Decrypt(e)
+				assert.NoError(t, err)
+				assert.Equal(t, testPlainText, string(d))
+			})
+		}
+	}
+	assert.Equal(t, "xchacha256-poly1305", NewChaChaEncryptor(chacha.XChaCha20, textSecret).Name())
+	// load a bad cipher
+	ce := NewChaChaEncryptor("Invalid_Cipher", textSecret)
+	_, err := ce.Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	_, err = ce.Decrypt([]byte(testPlainText))
+	assert.Error(t, err)
+}
+
+func TestChaChaEncryptor_KDF(t *testing.T) {
+	kdf := crypto.PBKDF2KDF{Iterations: 1000}
+	ce := NewChaChaEncryptor(chacha.XChaCha20, textSecret, WithChaChaKDF(kdf))
+	e, err := ce.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	header, err := crypto.DecodeHeader(e)
+	assert.NoError(t, err)
+	assert.Equal(t, kdf.ID(), header.KDF)
+	d, err := NewChaChaEncryptor(chacha.XChaCha20, textSecret).Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+}
+
+func TestChaChaEncryptor_Chain(t *testing.T) {
+	chain := NewChainEncryptor(
+		NewAESEncryptor(crypto.Key256, aes.GCM, textSecret),
+		NewChaChaEncryptor(chacha.ChaCha20, managedSecret),
+		NewChaChaEncryptor(chacha.XChaCha20, secureSecret),
+	)
+	assert.Equal(t, "aes256-gcm chacha256-poly1305 xchacha256-poly1305", chain.Name())
+	e, err := chain.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	d, err := chain.Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+}
+
+func BenchmarkChaChaEncryptor(b *testing.B) {
+	ce := NewChaChaEncryptor(chacha.XChaCha20, textSecret)
+	plaintext := []byte(testPlainText)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e, err := ce.Encrypt(plaintext)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = ce.Decrypt(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
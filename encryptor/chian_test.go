@@ -24,9 +24,9 @@ func TestChainEncryptor_Single(t *testing.T) {
 
 func TestChainEncryptor_Chained(t *testing.T) {
 	encryptors := []crypto.Encryptor{
-		&AESEncryptor{textSecret, crypto.Key128, aes.CFB},
-		&AESEncryptor{managedSecret, crypto.Key192, aes.CTR},
-		&AESEncryptor{secureSecret, crypto.Key256, aes.GCM},
+		NewAESEncryptor(crypto.Key128, aes.CFB, textSecret),
+		NewAESEncryptor(crypto.Key192, aes.CTR, managedSecret),
+		NewAESEncryptor(crypto.Key256, aes.GCM, secureSecret),
 	}
 	chain := NewChainEncryptor(encryptors...)
 	testChainName(t, chain, encryptors)
@@ -12,12 +12,32 @@ import (
 type Data struct {
 	Header
 	Bytes []byte
+	// WrappedKey is the data encryption key used to encrypt Bytes, wrapped
+	// by the key encryption key named in the Header. It is only set when
+	// the data was encrypted using envelope encryption.
+	WrappedKey []byte
+	// Recipients are the data encryption key used to encrypt Bytes, wrapped
+	// separately for each recipient. It is only set when the data was
+	// encrypted for multiple recipients.
+	Recipients []Recipient
+	// MAC is the HMAC-SHA256 of the Header, keys, and Bytes (SEE: NewDataMAC). It
+	// is only set when the data was encrypted with an unauthenticated cipher
+	// mode, e.g. AES-CFB and AES-CTR (encrypt-then-MAC).
+	MAC []byte
+}
+
+// Recipient is a data encryption key wrapped for a recipient of Data.
+type Recipient struct {
+	// ID is the id of the recipient (SEE: Encryptor.ID).
+	ID string
+	// WrappedKey is the data encryption key wrapped by the recipient.
+	WrappedKey []byte
 }
 
 // NewData returns an Data initialized
 // with a Header and encrypted data.
 func NewData(h Header, data []byte) Data {
-	return Data{h, data}
+	return Data{Header: h, Bytes: data}
 }
 
 // Valid returns an error if the Data is not valid.
@@ -65,3 +85,12 @@ func GobDecodeData(b []byte) (Data, error) {
 	d := gob.NewDecoder(&buf)
 	return data, d.Decode(&data)
 }
+
+// DecodeHeader decodes the Header from a byte representation of Data.
+func DecodeHeader(b []byte) (Header, error) {
+	data, err := DecodeData(b)
+	if err != nil {
+		return Header{}, err
+	}
+	return data.Header, nil
+}
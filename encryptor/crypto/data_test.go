@@ -37,7 +37,7 @@ func TestData(t *testing.T) {
 	}
 	for _, test := range tests {
 		data := NewData(Header{test.cipher, test.key, test.mode,
-			test.salt, test.iv, test.nonce}, test.bytes)
+			test.salt, test.iv, test.nonce, "", "", nil}, test.bytes)
 		test.err(t, data.Valid())
 	}
 }
@@ -67,6 +67,20 @@ func TestEncodeData(t *testing.T) {
 	assert.Equal(t, data, dec)
 }
 
+func TestDecodeHeader(t *testing.T) {
+	bytes, err := MakeRand(512)
+	assert.NoError(t, err)
+	h := makeHeader(t)
+	h.SecretID = "secret-id"
+	enc, err := EncodeData(NewData(h, bytes))
+	assert.NoError(t, err)
+	dec, err := DecodeHeader(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, h, dec)
+	_, err = DecodeHeader([]byte("bad"))
+	assert.Error(t, err)
+}
+
 func TestGobEncodeData(t *testing.T) {
 	bytes, err := MakeRand(512)
 	assert.NoError(t, err)
@@ -15,3 +15,17 @@ type Encryptor interface {
 	// Decrypt returns data decrypted with the secret.
 	Decrypt(ciphertext []byte) (plaintext []byte, err error)
 }
+
+// KeyWrapper is the interface used to wrap data encryption keys with a key encryption
+// key for envelope encryption. It can be implemented by an external KMS so that the
+// key encryption key never has to leave it.
+type KeyWrapper interface {
+	// ID returns the id of the key encryption key.
+	ID() string
+
+	// WrapKey returns the data encryption key wrapped with the key encryption key.
+	WrapKey(key []byte) (wrapped []byte, err error)
+
+	// UnwrapKey returns the data encryption key unwrapped with the key encryption key.
+	UnwrapKey(wrapped []byte) (key []byte, err error)
+}
@@ -11,13 +11,20 @@ const MinSaltLength = 8
 
 // A Header describes an encryption block. It contains the cipher name,
 // key length, mode used as well as the cipher key salt, iv or nonce.
+// If it is known, the id of the secret used to encrypt the block is
+// recorded in SecretID so that the secret can be found for decryption.
+// If the cipher key was derived with a KeySchedule, the KDF and salt of
+// the master key are recorded in KDF and KDFSalt.
 type Header struct {
-	Cipher string // e.g. "aes"
-	KeyLen KeyLen // e.g. 128
-	Mode   Mode   // e.g. "gcm"
-	Salt   []byte
-	IV     []byte
-	Nonce  []byte
+	Cipher   string // e.g. "aes"
+	KeyLen   KeyLen // e.g. 128
+	Mode     Mode   // e.g. "gcm"
+	Salt     []byte
+	IV       []byte
+	Nonce    []byte
+	SecretID string
+	KDF      string // e.g. "scrypt:n=32768,r=8,p=1"
+	KDFSalt  []byte
 }
 
 // NewHeader create a new Header checking the length of the
@@ -26,7 +33,7 @@ type Header struct {
 func NewHeader(cipher string, keyLen KeyLen, mode Mode, salt []byte, iv []byte, nonce []byte) (Header, error) {
 	cipher = strings.ToLower(cipher)
 	mode = Mode(strings.ToLower(mode.String()))
-	h := Header{cipher, keyLen, mode, salt, iv, nonce}
+	h := Header{Cipher: cipher, KeyLen: keyLen, Mode: mode, Salt: salt, IV: iv, Nonce: nonce}
 	if err := h.Valid(); err != nil {
 		return Header{}, err
 	}
@@ -50,6 +57,9 @@ func (h Header) Valid() error {
 	if h.Nonce != nil && len(h.Nonce) < NonceLength {
 		return fmt.Errorf("nonce length %d < %d minimum", len(h.Nonce), NonceLength)
 	}
+	if h.KDF != "" && len(h.KDFSalt) < MinSaltLength {
+		return fmt.Errorf("kdf salt length %d < %d minimum", len(h.KDFSalt), MinSaltLength)
+	}
 	return nil
 }
 
@@ -0,0 +1,295 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is a key derivation function that derives the master key of a KeySchedule from a secret.
+type KDF interface {
+	// ID returns the name and parameters of the KDF, e.g. "scrypt:n=32768,r=8,p=1".
+	// The id is recorded in the header of encrypted data so the master key can be
+	// derived again to decrypt it. SEE: ParseKDF.
+	ID() string
+	// Key returns a key of length l derived from the secret and salt.
+	Key(secret, salt []byte, l int) ([]byte, error)
+}
+
+// KDF parameter limits. Data with parameters outside of these limits is not decrypted,
+// so a header cannot make decryption use an unreasonable amount of memory or time.
+// The memory of scrypt (128·N·r bytes) and Argon2id is capped as a whole, since the
+// parameters are read from the header of stored (and possibly tampered) data. The caps
+// are kept close to the largest parameters in real use, since every read of a tampered
+// record derives its master key again.
+const (
+	maxScryptMemory    = 256 << 20 // 256 MiB
+	maxScryptR         = 32
+	maxScryptP         = 16
+	maxPBKDF2Iter      = 1 << 24
+	maxArgon2idTime    = 10
+	maxArgon2idMemory  = 256 << 10 // 256 MiB in KiB
+	maxArgon2idThreads = 255
+)
+
+// ScryptKDF is a KDF that derives keys with scrypt.
+type ScryptKDF struct {
+	N int // CPU and memory cost, a power of 2
+	R int // block size
+	P int // parallelization
+}
+
+var _ KDF = (*ScryptKDF)(nil)
+
+// DefaultKDF is the KDF used to derive master keys if a KDF is not set.
+// It is scrypt with N=32768, r=8, and p=1.
+var DefaultKDF KDF = ScryptKDF{N: 1 << 15, R: 8, P: 1}
+
+// ID returns the name and parameters of the KDF, e.g. "scrypt:n=32768,r=8,p=1".
+func (k ScryptKDF) ID() string {
+	return fmt.Sprintf("scrypt:n=%d,r=%d,p=%d", k.N, k.R, k.P)
+}
+
+// Key returns a key of length l derived from the secret and salt.
+func (k ScryptKDF) Key(secret, salt []byte, l int) ([]byte, error) {
+	if err := k.valid(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key(secret, salt, k.N, k.R, k.P, l)
+}
+
+func (k ScryptKDF) valid() error {
+	if k.N <= 1 || k.N&(k.N-1) != 0 {
+		return fmt.Errorf("invalid scrypt n: %d", k.N)
+	}
+	if k.R <= 0 || k.R > maxScryptR {
+		return fmt.Errorf("invalid scrypt r: %d", k.R)
+	}
+	if k.N > maxScryptMemory/(128*k.R) {
+		return fmt.Errorf("scrypt memory 128*%d*%d exceeds %d bytes", k.N, k.R, maxScryptMemory)
+	}
+	if k.P <= 0 || k.P > maxScryptP {
+		return fmt.Errorf("invalid scrypt p: %d", k.P)
+	}
+	return nil
+}
+
+// PBKDF2KDF is a KDF that derives keys with PBKDF2 and HMAC-SHA512.
+type PBKDF2KDF struct {
+	Iterations int
+}
+
+var _ KDF = (*PBKDF2KDF)(nil)
+
+// ID returns the name and parameters of the KDF, e.g. "pbkdf2-sha512:i=210000".
+func (k PBKDF2KDF) ID() string {
+	return fmt.Sprintf("pbkdf2-sha512:i=%d", k.Iterations)
+}
+
+// Key returns a key of length l derived from the secret and salt.
+func (k PBKDF2KDF) Key(secret, salt []byte, l int) ([]byte, error) {
+	if err := k.valid(); err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(secret, salt, k.Iterations, l, sha512.New), nil
+}
+
+func (k PBKDF2KDF) valid() error {
+	if k.Iterations <= 0 || k.Iterations > maxPBKDF2Iter {
+		return fmt.Errorf("invalid pbkdf2 iterations: %d", k.Iterations)
+	}
+	return nil
+}
+
+// Argon2idKDF is a KDF that derives keys with Argon2id.
+type Argon2idKDF struct {
+	Time    int // number of passes over the memory
+	Memory  int // memory in KiB
+	Threads int // parallelism
+}
+
+var _ KDF = (*Argon2idKDF)(nil)
+
+// ID returns the name and parameters of the KDF, e.g. "argon2id:t=1,m=65536,p=4".
+func (k Argon2idKDF) ID() string {
+	return fmt.Sprintf("argon2id:t=%d,m=%d,p=%d", k.Time, k.Memory, k.Threads)
+}
+
+// Key returns a key of length l derived from the secret and salt.
+func (k Argon2idKDF) Key(secret, salt []byte, l int) ([]byte, error) {
+	if err := k.valid(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(secret, salt, uint32(k.Time), uint32(k.Memory), uint8(k.Threads), uint32(l)), nil
+}
+
+func (k Argon2idKDF) valid() error {
+	if k.Time <= 0 || k.Time > maxArgon2idTime {
+		return fmt.Errorf("invalid argon2id time: %d", k.Time)
+	}
+	if k.Threads <= 0 || k.Threads > maxArgon2idThreads {
+		return fmt.Errorf("invalid argon2id threads: %d", k.Threads)
+	}
+	// argon2 requires at least 8 KiB of memory for each thread
+	if k.Memory < 8*k.Threads || k.Memory > maxArgon2idMemory {
+		return fmt.Errorf("invalid argon2id memory: %d", k.Memory)
+	}
+	return nil
+}
+
+// ParseKDF returns the KDF for an id returned by KDF.ID.
+func ParseKDF(id string) (KDF, error) {
+	name, list, _ := strings.Cut(id, ":")
+	params := map[string]int{}
+	for _, param := range strings.Split(list, ",") {
+		if param == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kdf parameter: %s", param)
+		}
+		params[key] = n
+	}
+	var kdf KDF
+	var err error
+	switch name {
+	case "scrypt":
+		k := ScryptKDF{N: params["n"], R: params["r"], P: params["p"]}
+		kdf, err = k, k.valid()
+	case "pbkdf2-sha512":
+		k := PBKDF2KDF{Iterations: params["i"]}
+		kdf, err = k, k.valid()
+	case "argon2id":
+		k := Argon2idKDF{Time: params["t"], Memory: params["m"], Threads: params["p"]}
+		kdf, err = k, k.valid()
+	default:
+		return nil, fmt.Errorf("unsupported kdf: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
+
+// masterKeyLen is the length of a master key.
+const masterKeyLen = 32
+
+// maxMasterKeys is the most master keys a KeySchedule keeps.
+const maxMasterKeys = 64
+
+// cipherKeyInfo binds a cipher key to the cipher it is derived for.
+const cipherKeyInfo = "chestnut cipher key "
+
+// KeySchedule derives the cipher keys of encrypted data from a secret. Instead of
+// running the KDF for each block of data, a master key is derived from the secret
+// with the KDF and a random master salt once, and the cipher key of each block is
+// derived from the master key and the salt of the block with HKDF. The KDF and the
+// master salt are recorded in the header (SEE: SetHeader). Master keys are kept in
+// memory, so a KeySchedule should be reused for as long as the secret is used.
+// Data with a header that does not name a KDF uses the legacy key derivation (SEE:
+// NewCipherKey). A nil KeySchedule derives keys the same way, without keeping them.
+type KeySchedule struct {
+	kdf  KDF
+	mu   sync.Mutex
+	salt []byte
+	keys map[string][]byte
+}
+
+// NewKeySchedule returns a new KeySchedule that derives master keys for new data with
+// the kdf. If kdf is nil, DefaultKDF is used.
+func NewKeySchedule(kdf KDF) *KeySchedule {
+	if kdf == nil {
+		kdf = DefaultKDF
+	}
+	return &KeySchedule{kdf: kdf, keys: map[string][]byte{}}
+}
+
+// KDF returns the KDF used to derive master keys for new data.
+func (ks *KeySchedule) KDF() KDF {
+	return ks.kdf
+}
+
+// SetHeader records the KDF and master salt used for new data in the header.
+func (ks *KeySchedule) SetHeader(h *Header) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.salt == nil {
+		salt, err := MakeSalt()
+		if err != nil {
+			return err
+		}
+		ks.salt = salt
+	}
+	h.KDF = ks.kdf.ID()
+	h.KDFSalt = ks.salt
+	return nil
+}
+
+// CipherKey returns the cipher key of length l for the data with the header.
+func (ks *KeySchedule) CipherKey(l KeyLen, secret []byte, h Header) ([]byte, error) {
+	if h.KDF == "" {
+		return NewCipherKey(l, secret, h.Salt)
+	}
+	if len(h.KDFSalt) < MinSaltLength {
+		return nil, fmt.Errorf("kdf salt length %d < %d minimum", len(h.KDFSalt), MinSaltLength)
+	}
+	master, err := ks.masterKey(secret, h.KDF, h.KDFSalt)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, l)
+	info := []byte(cipherKeyInfo + h.Name())
+	if _, err = io.ReadFull(hkdf.New(sha256.New, master, h.Salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// masterKey returns the master key derived from the secret with the kdf and salt.
+func (ks *KeySchedule) masterKey(secret []byte, kdfID string, salt []byte) ([]byte, error) {
+	if len(secret) <= 0 {
+		return nil, errors.New("secret cannot be empty")
+	}
+	sum := sha256.Sum256(secret)
+	id := string(sum[:]) + kdfID + "\x00" + string(salt)
+	if ks != nil {
+		ks.mu.Lock()
+		master, ok := ks.keys[id]
+		ks.mu.Unlock()
+		if ok {
+			return master, nil
+		}
+	}
+	kdf, err := ParseKDF(kdfID)
+	if err != nil {
+		return nil, err
+	}
+	master, err := kdf.Key(secret, salt, masterKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	if ks != nil {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		for k := range ks.keys {
+			if len(ks.keys) < maxMasterKeys {
+				break
+			}
+			delete(ks.keys, k)
+		}
+		ks.keys[id] = master
+	}
+	return master, nil
+}
@@ -0,0 +1,158 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKDF = ScryptKDF{N: 1024, R: 8, P: 1}
+
+func TestParseKDF(t *testing.T) {
+	// the memory limits are inclusive
+	for _, id := range []string{"scrypt:n=262144,r=8,p=1", "scrypt:n=1048576,r=2,p=1", "argon2id:t=10,m=262144,p=1"} {
+		_, err := ParseKDF(id)
+		assert.NoError(t, err, id)
+	}
+	kdf, err := ParseKDF(DefaultKDF.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultKDF, kdf)
+	assert.Equal(t, "scrypt:n=1024,r=8,p=1", testKDF.ID())
+	kdfs := []KDF{
+		testKDF,
+		PBKDF2KDF{Iterations: 1000},
+		Argon2idKDF{Time: 1, Memory: 64, Threads: 2},
+	}
+	for _, k := range kdfs {
+		kdf, err = ParseKDF(k.ID())
+		assert.NoError(t, err)
+		assert.Equal(t, k, kdf)
+	}
+	assert.Equal(t, "pbkdf2-sha512:i=1000", kdfs[1].ID())
+	assert.Equal(t, "argon2id:t=1,m=64,p=2", kdfs[2].ID())
+	invalid := []string{
+		"",
+		"md5:n=1",
+		"scrypt",
+		"scrypt:n=1000,r=8,p=1",
+		"scrypt:n=1024,r=0,p=1",
+		"scrypt:n=1024,r=8,p=0",
+		"scrypt:n=1073741824,r=8,p=1",
+		"scrypt:n=4194304,r=32,p=1",
+		"scrypt:n=524288,r=8,p=1",
+		"scrypt:n=x,r=8,p=1",
+		"pbkdf2-sha512",
+		"pbkdf2-sha512:i=0",
+		"pbkdf2-sha512:i=1073741824",
+		"argon2id:t=0,m=64,p=1",
+		"argon2id:t=1,m=4,p=1",
+		"argon2id:t=1,m=64,p=0",
+		"argon2id:t=1,m=64,p=256",
+		"argon2id:t=1,m=1073741824,p=1",
+		"argon2id:t=1,m=4194304,p=1",
+		"argon2id:t=1,m=1048577,p=1",
+		"argon2id:t=1,m=262145,p=1",
+		"argon2id:t=11,m=64,p=1",
+	}
+	for _, id := range invalid {
+		_, err = ParseKDF(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestKDF_Key(t *testing.T) {
+	sec := []byte(secret)
+	salt, err := MakeSalt()
+	assert.NoError(t, err)
+	kdfs := []KDF{
+		testKDF,
+		PBKDF2KDF{Iterations: 1000},
+		Argon2idKDF{Time: 1, Memory: 64, Threads: 2},
+	}
+	keys := map[string]bool{}
+	for _, kdf := range kdfs {
+		key, err := kdf.Key(sec, salt, masterKeyLen)
+		assert.NoError(t, err)
+		assert.Len(t, key, masterKeyLen)
+		key2, err := kdf.Key(sec, salt, masterKeyLen)
+		assert.NoError(t, err)
+		assert.Equal(t, key, key2)
+		keys[string(key)] = true
+	}
+	assert.Len(t, keys, len(kdfs))
+	// the pbkdf2 kdf matches NewPBKDF2CipherKey
+	key, err := PBKDF2KDF{Iterations: 1000}.Key(sec, salt, int(Key256))
+	assert.NoError(t, err)
+	pbKey, err := NewPBKDF2CipherKey(Key256, 1000, sec, salt)
+	assert.NoError(t, err)
+	assert.Equal(t, pbKey, key)
+	invalid := []KDF{
+		ScryptKDF{},
+		PBKDF2KDF{},
+		Argon2idKDF{},
+		Argon2idKDF{Time: maxArgon2idTime + 1, Memory: 64, Threads: 2},
+		Argon2idKDF{Time: 1, Memory: maxArgon2idMemory + 1, Threads: 2},
+	}
+	for _, kdf := range invalid {
+		_, err = kdf.Key(sec, salt, masterKeyLen)
+		assert.Error(t, err)
+	}
+}
+
+func TestKeySchedule(t *testing.T) {
+	sec := []byte(secret)
+	ks := NewKeySchedule(testKDF)
+	assert.Equal(t, testKDF, ks.KDF())
+	assert.Equal(t, DefaultKDF, NewKeySchedule(nil).KDF())
+	h := makeHeader(t)
+	err := ks.SetHeader(&h)
+	assert.NoError(t, err)
+	assert.Equal(t, testKDF.ID(), h.KDF)
+	assert.Len(t, h.KDFSalt, SaltLength)
+	assert.NoError(t, h.Valid())
+	key1, err := ks.CipherKey(Key256, sec, h)
+	assert.NoError(t, err)
+	assert.Len(t, key1, int(Key256))
+	// the master key is derived once
+	h2 := makeHeader(t)
+	err = ks.SetHeader(&h2)
+	assert.NoError(t, err)
+	assert.Equal(t, h.KDFSalt, h2.KDFSalt)
+	key2, err := ks.CipherKey(Key256, sec, h2)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key1, key2)
+	assert.Len(t, ks.keys, 1)
+	// the key is the same without the key schedule
+	key, err := (*KeySchedule)(nil).CipherKey(Key256, sec, h)
+	assert.NoError(t, err)
+	assert.Equal(t, key1, key)
+	// the key is bound to the cipher
+	h.Mode = "ctr"
+	key, err = ks.CipherKey(Key256, sec, h)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key1, key)
+	// headers without a kdf use the legacy key derivation
+	legacy := makeHeader(t)
+	key, err = ks.CipherKey(Key256, sec, legacy)
+	assert.NoError(t, err)
+	legacyKey, err := NewCipherKey(Key256, sec, legacy.Salt)
+	assert.NoError(t, err)
+	assert.Equal(t, legacyKey, key)
+	// invalid headers
+	h.KDFSalt = nil
+	assert.Error(t, h.Valid())
+	_, err = ks.CipherKey(Key256, sec, h)
+	assert.Error(t, err)
+	h.KDF = "md5:n=1"
+	h.KDFSalt = h2.KDFSalt
+	_, err = ks.CipherKey(Key256, sec, h)
+	assert.Error(t, err)
+	_, err = ks.CipherKey(Key256, nil, h2)
+	assert.Error(t, err)
+	// headers cannot make decryption use an unreasonable amount of memory
+	for _, id := range []string{"scrypt:n=4194304,r=32,p=16", "argon2id:t=64,m=4194304,p=255"} {
+		h2.KDF = id
+		_, err = NewKeySchedule(nil).CipherKey(Key256, sec, h2)
+		assert.Error(t, err, id)
+	}
+}
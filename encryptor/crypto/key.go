@@ -30,7 +30,8 @@ func (k KeyLen) String() string {
 	}
 }
 
-// NewCipherKey generate a new cipher key of the appropriate key length.
+// NewCipherKey generate a new cipher key of the appropriate key length. It is the legacy
+// key derivation used for data with a header that does not name a KDF (SEE: KeySchedule).
 // Note: Currently this is hard-coded to 4096 key iterations. The thinking here is that
 // the strength of secret was determined externally and therefore it less important to
 // iterate (again) a large number of times. 1<<15 (or 32768) key iterations, seems to
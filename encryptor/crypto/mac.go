@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// MACKeyLength is the length of a MAC key.
+const MACKeyLength = 32
+
+// macKeyInfo binds a MAC key to the cipher it is derived for.
+const macKeyInfo = "chestnut hmac-sha256 key "
+
+// ErrMACMismatch the MAC of the encrypted data does not match.
+var ErrMACMismatch = errors.New("message authentication failed")
+
+// NewMACKey returns the HMAC-SHA256 key for the data with the header. The MAC key
+// is derived from the cipher key with HKDF, so the two keys are not the same.
+func NewMACKey(cipherKey []byte, h Header) ([]byte, error) {
+	if len(cipherKey) <= 0 {
+		return nil, errors.New("cipher key cannot be empty")
+	}
+	key := make([]byte, MACKeyLength)
+	info := []byte(macKeyInfo + h.Name())
+	if _, err := io.ReadFull(hkdf.New(sha256.New, cipherKey, h.Salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewDataMAC returns the HMAC-SHA256 of the header fields, the wrapped keys, and the
+// encrypted bytes of the data. Everything but the MAC itself is authenticated, so a wrapped
+// key or recipient cannot be swapped or removed without the MAC failing. Each field is
+// written explicitly, so the MAC does not change if fields are added to the Header.
+func NewDataMAC(macKey []byte, data Data) ([]byte, error) {
+	mac := hmac.New(sha256.New, macKey)
+	h := data.Header
+	writeMACField(mac, []byte(h.Cipher))
+	writeMACField(mac, binary.BigEndian.AppendUint64(nil, uint64(h.KeyLen)))
+	writeMACField(mac, []byte(h.Mode))
+	writeMACField(mac, h.Salt)
+	writeMACField(mac, h.IV)
+	writeMACField(mac, h.Nonce)
+	writeMACField(mac, []byte(h.SecretID))
+	writeMACField(mac, []byte(h.KDF))
+	writeMACField(mac, h.KDFSalt)
+	writeMACField(mac, data.WrappedKey)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(data.Recipients))))
+	for _, r := range data.Recipients {
+		writeMACField(mac, []byte(r.ID))
+		writeMACField(mac, r.WrappedKey)
+	}
+	mac.Write(data.Bytes)
+	return mac.Sum(nil), nil
+}
+
+// writeMACField writes the length of b followed by b, so the fields cannot run together.
+func writeMACField(w io.Writer, b []byte) {
+	_, _ = w.Write(binary.BigEndian.AppendUint64(nil, uint64(len(b))))
+	_, _ = w.Write(b)
+}
+
+// VerifyDataMAC returns ErrMACMismatch if the MAC of the data does not match, in constant time.
+func VerifyDataMAC(macKey []byte, data Data) error {
+	mac, err := NewDataMAC(macKey, data)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, data.MAC) {
+		return ErrMACMismatch
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataMAC(t *testing.T) {
+	h := makeHeader(t)
+	cipherKey, err := MakeRand(uint(Key256))
+	assert.NoError(t, err)
+	macKey, err := NewMACKey(cipherKey, h)
+	assert.NoError(t, err)
+	assert.Len(t, macKey, MACKeyLength)
+	assert.NotEqual(t, cipherKey, macKey)
+	key, err := NewMACKey(cipherKey, h)
+	assert.NoError(t, err)
+	assert.Equal(t, macKey, key)
+	_, err = NewMACKey(nil, h)
+	assert.Error(t, err)
+	data := NewData(h, []byte(secret))
+	data.MAC, err = NewDataMAC(macKey, data)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyDataMAC(macKey, data))
+	// the header is authenticated
+	tampered := data
+	tampered.SecretID = "i-am-another-secret"
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	tampered = data
+	tampered.Salt = append([]byte{}, data.Salt...)
+	tampered.Salt[0] ^= 1
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	// the bytes are authenticated
+	tampered = data
+	tampered.Bytes = []byte(secret + "!")
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	// the wrapped keys are authenticated
+	data.WrappedKey = []byte("i-am-a-wrapped-key")
+	data.Recipients = []Recipient{{ID: "i-am-a-recipient", WrappedKey: []byte("i-am-a-wrapped-key")}}
+	data.MAC, err = NewDataMAC(macKey, data)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyDataMAC(macKey, data))
+	tampered = data
+	tampered.WrappedKey = []byte("i-am-another-wrapped-key")
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	tampered = data
+	tampered.Recipients = nil
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	tampered = data
+	tampered.Recipients = []Recipient{{ID: "i-am-another-recipient", WrappedKey: []byte("i-am-a-wrapped-key")}}
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	// the fields cannot run together
+	tampered = data
+	tampered.Recipients = []Recipient{{ID: "i-am-a-recipienti-am-", WrappedKey: []byte("a-wrapped-key")}}
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+	// the key is authenticated
+	otherKey, err := NewMACKey([]byte("i-am-another-key"), h)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, VerifyDataMAC(otherKey, data), ErrMACMismatch)
+	tampered = data
+	tampered.MAC = nil
+	assert.ErrorIs(t, VerifyDataMAC(macKey, tampered), ErrMACMismatch)
+}
+
+func TestDataMAC_Vector(t *testing.T) {
+	h := Header{
+		Cipher:   "aes",
+		KeyLen:   Key256,
+		Mode:     "ctr",
+		Salt:     []byte("i-am-a-salt-1234"),
+		IV:       []byte("i-am-an-iv-12345"),
+		SecretID: "i-am-a-secret-id",
+		KDF:      "scrypt:n=32768,r=8,p=1",
+		KDFSalt:  []byte("i-am-a-kdf-salt!"),
+	}
+	data := NewData(h, []byte(secret))
+	data.WrappedKey = []byte("i-am-a-wrapped-key")
+	data.Recipients = []Recipient{{ID: "i-am-a-recipient", WrappedKey: []byte("i-am-a-wrapped-key")}}
+	macKey, err := NewMACKey([]byte("i-am-a-cipher-key"), h)
+	assert.NoError(t, err)
+	mac, err := NewDataMAC(macKey, data)
+	assert.NoError(t, err)
+	// the mac must not change, or the data already stored will not decrypt
+	assert.Equal(t, "9252d6987a20adeaf15286f65b27d7ef43dae436bfc3dbf21153be4ec614b53e", hex.EncodeToString(mac))
+}
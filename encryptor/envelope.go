@@ -0,0 +1,166 @@
+package encryptor
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// EnvelopeEncryptor is an AES encryptor that supports envelope encryption. Each call
+// to Encrypt generates a new random data encryption key (DEK) which is used to encrypt
+// the data. The DEK is then wrapped by a key encryption key (KEK) and stored alongside
+// the encrypted data. Rotating the KEK only requires the DEKs to be re-wrapped (SEE:
+// Rewrap) instead of re-encrypting the data itself.
+type EnvelopeEncryptor struct {
+	kek    crypto.KeyWrapper
+	keks   map[string]crypto.KeyWrapper
+	keyLen crypto.KeyLen
+	mode   crypto.Mode
+}
+
+var _ crypto.Encryptor = (*EnvelopeEncryptor)(nil)
+
+// NewEnvelopeEncryptor returns a new EnvelopeEncryptor configured with an AES keyLen
+// length and mode. New DEKs are wrapped with the kek. The data can be decrypted with
+// the kek or any of the additional keks, which is useful during a rotation.
+func NewEnvelopeEncryptor(keyLen crypto.KeyLen, mode crypto.Mode,
+	kek crypto.KeyWrapper, keks ...crypto.KeyWrapper) *EnvelopeEncryptor {
+	ee := new(EnvelopeEncryptor)
+	ee.kek = kek
+	ee.keyLen = keyLen
+	ee.mode = mode
+	ee.keks = map[string]crypto.KeyWrapper{}
+	for _, k := range append([]crypto.KeyWrapper{kek}, keks...) {
+		if _, ok := ee.keks[k.ID()]; !ok {
+			ee.keks[k.ID()] = k
+		}
+	}
+	return ee
+}
+
+// ID returns the id of the key encryption key used to wrap the data encryption keys.
+func (e *EnvelopeEncryptor) ID() string {
+	return e.kek.ID()
+}
+
+// Name returns the name of the configured AES encryption cipher
+// in following format "[cipher][keyLen length]-[mode]" e.g. "aes192-ctr".
+func (e *EnvelopeEncryptor) Name() string {
+	return crypto.CipherName("aes", e.keyLen, e.mode)
+}
+
+// Encrypt returns the plain data encrypted with a new data encryption key
+// along with the data encryption key wrapped by the key encryption key.
+func (e *EnvelopeEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	encryptCall, err := aesEncryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := crypto.MakeRand(uint(aes.CipherKeyLen(e.keyLen, e.mode)))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := e.kek.WrapKey(dek)
+	if err != nil {
+		return nil, err
+	}
+	return encryptCall(e.keyLen, dek, plaintext, aes.WithCipherKey(),
+		aes.WithSecretID(e.kek.ID()), aes.WithWrappedKey(wrapped))
+}
+
+// Decrypt returns the cipher data decrypted with its unwrapped data encryption key.
+func (e *EnvelopeEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	decryptCall, err := aesDecryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.DecodeData(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrapKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return decryptCall(e.keyLen, dek, ciphertext, aes.WithCipherKey())
+}
+
+// Rewrap returns the cipher data with its data encryption key re-wrapped by the
+// kek. The encrypted data is not changed. The result can be decrypted by an
+// EnvelopeEncryptor using kek. This is used to rotate the key encryption key.
+func (e *EnvelopeEncryptor) Rewrap(ciphertext []byte, kek crypto.KeyWrapper) ([]byte, error) {
+	data, err := crypto.DecodeData(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrapKey(data)
+	if err != nil {
+		return nil, err
+	}
+	// the MAC covers the header and the wrapped key, so it is checked
+	// before they are replaced, and updated with the new ones after.
+	authenticated := len(data.MAC) > 0
+	if authenticated {
+		mac, macErr := aes.DataMAC(dek, data)
+		if macErr != nil {
+			return nil, macErr
+		}
+		if !hmac.Equal(mac, data.MAC) {
+			return nil, crypto.ErrMACMismatch
+		}
+	}
+	if data.WrappedKey, err = kek.WrapKey(dek); err != nil {
+		return nil, err
+	}
+	data.SecretID = kek.ID()
+	if authenticated {
+		if data.MAC, err = aes.DataMAC(dek, data); err != nil {
+			return nil, err
+		}
+	}
+	return crypto.EncodeData(data)
+}
+
+func (e *EnvelopeEncryptor) unwrapKey(data crypto.Data) ([]byte, error) {
+	if len(data.WrappedKey) <= 0 {
+		return nil, errors.New("wrapped key not found")
+	}
+	kek, ok := e.keks[data.SecretID]
+	if !ok {
+		return nil, fmt.Errorf("key encryption key not found: %s", data.SecretID)
+	}
+	return kek.UnwrapKey(data.WrappedKey)
+}
+
+// SecretKeyWrapper is a KeyWrapper that wraps data encryption keys
+// with AES256-GCM using a key encryption key derived from a secret.
+type SecretKeyWrapper struct {
+	secret crypto.Secret
+	keys   *crypto.KeySchedule
+}
+
+var _ crypto.KeyWrapper = (*SecretKeyWrapper)(nil)
+
+// NewSecretKeyWrapper returns a new SecretKeyWrapper for the secret.
+func NewSecretKeyWrapper(secret crypto.Secret) *SecretKeyWrapper {
+	return &SecretKeyWrapper{secret, crypto.NewKeySchedule(nil)}
+}
+
+// ID returns the id of the secret.
+func (w *SecretKeyWrapper) ID() string {
+	return w.secret.ID()
+}
+
+// WrapKey returns the key encrypted with the secret.
+func (w *SecretKeyWrapper) WrapKey(key []byte) ([]byte, error) {
+	return aes.EncryptGCM(crypto.Key256, w.secret.Open(), key,
+		aes.WithSecretID(w.secret.ID()), aes.WithKeySchedule(w.keys))
+}
+
+// UnwrapKey returns the key decrypted with the secret.
+func (w *SecretKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return aes.DecryptGCM(crypto.Key256, w.secret.Open(), wrapped, aes.WithKeySchedule(w.keys))
+}
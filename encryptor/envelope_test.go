@@ -0,0 +1,110 @@
+package encryptor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// kmsKeyWrapper is a stand-in for an external KMS that holds its keys by id.
+type kmsKeyWrapper struct {
+	id   string
+	keys map[string][]byte
+}
+
+var _ crypto.KeyWrapper = (*kmsKeyWrapper)(nil)
+
+func (k *kmsKeyWrapper) ID() string {
+	return k.id
+}
+
+func (k *kmsKeyWrapper) WrapKey(key []byte) ([]byte, error) {
+	handle, err := crypto.MakeRand(16)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[string(handle)] = key
+	return handle, nil
+}
+
+func (k *kmsKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[string(wrapped)]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+func TestEnvelopeEncryptor(t *testing.T) {
+	keks := []crypto.KeyWrapper{
+		NewSecretKeyWrapper(managedSecret),
+		&kmsKeyWrapper{"kms", map[string][]byte{}},
+	}
+	modes := []crypto.Mode{aes.CFB, aes.CTR, aes.GCM, aes.SIV}
+	keyLens := []crypto.KeyLen{crypto.Key128, crypto.Key192, crypto.Key256}
+	for _, kek := range keks {
+		for _, mode := range modes {
+			for _, keyLen := range keyLens {
+				ee := NewEnvelopeEncryptor(keyLen, mode, kek)
+				assert.Equal(t, kek.ID(), ee.ID())
+				assert.Equal(t, crypto.CipherName("aes", keyLen, mode), ee.Name())
+				e, err := ee.Encrypt([]byte(testPlainText))
+				assert.NoError(t, err)
+				data, err := crypto.DecodeData(e)
+				assert.NoError(t, err)
+				assert.Equal(t, kek.ID(), data.SecretID)
+				assert.NotEmpty(t, data.WrappedKey)
+				d, err := ee.Decrypt(e)
+				assert.NoError(t, err)
+				assert.Equal(t, testPlainText, string(d))
+			}
+		}
+	}
+}
+
+func TestEnvelopeEncryptor_Rewrap(t *testing.T) {
+	oldKEK := NewSecretKeyWrapper(managedSecret)
+	newKEK := NewSecretKeyWrapper(secureSecret)
+	ee := NewEnvelopeEncryptor(crypto.Key256, aes.CTR, oldKEK)
+	e, err := ee.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	rewrapped, err := ee.Rewrap(e, newKEK)
+	assert.NoError(t, err)
+	oldData, err := crypto.DecodeData(e)
+	assert.NoError(t, err)
+	newData, err := crypto.DecodeData(rewrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, oldData.Bytes, newData.Bytes)
+	assert.Equal(t, newKEK.ID(), newData.SecretID)
+	// the old kek cannot decrypt the rewrapped data
+	_, err = ee.Decrypt(rewrapped)
+	assert.Error(t, err)
+	// the new kek can decrypt both during the rotation
+	rotated := NewEnvelopeEncryptor(crypto.Key256, aes.CTR, newKEK, oldKEK)
+	for _, data := range [][]byte{e, rewrapped} {
+		d, err := rotated.Decrypt(data)
+		assert.NoError(t, err)
+		assert.Equal(t, testPlainText, string(d))
+	}
+	_, err = ee.Rewrap([]byte("bad"), newKEK)
+	assert.Error(t, err)
+}
+
+func TestEnvelopeEncryptor_Errors(t *testing.T) {
+	kek := NewSecretKeyWrapper(managedSecret)
+	ee := NewEnvelopeEncryptor(crypto.Key256, "invalid", kek)
+	_, err := ee.Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	_, err = ee.Decrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	// data that was not envelope encrypted
+	ae := NewAESEncryptor(crypto.Key256, aes.GCM, managedSecret)
+	e, err := ae.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	ee = NewEnvelopeEncryptor(crypto.Key256, aes.GCM, kek)
+	_, err = ee.Decrypt(e)
+	assert.Error(t, err)
+}
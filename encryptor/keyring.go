@@ -0,0 +1,103 @@
+package encryptor
+
+import (
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// KeyringEncryptor is an AES encryptor that holds a keyring of secrets. Data is
+// always encrypted with the primary secret, and the id of that secret is recorded
+// in the header of the encrypted data. Data is decrypted with the secret named in
+// its header, so data encrypted with any secret in the keyring can be read. This
+// allows data encrypted with old and new secrets to coexist during a rotation.
+type KeyringEncryptor struct {
+	primary crypto.Secret
+	secrets map[string]crypto.Secret
+	keys    map[string]*crypto.KeySchedule
+	keyLen  crypto.KeyLen
+	mode    crypto.Mode
+	opts    []AESOption
+}
+
+var _ crypto.Encryptor = (*KeyringEncryptor)(nil)
+
+// NewKeyringEncryptor returns a new KeyringEncryptor configured with an AES keyLen
+// length and mode that encrypts with the primary secret, and decrypts with any
+// of the primary or additional secrets. Secrets are identified by their id, if
+// more than one secret has the same id, the first one (or primary) is used.
+func NewKeyringEncryptor(keyLen crypto.KeyLen, mode crypto.Mode,
+	primary crypto.Secret, secrets ...crypto.Secret) *KeyringEncryptor {
+	ke := new(KeyringEncryptor)
+	ke.primary = primary
+	ke.keyLen = keyLen
+	ke.mode = mode
+	ke.secrets = map[string]crypto.Secret{}
+	ke.keys = map[string]*crypto.KeySchedule{}
+	for _, s := range append([]crypto.Secret{primary}, secrets...) {
+		if _, ok := ke.secrets[s.ID()]; !ok {
+			ke.secrets[s.ID()] = s
+			ke.keys[s.ID()] = crypto.NewKeySchedule(nil)
+		}
+	}
+	return ke
+}
+
+// WithOptions applies the AESOptions, e.g. WithLegacyUnauthenticated, to the encryptor
+// for every secret in the keyring and returns the KeyringEncryptor. If an option sets
+// the KDF, the key schedules are reset so the keys are derived with it.
+func (e *KeyringEncryptor) WithOptions(opt ...AESOption) *KeyringEncryptor {
+	e.opts = append(e.opts, opt...)
+	ae := new(AESEncryptor)
+	for _, o := range e.opts {
+		o.apply(ae)
+	}
+	for id := range e.keys {
+		e.keys[id] = crypto.NewKeySchedule(ae.kdf)
+	}
+	return e
+}
+
+// ID returns the id of the primary secret that is used to encrypt the data.
+func (e *KeyringEncryptor) ID() string {
+	return e.primary.ID()
+}
+
+// Name returns the name of the configured AES encryption cipher
+// in following format "[cipher][keyLen length]-[mode]" e.g. "aes192-ctr".
+func (e *KeyringEncryptor) Name() string {
+	return crypto.CipherName("aes", e.keyLen, e.mode)
+}
+
+// Encrypt returns the plain data encrypted with the configured cipher mode and primary secret.
+func (e *KeyringEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	return e.encryptor(e.keyLen, e.mode, e.primary).Encrypt(plaintext)
+}
+
+// Decrypt returns the cipher data decrypted with the secret named in its header using the
+// cipher mode and key length it was encrypted with. If the header does not name a secret,
+// the data is decrypted with the primary secret and the configured cipher mode.
+func (e *KeyringEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	header, err := crypto.DecodeHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if header.SecretID == "" {
+		return e.encryptor(e.keyLen, e.mode, e.primary).Decrypt(ciphertext)
+	}
+	secret, ok := e.secrets[header.SecretID]
+	if !ok {
+		return nil, fmt.Errorf("secret not found: %s", header.SecretID)
+	}
+	return e.encryptor(header.KeyLen, header.Mode, secret).Decrypt(ciphertext)
+}
+
+// encryptor returns an AESEncryptor for the secret that shares the key schedule of the secret.
+func (e *KeyringEncryptor) encryptor(keyLen crypto.KeyLen, mode crypto.Mode, secret crypto.Secret) *AESEncryptor {
+	ae := &AESEncryptor{secret: secret, keyLen: keyLen, mode: mode}
+	for _, o := range e.opts {
+		o.apply(ae)
+	}
+	ae.keys = e.keys[secret.ID()]
+	return ae
+}
@@ -0,0 +1,95 @@
+package encryptor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyringEncryptor(t *testing.T) {
+	oldSecret := crypto.NewManagedSecret(uuid.New().String(), "i-am-an-old-secret")
+	newSecret := crypto.NewManagedSecret(uuid.New().String(), "i-am-a-new-secret")
+	// encrypt with the old secret
+	old := NewKeyringEncryptor(crypto.Key128, aes.CFB, oldSecret)
+	assert.Equal(t, oldSecret.ID(), old.ID())
+	assert.Equal(t, crypto.CipherName("aes", crypto.Key128, aes.CFB), old.Name())
+	oldData, err := old.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	header, err := crypto.DecodeHeader(oldData)
+	assert.NoError(t, err)
+	assert.Equal(t, oldSecret.ID(), header.SecretID)
+	// rotate to the new secret with a different mode
+	ke := NewKeyringEncryptor(crypto.Key256, aes.GCM, newSecret, oldSecret, newSecret)
+	assert.Equal(t, newSecret.ID(), ke.ID())
+	newData, err := ke.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	header, err = crypto.DecodeHeader(newData)
+	assert.NoError(t, err)
+	assert.Equal(t, newSecret.ID(), header.SecretID)
+	// both can be decrypted
+	for _, data := range [][]byte{oldData, newData} {
+		d, err := ke.Decrypt(data)
+		assert.NoError(t, err)
+		assert.Equal(t, testPlainText, string(d))
+	}
+	// the old keyring does not have the new secret
+	_, err = old.Decrypt(newData)
+	assert.Error(t, err)
+	// bad data
+	_, err = ke.Decrypt([]byte("bad"))
+	assert.Error(t, err)
+}
+
+func TestKeyringEncryptor_NoSecretID(t *testing.T) {
+	// data encrypted without a secret id is decrypted with the primary secret
+	data, err := aes.EncryptCTR(crypto.Key192, textSecret.Open(), []byte(testPlainText))
+	assert.NoError(t, err)
+	ke := NewKeyringEncryptor(crypto.Key192, aes.CTR, textSecret, managedSecret)
+	d, err := ke.Decrypt(data)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+}
+
+func TestKeyringEncryptor_Options(t *testing.T) {
+	ke := NewKeyringEncryptor(crypto.Key256, aes.CTR, textSecret, managedSecret)
+	e, err := ke.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	data, err := crypto.DecodeData(e)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data.MAC)
+	// data encrypted without a mac
+	data.MAC = nil
+	legacy, err := crypto.EncodeData(data)
+	assert.NoError(t, err)
+	_, err = ke.Decrypt(legacy)
+	assert.Error(t, err)
+	ke = ke.WithOptions(WithLegacyUnauthenticated())
+	d, err := ke.Decrypt(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// the options apply to every secret in the keyring
+	old, err := NewAESEncryptor(crypto.Key256, aes.CTR, managedSecret).Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	data, err = crypto.DecodeData(old)
+	assert.NoError(t, err)
+	data.MAC = nil
+	legacy, err = crypto.EncodeData(data)
+	assert.NoError(t, err)
+	d, err = ke.Decrypt(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+	// the keys are derived with the kdf of the options
+	kdf := crypto.PBKDF2KDF{Iterations: 1000}
+	ke = NewKeyringEncryptor(crypto.Key256, aes.GCM, textSecret).WithOptions(WithKDF(kdf))
+	e, err = ke.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	header, err := crypto.DecodeHeader(e)
+	assert.NoError(t, err)
+	assert.Equal(t, kdf.ID(), header.KDF)
+	d, err = ke.Decrypt(e)
+	assert.NoError(t, err)
+	assert.Equal(t, testPlainText, string(d))
+}
@@ -0,0 +1,148 @@
+package encryptor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// RecipientsEncryptor is an AES encryptor that encrypts data for multiple recipients.
+// Each call to Encrypt generates a new random data encryption key (DEK) which is used
+// to encrypt the data once. The DEK is then wrapped separately by each recipient, and
+// the wrapped DEKs are stored alongside the encrypted data with the ids of their
+// recipients. The data can be decrypted by any one of the recipients, unlike a
+// ChainEncryptor, which requires all of them.
+//
+// A recipient is any crypto.Encryptor, e.g. an AESEncryptor for a service secret, or
+// an AgeEncryptor for the public key of an offline break-glass key.
+type RecipientsEncryptor struct {
+	id         string
+	keyLen     crypto.KeyLen
+	mode       crypto.Mode
+	recipients []crypto.Encryptor
+}
+
+var _ crypto.Encryptor = (*RecipientsEncryptor)(nil)
+
+const recipientSep = " "
+
+// NewRecipientsEncryptor returns a new RecipientsEncryptor configured with an
+// AES keyLen length and mode that encrypts the data for the recipients.
+func NewRecipientsEncryptor(keyLen crypto.KeyLen, mode crypto.Mode,
+	recipients ...crypto.Encryptor) *RecipientsEncryptor {
+	re := new(RecipientsEncryptor)
+	re.keyLen = keyLen
+	re.mode = mode
+	re.recipients = recipients
+	ids := make([]string, len(recipients))
+	for i, r := range recipients {
+		ids[i] = r.ID()
+	}
+	re.id = strings.Join(ids, recipientSep)
+	return re
+}
+
+// ID returns a concatenated list of the ids of the recipients separated by spaces.
+func (e *RecipientsEncryptor) ID() string {
+	return e.id
+}
+
+// Name returns the name of the configured AES encryption cipher
+// in following format "[cipher][keyLen length]-[mode]" e.g. "aes192-ctr".
+func (e *RecipientsEncryptor) Name() string {
+	return crypto.CipherName("aes", e.keyLen, e.mode)
+}
+
+// Encrypt returns the plain data encrypted with a new data encryption key
+// along with the data encryption key wrapped by each of the recipients.
+func (e *RecipientsEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	if len(e.recipients) <= 0 {
+		return nil, errors.New("recipient required")
+	}
+	encryptCall, err := aesEncryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := crypto.MakeRand(uint(aes.CipherKeyLen(e.keyLen, e.mode)))
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]crypto.Recipient, len(e.recipients))
+	for i, r := range e.recipients {
+		wrapped, wrapErr := r.Encrypt(dek)
+		if wrapErr != nil {
+			return nil, fmt.Errorf("recipient %s: %w", r.ID(), wrapErr)
+		}
+		recipients[i] = crypto.Recipient{ID: r.ID(), WrappedKey: wrapped}
+	}
+	return encryptCall(e.keyLen, dek, plaintext, aes.WithCipherKey(), aes.WithRecipients(recipients))
+}
+
+// Decrypt returns the cipher data decrypted with its data encryption key, which is unwrapped by the
+// first recipient that can. The recipients with an id listed in the data are tried first, followed
+// by the others, since the id of a recipient that encrypts may not match its id when it decrypts.
+func (e *RecipientsEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	decryptCall, err := aesDecryptCall(e.mode)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.DecodeData(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.unwrapKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return decryptCall(e.keyLen, dek, ciphertext, aes.WithCipherKey())
+}
+
+func (e *RecipientsEncryptor) unwrapKey(data crypto.Data) ([]byte, error) {
+	if len(data.Recipients) <= 0 {
+		return nil, errors.New("recipients not found")
+	}
+	unwrap := func(r crypto.Encryptor, wrapped crypto.Recipient) []byte {
+		dek, err := r.Decrypt(wrapped.WrappedKey)
+		if err != nil || len(dek) != int(aes.CipherKeyLen(data.KeyLen, data.Mode)) {
+			return nil
+		}
+		return dek
+	}
+	for _, r := range e.recipients {
+		for _, wrapped := range data.Recipients {
+			if wrapped.ID != r.ID() {
+				continue
+			}
+			if dek := unwrap(r, wrapped); dek != nil {
+				return dek, nil
+			}
+		}
+	}
+	for _, r := range e.recipients {
+		for _, wrapped := range data.Recipients {
+			if wrapped.ID == r.ID() {
+				continue
+			}
+			if dek := unwrap(r, wrapped); dek != nil {
+				return dek, nil
+			}
+		}
+	}
+	return nil, errors.New("no recipient could unwrap the data encryption key")
+}
+
+// RecipientIDs returns the ids of the recipients of the cipher data.
+func RecipientIDs(ciphertext []byte) ([]string, error) {
+	data, err := crypto.DecodeData(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(data.Recipients))
+	for i, r := range data.Recipients {
+		ids[i] = r.ID
+	}
+	return ids, nil
+}
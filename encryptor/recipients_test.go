@@ -0,0 +1,71 @@
+package encryptor
+
+import (
+	"testing"
+
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientsEncryptor(t *testing.T) {
+	breakGlass, breakGlassSecret := newAgeIdentity(t)
+	service := NewAESEncryptor(crypto.Key256, aes.GCM, managedSecret)
+	// the writer only needs the public key of the break-glass identity
+	writer := NewAgeEncryptor(nil, breakGlass.Recipient().String())
+	modes := []crypto.Mode{aes.CFB, aes.CTR, aes.GCM, aes.SIV}
+	for _, mode := range modes {
+		t.Run(mode.String(), func(t *testing.T) {
+			re := NewRecipientsEncryptor(crypto.Key256, mode, service, writer)
+			assert.Equal(t, service.ID()+" "+writer.ID(), re.ID())
+			assert.Equal(t, crypto.CipherName("aes", crypto.Key256, mode), re.Name())
+			e, err := re.Encrypt([]byte(testPlainText))
+			assert.NoError(t, err)
+			ids, err := RecipientIDs(e)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{service.ID(), writer.ID()}, ids)
+			// the data can be decrypted by any one of the recipients
+			d, err := re.Decrypt(e)
+			assert.NoError(t, err)
+			assert.Equal(t, testPlainText, string(d))
+			d, err = NewRecipientsEncryptor(crypto.Key256, mode, service).Decrypt(e)
+			assert.NoError(t, err)
+			assert.Equal(t, testPlainText, string(d))
+			d, err = NewRecipientsEncryptor(crypto.Key256, mode, NewAgeEncryptor(breakGlassSecret)).Decrypt(e)
+			assert.NoError(t, err)
+			assert.Equal(t, testPlainText, string(d))
+			// but not by anyone else
+			other := NewAESEncryptor(crypto.Key256, aes.GCM, secureSecret)
+			_, err = NewRecipientsEncryptor(crypto.Key256, mode, other).Decrypt(e)
+			assert.Error(t, err)
+			_, err = NewRecipientsEncryptor(crypto.Key256, mode, writer).Decrypt(e)
+			assert.Error(t, err)
+		})
+	}
+	// the data key is wrapped once for each recipient
+	re := NewRecipientsEncryptor(crypto.Key256, aes.GCM, service, writer)
+	e, err := re.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	data, err := crypto.DecodeData(e)
+	assert.NoError(t, err)
+	assert.Len(t, data.Recipients, 2)
+	assert.Empty(t, data.WrappedKey)
+	// recipients are required
+	_, err = NewRecipientsEncryptor(crypto.Key256, aes.GCM).Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	_, err = NewRecipientsEncryptor(crypto.Key256, aes.GCM, NewAgeEncryptor(nil)).Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	// data without recipients
+	e, err = service.Encrypt([]byte(testPlainText))
+	assert.NoError(t, err)
+	_, err = re.Decrypt(e)
+	assert.Error(t, err)
+	_, err = RecipientIDs([]byte("bad"))
+	assert.Error(t, err)
+	// load a bad cipher
+	re = NewRecipientsEncryptor(crypto.Key256, "Invalid_Cipher_Mode", service)
+	_, err = re.Encrypt([]byte(testPlainText))
+	assert.Error(t, err)
+	_, err = re.Decrypt([]byte(testPlainText))
+	assert.Error(t, err)
+}
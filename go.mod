@@ -5,6 +5,7 @@ go 1.23
 toolchain go1.23.2
 
 require (
+	filippo.io/age v1.0.0
 	github.com/btcsuite/btcd/btcec/v2 v2.3.4
 	github.com/google/uuid v1.6.0
 	github.com/hashicorp/go-version v1.7.0
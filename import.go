@@ -0,0 +1,287 @@
+package chestnut
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+// ImportMode sets how Import handles the keys in a backup that
+// are already in the storage chest.
+type ImportMode int
+
+const (
+	// ImportMerge replaces the existing keys with the keys in the backup.
+	// Keys that are not in the backup are kept.
+	ImportMerge ImportMode = iota
+	// ImportReplace deletes all the keys in the storage chest before
+	// the backup is imported.
+	ImportReplace
+	// ImportSkipExisting only imports the keys in the backup that are
+	// not in the storage chest.
+	ImportSkipExisting
+)
+
+// String returns the name of the import mode.
+func (m ImportMode) String() string {
+	switch m {
+	case ImportMerge:
+		return "merge"
+	case ImportReplace:
+		return "replace"
+	case ImportSkipExisting:
+		return "skip-existing"
+	default:
+		return "unknown"
+	}
+}
+
+// ConflictError is returned by Import when overwrites are forbidden and keys in
+// the backup are already in the storage chest. ConflictError wraps ErrForbidden.
+type ConflictError struct {
+	// Conflicts is a mapped list of the keys in the backup that already exist.
+	Conflicts map[string][][]byte
+}
+
+// Error returns the number of conflicting keys.
+func (e *ConflictError) Error() string {
+	var n int
+	for _, keys := range e.Conflicts {
+		n += len(keys)
+	}
+	return fmt.Sprintf("%s: %d keys already exist", ErrForbidden, n)
+}
+
+// Unwrap returns ErrForbidden.
+func (e *ConflictError) Unwrap() error {
+	return ErrForbidden
+}
+
+// Import restores the backup at path saved by Export. The mode sets how the keys that
+// are already in the storage chest are handled. Before anything is written, every
+// value in the backup is decrypted with the encryptor. If a value does not decrypt,
+// nothing is imported and an error is returned. If overwrites are forbidden and a key
+// in the backup already exists (unless the mode is ImportSkipExisting), nothing is
+// imported and a *ConflictError listing the keys is returned. Expired records are
+// not imported. The blind index entries of the imported records are rebuilt from the
+// backup. If the store supports transactions, the backup is imported inside of a
+// single transaction. Writes to the storage chest block until Import returns.
+func (cn *Chestnut) Import(path string, mode ImportMode) error {
+	cn.log.Infof("import: from path: %s mode: %s", path, mode)
+	if err := cn.writable("import"); err != nil {
+		return err
+	}
+	// the conflicts and existing keys are checked before the import, so
+	// other writes wait until the import returns like they do for Rekey.
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	br, ok := cn.store.(storage.BackupReader)
+	if !ok {
+		err := errors.New("store cannot read backups")
+		return cn.logError("import", err)
+	}
+	read := func(fn storage.BackupFunc) error {
+		return br.ReadBackup(path, fn)
+	}
+	n, err := cn.importBackup(read, mode)
+	if err != nil {
+		return cn.logError("import", err)
+	}
+	cn.log.Infof("import: imported %d keys from path: %s", n, path)
+	return nil
+}
+
+// readBackupFunc calls fn for each namespace, key, and stored value in a backup.
+type readBackupFunc func(fn storage.BackupFunc) error
+
+// importBackup validates and then imports the backup read by read
+// and returns the number of keys imported.
+func (cn *Chestnut) importBackup(read readBackupFunc, mode ImportMode) (int, error) {
+	if mode < ImportMerge || mode > ImportSkipExisting {
+		return 0, fmt.Errorf("invalid import mode: %d", mode)
+	}
+	if err := cn.validateBackup(read, mode); err != nil {
+		return 0, err
+	}
+	var existing map[string][][]byte
+	if mode == ImportReplace {
+		var err error
+		if existing, err = cn.store.ListAll(); err != nil {
+			return 0, err
+		}
+		delete(existing, rekeyNamespace)
+	}
+	var events []watchEvent
+	var n int
+	importBackup := func(tx storage.Tx) error {
+		events, n = nil, 0
+		// the blind index is rebuilt from the indexed records of the backup
+		indexMACs := map[string][]byte{}
+		type record struct {
+			name string
+			key  []byte
+		}
+		var imported []record
+		for name, keys := range existing {
+			for _, key := range keys {
+				if err := tx.Delete(name, key); err != nil {
+					return err
+				}
+				if !isReserved(name) {
+					events = append(events, deleteEvent(name, key))
+				}
+			}
+		}
+		err := read(func(name string, key []byte, value []byte) error {
+			data, expiry := decodeExpiry(value)
+			if name == rekeyNamespace || expired(expiry) {
+				return nil
+			}
+			if name == indexNamespace {
+				if len(key) > 0 && key[0] == indexRecordTag {
+					indexMACs[string(key)] = append([]byte{}, data...)
+				}
+				return nil
+			}
+			if mode == ImportSkipExisting {
+				if has, _ := tx.Has(name, key); has {
+					return nil
+				}
+			} else if mode == ImportMerge && !isReserved(name) {
+				cn.deleteReplacedStream(tx, name, key, data)
+			}
+			// keys and values are only valid for the life of the call
+			key = append([]byte{}, key...)
+			data = append([]byte{}, data...)
+			if err := cn.putExpiry(tx, name, key, data, expiry); err != nil {
+				return err
+			}
+			n++
+			if !isReserved(name) {
+				imported = append(imported, record{name, key})
+				events = append(events, importEvent(name, key, data))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range imported {
+			macs := splitIndexMACs(indexMACs[string(indexRecordKey(r.name, r.key))])
+			if err = cn.reindex(tx, r.name, r.key, macs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if store, ok := cn.store.(storage.Transactional); ok {
+		err = store.Update(importBackup)
+	} else {
+		err = importBackup(cn.store)
+	}
+	if err != nil {
+		return 0, err
+	}
+	cn.publish(events...)
+	return n, nil
+}
+
+// validateBackup checks that every value in the backup decrypts with the encryptor
+// and, if overwrites are forbidden, that the import will not overwrite any keys.
+func (cn *Chestnut) validateBackup(read readBackupFunc, mode ImportMode) error {
+	conflicts := map[string][][]byte{}
+	var n int
+	err := read(func(name string, key []byte, value []byte) error {
+		data, expiry := decodeExpiry(value)
+		if name == rekeyNamespace || expired(expiry) {
+			return nil
+		}
+		n++
+		if name == indexNamespace {
+			if err := validIndex(key, data); err != nil {
+				return fmt.Errorf("invalid backup key: %s.%x: %w", name, key, err)
+			}
+			return nil
+		}
+		// stream chunks are sealed with the stream key in the manifest
+		if name != streamNamespace {
+			if err := cn.verify(data); err != nil {
+				return fmt.Errorf("invalid backup key: %s.%s: %w", name, key, err)
+			}
+		}
+		if cn.opts.overwrites || mode == ImportSkipExisting || isReserved(name) {
+			return nil
+		}
+		if has, _ := cn.has(cn.store, name, key); has {
+			conflicts[name] = append(conflicts[name], append([]byte{}, key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	cn.log.Debugf("import: validated %d keys", n)
+	return nil
+}
+
+// verify returns nil if the stored value decrypts with the encryptor. The
+// value can be either ciphertext stored by Put, a package stored by Save,
+// or a stream manifest stored by PutStream.
+func (cn *Chestnut) verify(value []byte) error {
+	if isStream(value) {
+		_, err := cn.decodeStreamManifest(value)
+		return err
+	}
+	if pkg, err := packager.DecodePackage(value); err == nil {
+		_, err = cn.decryptSealed(pkg.Cipher)
+		return err
+	}
+	plaintext, err := cn.decryptSealed(value)
+	if err != nil {
+		return err
+	}
+	plaintext, _ = openExpiry(plaintext)
+	_, err = cn.decompress(plaintext)
+	return err
+}
+
+// deleteReplacedStream deletes the chunks of the stream at key in tx if there is one,
+// and it is not the same stream as the value replacing it.
+func (cn *Chestnut) deleteReplacedStream(tx storage.Tx, name string, key []byte, value []byte) {
+	current, err := tx.Get(name, key)
+	if err != nil {
+		return
+	}
+	if current, _ = decodeExpiry(current); !isStream(current) {
+		return
+	}
+	m, err := cn.decodeStreamManifest(current)
+	if err != nil {
+		return
+	}
+	if isStream(value) {
+		if next, err := cn.decodeStreamManifest(value); err == nil && next.ID == m.ID {
+			return
+		}
+	}
+	for i := uint32(0); i < m.Chunks; i++ {
+		_ = tx.Delete(streamNamespace, m.chunkKey(i))
+	}
+}
+
+// importEvent returns the put event for an imported value.
+func importEvent(name string, key []byte, value []byte) watchEvent {
+	if isStream(value) {
+		return putEvent(name, key, nil)
+	}
+	if _, err := packager.DecodePackage(value); err == nil {
+		return putEvent(name, key, nil)
+	}
+	return putEvent(name, key, value)
+}
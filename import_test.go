@@ -0,0 +1,193 @@
+package chestnut
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+const importName = "import-namespace"
+
+// exportImport puts the records to back up, exports them, and then changes them.
+func (ts *ChestnutTestSuite) exportImport(cn *Chestnut) string {
+	err := cn.Put(importName, []byte("a"), []byte(testValue))
+	ts.Require().NoError(err)
+	err = cn.Save(importName, []byte("b"), &objectSrc)
+	ts.Require().NoError(err)
+	err = cn.PutStream(importName, []byte("stream"), strings.NewReader(lorumIpsum))
+	ts.Require().NoError(err)
+	err = cn.Put(importName, []byte("expired"), []byte(testValue), WithTTL(1))
+	ts.Require().NoError(err)
+	path := ts.T().TempDir()
+	err = cn.Export(path)
+	ts.Require().NoError(err)
+	err = cn.Delete(importName, []byte("a"))
+	ts.Require().NoError(err)
+	err = cn.Delete(importName, []byte("stream"))
+	ts.Require().NoError(err)
+	err = cn.Save(importName, []byte("b"), &TObject{ValueA: "changed"})
+	ts.Require().NoError(err)
+	err = cn.Put(importName, []byte("new"), []byte(testValue))
+	ts.Require().NoError(err)
+	return path
+}
+
+func (ts *ChestnutTestSuite) assertImported(cn *Chestnut, changed bool) {
+	value, err := cn.Get(importName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	obj := &TObject{}
+	err = cn.Load(importName, []byte("b"), obj)
+	ts.NoError(err)
+	if changed {
+		ts.Equal("changed", obj.ValueA)
+	} else {
+		ts.Equal(&objOut, obj)
+	}
+	buf := &bytes.Buffer{}
+	err = cn.GetStream(importName, []byte("stream"), buf)
+	ts.NoError(err)
+	ts.Equal(lorumIpsum, buf.String())
+	has, err := cn.Has(importName, []byte("expired"))
+	ts.NoError(err)
+	ts.False(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Import() {
+	path := ts.exportImport(ts.cn)
+	err := ts.cn.Import(path, ImportMerge)
+	ts.NoError(err)
+	ts.assertImported(ts.cn, false)
+	has, err := ts.cn.Has(importName, []byte("new"))
+	ts.NoError(err)
+	ts.True(has)
+	err = ts.cn.Import(path, ImportMode(-1))
+	ts.Error(err)
+	err = ts.cn.Import(ts.T().TempDir(), ImportMerge)
+	ts.Error(err)
+	err = NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt).Import(path, ImportMerge)
+	ts.Error(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportIndex() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	err := cn.Save(indexName, []byte("1"), &TIndexed{Email: "a@example.com"})
+	ts.Require().NoError(err)
+	path := ts.T().TempDir()
+	err = cn.Export(path)
+	ts.Require().NoError(err)
+	err = cn.Save(indexName, []byte("1"), &TIndexed{Email: "b@example.com"})
+	ts.Require().NoError(err)
+	err = cn.Save(indexName, []byte("2"), &TIndexed{Email: "a@example.com"})
+	ts.Require().NoError(err)
+	err = cn.Import(path, ImportMerge)
+	ts.NoError(err)
+	// both sides are indexed by the shared value
+	ts.ElementsMatch([]string{"1", "2"}, ts.findBy(cn, "email", "a@example.com"))
+	ts.Empty(ts.findBy(cn, "email", "b@example.com"))
+	// a record replaced by a value is no longer indexed
+	err = cn.Put(indexName, []byte("3"), []byte(testValue))
+	ts.Require().NoError(err)
+	err = cn.Export(path)
+	ts.Require().NoError(err)
+	err = cn.Save(indexName, []byte("3"), &TIndexed{Email: "a@example.com"})
+	ts.Require().NoError(err)
+	err = cn.Import(path, ImportMerge)
+	ts.NoError(err)
+	ts.ElementsMatch([]string{"1", "2"}, ts.findBy(cn, "email", "a@example.com"))
+	err = cn.Import(path, ImportReplace)
+	ts.NoError(err)
+	ts.ElementsMatch([]string{"1", "2"}, ts.findBy(cn, "email", "a@example.com"))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportReplace() {
+	path := ts.exportImport(ts.cn)
+	err := ts.cn.Import(path, ImportReplace)
+	ts.NoError(err)
+	ts.assertImported(ts.cn, false)
+	has, _ := ts.cn.Has(importName, []byte("new"))
+	ts.False(has)
+	// the chunks of the deleted stream were replaced by the backup
+	m, err := ts.cn.streamManifest(ts.cn.store, importName, []byte("stream"))
+	ts.Require().NoError(err)
+	chunks, err := ts.cn.store.List(streamNamespace)
+	ts.NoError(err)
+	ts.Len(chunks, int(m.Chunks))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportSkipExisting() {
+	path := ts.exportImport(ts.cn)
+	err := ts.cn.Import(path, ImportSkipExisting)
+	ts.NoError(err)
+	ts.assertImported(ts.cn, true)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportConflicts() {
+	path := ts.exportImport(ts.cn)
+	cn := NewChestnut(ts.cn.store, encryptorOpt, OverwritesForbidden())
+	err := cn.Import(path, ImportMerge)
+	ts.ErrorIs(err, ErrForbidden)
+	var conflicts *ConflictError
+	ts.Require().ErrorAs(err, &conflicts)
+	ts.ElementsMatch([]string{"b"}, toStrings(conflicts.Conflicts[importName]))
+	// nothing was imported
+	has, _ := cn.Has(importName, []byte("a"))
+	ts.False(has)
+	err = cn.Import(path, ImportSkipExisting)
+	ts.NoError(err)
+	ts.assertImported(cn, true)
+}
+
+// readHookStore calls onRead before it reads a backup.
+type readHookStore struct {
+	storage.Storage
+	onRead func()
+}
+
+func (s *readHookStore) ReadBackup(path string, fn storage.BackupFunc) error {
+	s.onRead()
+	return s.Storage.(storage.BackupReader).ReadBackup(path, fn)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportWrites() {
+	path := ts.exportImport(ts.cn)
+	done := make(chan error, 1)
+	var cn *Chestnut
+	store := &readHookStore{Storage: ts.cn.store}
+	store.onRead = func() {
+		store.onRead = func() {}
+		go func() {
+			done <- cn.Put(importName, []byte("a"), []byte("changed"))
+		}()
+		// writes are blocked until the import completes
+		select {
+		case err := <-done:
+			ts.Fail("put did not block")
+			done <- err
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	cn = NewChestnut(store, encryptorOpt, OverwritesForbidden())
+	err := cn.Import(path, ImportSkipExisting)
+	ts.Require().NoError(err)
+	// the put is checked against the imported key
+	ts.ErrorIs(<-done, ErrForbidden)
+	ts.assertImported(cn, true)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ImportInvalid() {
+	path := ts.exportImport(ts.cn)
+	secret := crypto.TextSecret("i-am-a-different-secret")
+	cn := NewChestnut(ts.cn.store, WithEncryptor(encryptor.NewAESEncryptor(crypto.Key256, aes.CFB, secret)))
+	err := cn.Import(path, ImportReplace)
+	ts.Error(err)
+	// nothing was imported
+	has, err := ts.cn.Has(importName, []byte("new"))
+	ts.NoError(err)
+	ts.True(has)
+}
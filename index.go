@@ -0,0 +1,297 @@
+package chestnut
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/jrapoport/chestnut/encoding/tags"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+	"golang.org/x/crypto/hkdf"
+)
+
+// indexNamespace is the reserved namespace of the blind indexes.
+const indexNamespace = "__chestnut_index"
+
+const (
+	// indexEntryTag is the prefix of the keys of the index entries. An index entry maps
+	// the HMAC of a namespace, field, and value to the keys of the records with that value.
+	indexEntryTag = 'e'
+	// indexRecordTag is the prefix of the keys of the indexed records. An indexed
+	// record maps the namespace and key of a record to the HMACs it is indexed by.
+	indexRecordTag = 'r'
+)
+
+var indexMACInfo = []byte("chestnut blind index mac")
+
+// newIndexKey returns the HMAC key of the blind indexes derived from the secret.
+func newIndexKey(secret crypto.Secret) ([]byte, error) {
+	key := secret.Open()
+	if len(key) <= 0 {
+		return nil, errors.New("blind index secret cannot be empty")
+	}
+	mac := make([]byte, crypto.Key256)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, indexMACInfo), mac)
+	return mac, err
+}
+
+// FindBy returns the keys of the records in the namespace whose indexed field equals
+// value. The field is the JSON name of a string field with the index tag option, e.g.
+// `json:"email,index"`. The records are found with the blind index, so nothing is
+// decrypted. Blind indexes must be enabled with WithBlindIndex.
+func (cn *Chestnut) FindBy(name string, field string, value string) ([][]byte, error) {
+	cn.log.Debugf("find by: %s.%s", name, field)
+	if cn.index == nil {
+		err := errors.New("blind index not enabled")
+		return nil, cn.logError("find by", err)
+	} else if err := storage.ValidKey(name, []byte(field)); err != nil {
+		return nil, cn.logError("find by", err)
+	}
+	mac := cn.indexMAC(name, field, value)
+	var keys [][]byte
+	find := func(tx storage.Tx) error {
+		entry, err := tx.Get(indexNamespace, indexEntryKey(mac))
+		if err != nil {
+			// nothing is indexed by the value
+			keys = [][]byte{}
+			return nil
+		}
+		keys = [][]byte{}
+		for _, key := range decodeIndexKeys(bytes.Clone(entry)) {
+			// an expired record is not found
+			if has, _ := cn.has(tx, name, key); has {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	}
+	var err error
+	if store, ok := cn.store.(storage.Transactional); ok {
+		err = store.View(find)
+	} else {
+		err = find(cn.store)
+	}
+	if err != nil {
+		return nil, cn.logError("find by", err)
+	}
+	cn.log.Debugf("find by: found %d keys", len(keys))
+	return keys, nil
+}
+
+// indexed calls fn inside of a transaction if blind indexes are enabled and the store
+// supports them, so a record and its index entries are written together.
+func (cn *Chestnut) indexed(fn func(tx storage.Tx) error) error {
+	if cn.index != nil {
+		if store, ok := cn.store.(storage.Transactional); ok {
+			return store.Update(fn)
+		}
+	}
+	return fn(cn.store)
+}
+
+// indexMAC returns the HMAC of the value of the field in the namespace.
+func (cn *Chestnut) indexMAC(name string, field string, value string) []byte {
+	h := hmac.New(sha256.New, cn.index)
+	for _, p := range []string{name, field, value} {
+		_ = binary.Write(h, binary.BigEndian, uint32(len(p)))
+		_, _ = h.Write([]byte(p))
+	}
+	return h.Sum(nil)
+}
+
+// indexStruct updates the blind index entries of the struct v saved at key in tx.
+func (cn *Chestnut) indexStruct(tx storage.Tx, name string, key []byte, v interface{}) error {
+	if cn.index == nil {
+		return nil
+	}
+	fields := indexedFields(reflect.ValueOf(v), map[string]string{})
+	macs := make([][]byte, 0, len(fields))
+	for field, value := range fields {
+		macs = append(macs, cn.indexMAC(name, field, value))
+	}
+	sort.Slice(macs, func(i, j int) bool {
+		return bytes.Compare(macs[i], macs[j]) < 0
+	})
+	return cn.reindex(tx, name, key, macs)
+}
+
+// unindex removes the blind index entries of the record at key in tx.
+func (cn *Chestnut) unindex(tx storage.Tx, name string, key []byte) error {
+	if cn.index == nil {
+		return nil
+	}
+	return cn.reindex(tx, name, key, nil)
+}
+
+// reindex replaces the blind index entries of the record at key in tx with macs.
+func (cn *Chestnut) reindex(tx storage.Tx, name string, key []byte, macs [][]byte) error {
+	recordKey := indexRecordKey(name, key)
+	var prev [][]byte
+	if value, err := tx.Get(indexNamespace, recordKey); err == nil {
+		prev = splitIndexMACs(bytes.Clone(value))
+	}
+	contains := func(list [][]byte, mac []byte) bool {
+		for _, m := range list {
+			if bytes.Equal(m, mac) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, mac := range prev {
+		if contains(macs, mac) {
+			continue
+		}
+		if err := updateIndexEntry(tx, mac, key, false); err != nil {
+			return err
+		}
+	}
+	for _, mac := range macs {
+		if contains(prev, mac) {
+			continue
+		}
+		if err := updateIndexEntry(tx, mac, key, true); err != nil {
+			return err
+		}
+	}
+	if len(macs) > 0 {
+		return tx.Put(indexNamespace, recordKey, bytes.Join(macs, nil))
+	} else if len(prev) > 0 {
+		return tx.Delete(indexNamespace, recordKey)
+	}
+	return nil
+}
+
+// updateIndexEntry adds or removes key from the index entry of mac.
+func updateIndexEntry(tx storage.Tx, mac []byte, key []byte, add bool) error {
+	entryKey := indexEntryKey(mac)
+	var keys [][]byte
+	if value, err := tx.Get(indexNamespace, entryKey); err == nil {
+		keys = decodeIndexKeys(bytes.Clone(value))
+	}
+	list := keys[:0]
+	for _, k := range keys {
+		if !bytes.Equal(k, key) {
+			list = append(list, k)
+		}
+	}
+	if add {
+		list = append(list, key)
+	}
+	if len(list) > 0 {
+		return tx.Put(indexNamespace, entryKey, encodeIndexKeys(list))
+	} else if len(keys) > 0 {
+		return tx.Delete(indexNamespace, entryKey)
+	}
+	return nil
+}
+
+// splitIndexMACs returns the HMACs of an indexed record.
+func splitIndexMACs(b []byte) [][]byte {
+	var macs [][]byte
+	for ; len(b) >= sha256.Size; b = b[sha256.Size:] {
+		macs = append(macs, b[:sha256.Size:sha256.Size])
+	}
+	return macs
+}
+
+// validIndex returns an error if the key and value are not an index entry or an
+// indexed record. The HMACs themselves cannot be checked without the index secret.
+func validIndex(key []byte, value []byte) error {
+	if len(key) <= 1 {
+		return errors.New("invalid index key")
+	}
+	switch key[0] {
+	case indexEntryTag:
+		if len(key) != 1+sha256.Size || len(encodeIndexKeys(decodeIndexKeys(value))) != len(value) {
+			return errors.New("invalid index entry")
+		}
+	case indexRecordTag:
+		if len(value) == 0 || len(value)%sha256.Size != 0 {
+			return errors.New("invalid indexed record")
+		}
+	default:
+		return errors.New("invalid index key")
+	}
+	return nil
+}
+
+// indexedFields adds the JSON name and value of each string field of v with
+// the index tag option to fields. Embedded structs are included. Fields
+// with an empty value are not indexed.
+func indexedFields(v reflect.Value, fields map[string]string) map[string]string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fields
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fields
+	}
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, opts := tags.ParseJSONTag(field.Tag.Get(tags.JSONTag))
+		if tags.IgnoreField(name) {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			indexedFields(v.Field(i), fields)
+			continue
+		}
+		if !field.IsExported() || !tags.IsIndexed(opts) || field.Type.Kind() != reflect.String {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if value := v.Field(i).String(); value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// indexEntryKey returns the key of the index entry of mac.
+func indexEntryKey(mac []byte) []byte {
+	return append([]byte{indexEntryTag}, mac...)
+}
+
+// indexRecordKey returns the key of the indexed record at name.key.
+func indexRecordKey(name string, key []byte) []byte {
+	rk := append([]byte{indexRecordTag}, binary.AppendUvarint(nil, uint64(len(name)))...)
+	rk = append(rk, name...)
+	return append(rk, key...)
+}
+
+// encodeIndexKeys returns the keys of an index entry as length prefixed bytes.
+func encodeIndexKeys(keys [][]byte) []byte {
+	var b []byte
+	for _, key := range keys {
+		b = binary.AppendUvarint(b, uint64(len(key)))
+		b = append(b, key...)
+	}
+	return b
+}
+
+// decodeIndexKeys returns the keys of an index entry.
+func decodeIndexKeys(b []byte) [][]byte {
+	var keys [][]byte
+	for len(b) > 0 {
+		n, l := binary.Uvarint(b)
+		if l <= 0 || uint64(len(b)-l) < n {
+			break
+		}
+		b = b[l:]
+		keys = append(keys, b[:n:n])
+		b = b[n:]
+	}
+	return keys
+}
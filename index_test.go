@@ -0,0 +1,135 @@
+package chestnut
+
+import (
+	"bytes"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+const indexName = "index-namespace"
+
+var indexSecret = crypto.TextSecret("i-am-a-blind-index-secret")
+
+type TIndexed struct {
+	Email  string `json:"email,index"`
+	Name   string `json:"name,index"`
+	Secret string `json:"secret,secure"`
+	TObject
+}
+
+func (ts *ChestnutTestSuite) findBy(cn *Chestnut, field, value string) []string {
+	keys, err := cn.FindBy(indexName, field, value)
+	ts.NoError(err)
+	return toStrings(keys)
+}
+
+func (ts *ChestnutTestSuite) testIndex(cn *Chestnut) {
+	users := map[string]*TIndexed{
+		"1": {Email: "a@example.com", Name: "a"},
+		"2": {Email: "b@example.com", Name: "b"},
+		"3": {Email: "a@example.com", Name: "c", Secret: testValue},
+	}
+	for key, u := range users {
+		err := cn.Save(indexName, []byte(key), u)
+		ts.Require().NoError(err)
+	}
+	ts.ElementsMatch([]string{"1", "3"}, ts.findBy(cn, "email", "a@example.com"))
+	ts.ElementsMatch([]string{"2"}, ts.findBy(cn, "email", "b@example.com"))
+	ts.ElementsMatch([]string{"3"}, ts.findBy(cn, "name", "c"))
+	ts.Empty(ts.findBy(cn, "email", "c@example.com"))
+	// fields are indexed separately
+	ts.Empty(ts.findBy(cn, "name", "a@example.com"))
+	ts.Empty(ts.findBy(cn, "secret", testValue))
+	// the index is updated when the struct is saved again
+	users["1"].Email = "c@example.com"
+	err := cn.Save(indexName, []byte("1"), users["1"])
+	ts.NoError(err)
+	ts.ElementsMatch([]string{"3"}, ts.findBy(cn, "email", "a@example.com"))
+	ts.ElementsMatch([]string{"1"}, ts.findBy(cn, "email", "c@example.com"))
+	// the index is updated when the struct is deleted
+	err = cn.Delete(indexName, []byte("3"))
+	ts.NoError(err)
+	ts.Empty(ts.findBy(cn, "email", "a@example.com"))
+	ts.Empty(ts.findBy(cn, "name", "c"))
+	// the index is updated when the struct is replaced with a value
+	err = cn.Put(indexName, []byte("2"), []byte(testValue))
+	ts.NoError(err)
+	ts.Empty(ts.findBy(cn, "email", "b@example.com"))
+	// the index does not contain the values
+	keyMap, err := cn.store.ListAll()
+	ts.NoError(err)
+	for _, key := range keyMap[indexNamespace] {
+		value, err := cn.store.Get(indexNamespace, key)
+		ts.NoError(err)
+		ts.False(bytes.Contains(value, []byte("example.com")))
+		ts.False(bytes.Contains(key, []byte("example.com")))
+	}
+	err = cn.Delete(indexName, []byte("1"))
+	ts.NoError(err)
+	keys, _ := cn.store.List(indexNamespace)
+	ts.Empty(keys)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Index() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	ts.testIndex(cn)
+	cn = NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt, WithBlindIndex(indexSecret))
+	ts.testIndex(cn)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_IndexTx() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	u := &TIndexed{Email: "a@example.com"}
+	err := cn.Update(func(tx *Tx) error {
+		return tx.Save(indexName, []byte("1"), u)
+	})
+	ts.NoError(err)
+	ts.ElementsMatch([]string{"1"}, ts.findBy(cn, "email", u.Email))
+	err = cn.Update(func(tx *Tx) error {
+		return tx.Delete(indexName, []byte("1"))
+	})
+	ts.NoError(err)
+	ts.Empty(ts.findBy(cn, "email", u.Email))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_IndexExpiry() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	u := &TIndexed{Email: "a@example.com"}
+	err := cn.Save(indexName, []byte("1"), u, WithTTL(1))
+	ts.NoError(err)
+	ts.Empty(ts.findBy(cn, "email", u.Email))
+	n, err := cn.Reap()
+	ts.NoError(err)
+	ts.Equal(1, n)
+	keys, _ := cn.store.List(indexNamespace)
+	ts.Empty(keys)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_IndexRekey() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	u := &TIndexed{Email: "a@example.com"}
+	err := cn.Save(indexName, []byte("1"), u)
+	ts.NoError(err)
+	e := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	err = cn.Rekey(e)
+	ts.NoError(err)
+	ts.ElementsMatch([]string{"1"}, ts.findBy(cn, "email", u.Email))
+	// a different secret does not find the record
+	cn = NewChestnut(ts.cn.store, WithEncryptor(e), WithBlindIndex(rekeySecret))
+	ts.Empty(ts.findBy(cn, "email", u.Email))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_IndexInvalid() {
+	_, err := ts.cn.FindBy(indexName, "email", "a@example.com")
+	ts.Error(err)
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	_, err = cn.FindBy("", "email", "a@example.com")
+	ts.Error(err)
+	_, err = cn.FindBy(indexName, "", "a@example.com")
+	ts.Error(err)
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(crypto.TextSecret("")))
+	})
+}
@@ -0,0 +1,198 @@
+package chestnut
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+// IterateFunc is the prototype for the function called with each key and decrypted
+// value by ForEach, Scan, and Range. If IterateFunc returns an error, the iteration
+// stops and the error is returned. To stop the iteration without an error, return
+// ErrStopIteration.
+type IterateFunc func(key []byte, plaintext []byte) error
+
+// ErrStopIteration is returned by an IterateFunc to stop an iteration without an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// ForEach calls fn with the key and decrypted value of each record in the namespace in
+// key order. Expired records are skipped. The records are read inside of a single read
+// transaction (if the store supports them), so fn must not write to the storage chest.
+// ForEach decrypts values stored by Put, streams stored by PutStream and structs stored
+// by Save are skipped. To iterate over structs stored by Save use ForEachValue.
+func (cn *Chestnut) ForEach(name string, fn IterateFunc) error {
+	cn.log.Debugf("for each: namespace: %s", name)
+	return cn.iterate("for each", name, cn.forEach(name), cn.decryptFunc(fn))
+}
+
+// Scan calls fn with the key and decrypted value of each record in the namespace with
+// a key that starts with prefix in key order. SEE: ForEach.
+func (cn *Chestnut) Scan(name string, prefix []byte, fn IterateFunc) error {
+	cn.log.Debugf("scan: namespace: %s prefix: %s", name, prefix)
+	return cn.iterate("scan", name, cn.scan(name, prefix), cn.decryptFunc(fn))
+}
+
+// Range calls fn with the key and decrypted value of each record in the namespace with
+// a key from start to end (inclusive) in key order. SEE: ForEach.
+func (cn *Chestnut) Range(name string, start []byte, end []byte, fn IterateFunc) error {
+	cn.log.Debugf("range: namespace: %s from: %s to: %s", name, start, end)
+	return cn.iterate("range", name, cn.scanRange(name, start, end), cn.decryptFunc(fn))
+}
+
+// ForEachValue calls fn with the key and decoded struct of each record in the namespace
+// in key order. The structs must have been stored by Save, streams stored by PutStream
+// are skipped. SEE: Chestnut.ForEach.
+func ForEachValue[T any](cn *Chestnut, name string, fn func(key []byte, v *T) error) error {
+	cn.log.Debugf("for each value: namespace: %s", name)
+	return cn.iterate("for each value", name, cn.forEach(name), loadFunc(cn, fn))
+}
+
+// ScanValues calls fn with the key and decoded struct of each record in the namespace
+// with a key that starts with prefix in key order. SEE: ForEachValue.
+func ScanValues[T any](cn *Chestnut, name string, prefix []byte, fn func(key []byte, v *T) error) error {
+	cn.log.Debugf("scan values: namespace: %s prefix: %s", name, prefix)
+	return cn.iterate("scan values", name, cn.scan(name, prefix), loadFunc(cn, fn))
+}
+
+// RangeValues calls fn with the key and decoded struct of each record in the namespace
+// with a key from start to end (inclusive) in key order. SEE: ForEachValue.
+func RangeValues[T any](cn *Chestnut, name string, start []byte, end []byte,
+	fn func(key []byte, v *T) error) error {
+	cn.log.Debugf("range values: namespace: %s from: %s to: %s", name, start, end)
+	return cn.iterate("range values", name, cn.scanRange(name, start, end), loadFunc(cn, fn))
+}
+
+// scanner iterates over the stored values in a namespace. If the store is not
+// iterable, the keys are listed and the values that match are read one at a time.
+type scanner struct {
+	scan  func(it storage.Iterable, fn storage.IterateFunc) error
+	match func(key []byte) bool
+}
+
+func (cn *Chestnut) forEach(name string) scanner {
+	return scanner{
+		scan: func(it storage.Iterable, fn storage.IterateFunc) error {
+			return it.ForEach(name, fn)
+		},
+		match: func([]byte) bool {
+			return true
+		},
+	}
+}
+
+func (cn *Chestnut) scan(name string, prefix []byte) scanner {
+	return scanner{
+		scan: func(it storage.Iterable, fn storage.IterateFunc) error {
+			return it.Scan(name, prefix, fn)
+		},
+		match: func(key []byte) bool {
+			return bytes.HasPrefix(key, prefix)
+		},
+	}
+}
+
+func (cn *Chestnut) scanRange(name string, start []byte, end []byte) scanner {
+	return scanner{
+		scan: func(it storage.Iterable, fn storage.IterateFunc) error {
+			return it.Range(name, start, end, fn)
+		},
+		match: func(key []byte) bool {
+			return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) <= 0
+		},
+	}
+}
+
+// iterate calls fn with the key and stored value of each unexpired record in the
+// namespace the scanner finds. If the store supports transactions, the records are
+// read inside of a single read transaction.
+func (cn *Chestnut) iterate(op string, name string, sc scanner, fn storage.IterateFunc) error {
+	visit := func(key []byte, value []byte) error {
+		value, expiry := decodeExpiry(value)
+		if expired(expiry) {
+			return nil
+		}
+		// keys are only valid for the life of the call
+		return fn(append([]byte{}, key...), value)
+	}
+	iterate := func(tx storage.Tx) error {
+		if it, ok := tx.(storage.Iterable); ok {
+			return sc.scan(it, visit)
+		}
+		cn.log.Debugf("%s: store is not iterable, list keys: %s", op, name)
+		keys, err := tx.List(name)
+		if err != nil {
+			return err
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+		for _, key := range keys {
+			if !sc.match(key) {
+				continue
+			}
+			value, err := tx.Get(name, key)
+			if err != nil {
+				return err
+			}
+			if err = visit(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var err error
+	if store, ok := cn.store.(storage.Transactional); ok {
+		err = store.View(iterate)
+	} else {
+		err = iterate(cn.store)
+	}
+	if errors.Is(err, ErrStopIteration) {
+		return nil
+	}
+	return cn.logError(op, err)
+}
+
+// decryptFunc returns a storage.IterateFunc that calls fn with the decrypted value.
+// Stream manifests and packages stored by Save are skipped.
+func (cn *Chestnut) decryptFunc(fn IterateFunc) storage.IterateFunc {
+	return func(key []byte, ciphertext []byte) error {
+		if isStream(ciphertext) {
+			return nil
+		} else if _, err := packager.DecodePackage(ciphertext); err == nil {
+			return nil
+		}
+		plaintext, err := cn.decrypt(ciphertext)
+		if errors.Is(err, ErrNotFound) {
+			// the sealed expiry time passed after the record was read
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if plaintext, err = cn.decompress(plaintext); err != nil {
+			return err
+		}
+		return fn(key, plaintext)
+	}
+}
+
+// loadFunc returns a storage.IterateFunc that calls fn with the decoded struct.
+// Stream manifests are skipped.
+func loadFunc[T any](cn *Chestnut, fn func(key []byte, v *T) error) storage.IterateFunc {
+	return func(key []byte, ciphertext []byte) error {
+		if isStream(ciphertext) {
+			return nil
+		}
+		v := new(T)
+		err := cn.unmarshal(ciphertext, v, false)
+		if errors.Is(err, ErrNotFound) {
+			// the sealed expiry time passed after the record was read
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return fn(key, v)
+	}
+}
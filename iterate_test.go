@@ -0,0 +1,124 @@
+package chestnut
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jrapoport/chestnut/storage"
+)
+
+const iterName = "iterate-namespace"
+
+// nonIterableStore hides the optional interfaces of a store.
+type nonIterableStore struct {
+	storage.Storage
+}
+
+func (ts *ChestnutTestSuite) putIterate(cn *Chestnut) []string {
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		err := cn.Put(iterName, []byte(keys[i]), []byte(keys[i]))
+		ts.NoError(err)
+	}
+	err := cn.Put(iterName, []byte("expired"), []byte(testValue), WithTTL(1))
+	ts.NoError(err)
+	return keys
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ForEach() {
+	for _, cn := range []*Chestnut{ts.cn, NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt)} {
+		keys := ts.putIterate(cn)
+		var found []string
+		collect := func(key []byte, plaintext []byte) error {
+			ts.Equal(string(key), string(plaintext))
+			found = append(found, string(key))
+			return nil
+		}
+		err := cn.ForEach(iterName, collect)
+		ts.NoError(err)
+		ts.Equal(keys, found)
+		found = nil
+		err = cn.Scan(iterName, []byte("key-1"), collect)
+		ts.NoError(err)
+		ts.Equal([]string{"key-1"}, found)
+		found = nil
+		err = cn.Range(iterName, []byte("key-3"), []byte("key-5"), collect)
+		ts.NoError(err)
+		ts.Equal([]string{"key-3", "key-4", "key-5"}, found)
+		errStop := errors.New("stop")
+		err = cn.ForEach(iterName, func([]byte, []byte) error {
+			return errStop
+		})
+		ts.ErrorIs(err, errStop)
+		found = nil
+		err = cn.ForEach(iterName, func(key []byte, _ []byte) error {
+			found = append(found, string(key))
+			return ErrStopIteration
+		})
+		ts.NoError(err)
+		ts.Equal(keys[:1], found)
+		err = cn.ForEach("not-found", collect)
+		ts.Error(err)
+	}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ForEachMixed() {
+	keys := ts.putIterate(ts.cn)
+	err := ts.cn.Save(iterName, []byte("key-obj"), &objectSrc)
+	ts.NoError(err)
+	err = ts.cn.PutStream(iterName, []byte("key-stream"), strings.NewReader(lorumIpsum))
+	ts.NoError(err)
+	var found []string
+	err = ts.cn.ForEach(iterName, func(key []byte, plaintext []byte) error {
+		ts.Equal(string(key), string(plaintext))
+		found = append(found, string(key))
+		return nil
+	})
+	ts.NoError(err)
+	ts.Equal(keys, found)
+	for i := range keys {
+		err = ts.cn.Delete(iterName, []byte(keys[i]))
+		ts.NoError(err)
+	}
+	found = nil
+	err = ForEachValue(ts.cn, iterName, func(key []byte, v *TObject) error {
+		ts.Equal(&objOut, v)
+		found = append(found, string(key))
+		return nil
+	})
+	ts.NoError(err)
+	ts.Equal([]string{"key-obj"}, found)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ForEachValue() {
+	for i := 0; i < 5; i++ {
+		obj := TObject{ValueA: testValue, ValueB: i}
+		err := ts.cn.Save(iterName, []byte(fmt.Sprintf("obj-%d", i)), &obj)
+		ts.NoError(err)
+	}
+	var values []int
+	collect := func(key []byte, v *TObject) error {
+		ts.Equal(fmt.Sprintf("obj-%d", v.ValueB), string(key))
+		ts.Equal(testValue, v.ValueA)
+		values = append(values, v.ValueB)
+		return nil
+	}
+	err := ForEachValue(ts.cn, iterName, collect)
+	ts.NoError(err)
+	ts.Equal([]int{0, 1, 2, 3, 4}, values)
+	values = nil
+	err = ScanValues(ts.cn, iterName, []byte("obj-2"), collect)
+	ts.NoError(err)
+	ts.Equal([]int{2}, values)
+	values = nil
+	err = RangeValues(ts.cn, iterName, []byte("obj-1"), []byte("obj-3"), collect)
+	ts.NoError(err)
+	ts.Equal([]int{1, 2, 3}, values)
+	// values stored with put cannot be decoded
+	err = ts.cn.Put(iterName, []byte("obj-5"), []byte(testValue))
+	ts.NoError(err)
+	err = ForEachValue(ts.cn, iterName, collect)
+	ts.Error(err)
+}
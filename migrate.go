@@ -0,0 +1,64 @@
+package chestnut
+
+import (
+	"errors"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+// Migrate copies the storage chest to the store dst using storage.Migrate. dst must
+// be open. If e is not nil, each record is re-encrypted with e as it is copied, and
+// a storage chest using dst must then be created with e. Otherwise the records are
+// copied as they are. If key obfuscation is enabled, dst is obfuscated with the same
+// secret. Records keep their expiry time, but a store that supports expiring keys
+// will not expire them natively. Use Reap to delete them after they expire.
+//
+// If Migrate is interrupted, calling it again with the same store will resume the
+// migration. Migrate cannot be called while a Rekey is in progress.
+func (cn *Chestnut) Migrate(dst storage.Storage, e crypto.Encryptor) error {
+	if dst == nil {
+		err := errors.New("store required")
+		return cn.logError("migrate", err)
+	}
+	if has, _ := cn.store.Has(rekeyNamespace, rekeyCheckpointKey); has {
+		err := errors.New("rekey in progress")
+		return cn.logError("migrate", err)
+	}
+	var opt []storage.MigrateOption
+	if e != nil {
+		cn.log.Infof("migrate: re-encrypt from %s to %s", cn.encryptor().Name(), e.Name())
+		opt = append(opt, storage.WithTransform(cn.migrateTransform(e)))
+	}
+	if cn.opts.obfuscation != nil {
+		var err error
+		if dst, err = newObfuscatedStore(dst, cn.opts.obfuscation, cn.log); err != nil {
+			return cn.logError("migrate", err)
+		}
+	}
+	res, err := storage.Migrate(cn.store, dst, opt...)
+	if err != nil {
+		return cn.logError("migrate", err)
+	}
+	cn.log.Infof("migrate: migrated %d keys in %d namespaces (%d skipped)",
+		res.Keys, res.Namespaces, res.Skipped)
+	return nil
+}
+
+// migrateTransform returns the transform that re-encrypts a record with e.
+func (cn *Chestnut) migrateTransform(e crypto.Encryptor) storage.TransformFunc {
+	return func(name string, key []byte, value []byte) ([]byte, error) {
+		// stream chunks are sealed with the stream key, which is re-encrypted in its
+		// manifest, and blind index entries are keyed with the index secret
+		if name == streamNamespace || name == indexNamespace {
+			return value, nil
+		}
+		// records with a ttl keep their expiry time
+		value, expiry := decodeExpiry(value)
+		value, err := cn.recrypt(value, e)
+		if err != nil {
+			return nil, err
+		}
+		return encodeExpiry(value, expiry), nil
+	}
+}
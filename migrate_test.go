@@ -0,0 +1,114 @@
+package chestnut
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+const migrateName = "migrate-namespace"
+
+func (ts *ChestnutTestSuite) openMigrated(cn *Chestnut) {
+	value, err := cn.Get(migrateName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	obj := &TObject{}
+	err = cn.Load(migrateName, []byte("b"), obj)
+	ts.NoError(err)
+	ts.Equal(&objOut, obj)
+	buf := &bytes.Buffer{}
+	err = cn.GetStream(migrateName, []byte("stream"), buf)
+	ts.NoError(err)
+	ts.Equal(lorumIpsum, buf.String())
+	has, err := cn.Has(migrateName, []byte("expired"))
+	ts.NoError(err)
+	ts.False(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Migrate() {
+	put := func(cn *Chestnut) {
+		err := cn.Put(migrateName, []byte("a"), []byte(testValue))
+		ts.Require().NoError(err)
+		err = cn.Save(migrateName, []byte("b"), &objectSrc)
+		ts.Require().NoError(err)
+		err = cn.PutStream(migrateName, []byte("stream"), strings.NewReader(lorumIpsum))
+		ts.Require().NoError(err)
+		err = cn.Put(migrateName, []byte("expired"), []byte(testValue), WithTTL(1))
+		ts.Require().NoError(err)
+	}
+	put(ts.cn)
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	migrateTests := []struct {
+		e    crypto.Encryptor
+		opts []ChestOption
+	}{
+		{nil, []ChestOption{encryptorOpt}},
+		{newEncryptor, []ChestOption{WithEncryptor(newEncryptor)}},
+	}
+	for _, storeFunc := range []StoreFunc{nutsStore, boltStore} {
+		for _, test := range migrateTests {
+			dst := storeFunc(ts.T(), ts.T().TempDir())
+			err := dst.Open()
+			ts.Require().NoError(err)
+			err = ts.cn.Migrate(dst, test.e)
+			ts.NoError(err)
+			err = dst.Close()
+			ts.NoError(err)
+			cn := NewChestnut(dst, test.opts...)
+			err = cn.Open()
+			ts.Require().NoError(err)
+			ts.openMigrated(cn)
+			list, err := cn.List(testName)
+			ts.NoError(err)
+			ts.NotEmpty(list)
+			keyMap, err := cn.store.ListAll()
+			ts.NoError(err)
+			ts.NotContains(keyMap, "__chestnut_migrate")
+			err = cn.Close()
+			ts.NoError(err)
+		}
+	}
+	// key obfuscation
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithKeyObfuscation(obfuscateSecret))
+	put(cn)
+	dst := ts.storeFunc(ts.T(), ts.T().TempDir())
+	err := dst.Open()
+	ts.Require().NoError(err)
+	err = cn.Migrate(dst, nil)
+	ts.NoError(err)
+	cn = NewChestnut(dst, encryptorOpt, WithKeyObfuscation(obfuscateSecret))
+	ts.openMigrated(cn)
+	err = cn.Close()
+	ts.NoError(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_MigrateInvalid() {
+	err := ts.cn.Migrate(nil, nil)
+	ts.Error(err)
+	err = ts.cn.Migrate(ts.cn.store, nil)
+	ts.Error(err)
+	dst := ts.storeFunc(ts.T(), ts.T().TempDir())
+	err = dst.Open()
+	ts.Require().NoError(err)
+	defer func() {
+		err = dst.Close()
+		ts.NoError(err)
+	}()
+	err = ts.cn.store.Save(rekeyNamespace, rekeyCheckpointKey, &rekeyCheckpoint{})
+	ts.Require().NoError(err)
+	err = ts.cn.Migrate(dst, nil)
+	ts.Error(err)
+	err = ts.cn.store.Delete(rekeyNamespace, rekeyCheckpointKey)
+	ts.Require().NoError(err)
+	// the records cannot be re-encrypted
+	err = ts.cn.store.Put(migrateName, []byte("a"), []byte("not-ciphertext"))
+	ts.Require().NoError(err)
+	err = ts.cn.Migrate(dst, encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret))
+	ts.Error(err)
+	_, ok := ts.cn.store.(storage.Transactional)
+	ts.True(ok)
+}
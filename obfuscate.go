@@ -0,0 +1,632 @@
+package chestnut
+
+import (
+	"bytes"
+	goaes "crypto/aes"
+	gocipher "crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/log"
+	"github.com/jrapoport/chestnut/storage"
+	jsoniter "github.com/json-iterator/go"
+	"golang.org/x/crypto/hkdf"
+)
+
+// obfuscatedNames is the reserved namespace of the namespace directory.
+const obfuscatedNames = "__chestnut_names"
+
+var (
+	obfuscateMACInfo = []byte("chestnut key obfuscation mac")
+	obfuscateDirInfo = []byte("chestnut key obfuscation directory")
+)
+
+// obfuscatedStore hides the namespaces and keys of the store it wraps. Namespaces and
+// keys are replaced by their HMAC-SHA256 before they reach the store. To list the
+// original names, each namespace has a directory that maps the HMAC of a key to
+// the key sealed with AES256-GCM, and the namespaces are kept in a directory of
+// their own. A key and its directory entry are written in the same transaction
+// if the store supports them. The store is always iterable, and keys expire
+// natively if the store supports expiring keys. A namespace stays in the namespace
+// directory after its last key is deleted, but it is not listed by ListAll.
+type obfuscatedStore struct {
+	store storage.Storage
+	mac   []byte
+	dir   gocipher.AEAD
+	log   log.Logger
+}
+
+// obfuscatedTxStore is an obfuscatedStore for a store that supports transactions.
+type obfuscatedTxStore struct {
+	*obfuscatedStore
+}
+
+var _ storage.Storage = (*obfuscatedStore)(nil)
+
+var _ storage.Transactional = (*obfuscatedTxStore)(nil)
+
+var _ storage.BackupReader = (*obfuscatedStore)(nil)
+
+var _ storage.Iterable = (*obfuscatedStore)(nil)
+
+var _ storage.Expiring = (*obfuscatedStore)(nil)
+
+// newObfuscatedStore wraps the store with an obfuscatedStore using keys derived from
+// the secret. If the store supports transactions, so does the returned store.
+func newObfuscatedStore(store storage.Storage, secret crypto.Secret, l log.Logger) (storage.Storage, error) {
+	key := secret.Open()
+	if len(key) <= 0 {
+		return nil, errors.New("key obfuscation secret cannot be empty")
+	}
+	derive := func(info []byte) ([]byte, error) {
+		k := make([]byte, crypto.Key256)
+		_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, info), k)
+		return k, err
+	}
+	mac, err := derive(obfuscateMACInfo)
+	if err != nil {
+		return nil, err
+	}
+	dirKey, err := derive(obfuscateDirInfo)
+	if err != nil {
+		return nil, err
+	}
+	block, err := goaes.NewCipher(dirKey)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := gocipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &obfuscatedStore{store: store, mac: mac, dir: dir, log: l}
+	if _, ok := store.(storage.Transactional); ok {
+		return &obfuscatedTxStore{s}, nil
+	}
+	return s, nil
+}
+
+// hash returns the hex encoded HMAC of the parts. Each part is length
+// prefixed so different parts can not produce the same hash.
+func (s *obfuscatedStore) hash(parts ...[]byte) string {
+	h := hmac.New(sha256.New, s.mac)
+	for _, p := range parts {
+		_ = binary.Write(h, binary.BigEndian, uint32(len(p)))
+		_, _ = h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// namespace returns the obfuscated namespace.
+func (s *obfuscatedStore) namespace(name string) string {
+	return s.hash([]byte("namespace"), []byte(name))
+}
+
+// directory returns the obfuscated namespace of the key directory for the namespace.
+func (s *obfuscatedStore) directory(name string) string {
+	return s.hash([]byte("directory"), []byte(name))
+}
+
+// key returns the obfuscated key.
+func (s *obfuscatedStore) key(name string, key []byte) []byte {
+	return []byte(s.hash([]byte("key"), []byte(name), key))
+}
+
+// seal encrypts a directory entry. The obfuscated name is used as additional
+// data so an entry can not be moved to another name.
+func (s *obfuscatedStore) seal(plaintext []byte, name []byte) ([]byte, error) {
+	nonce, err := crypto.MakeRand(uint(s.dir.NonceSize()))
+	if err != nil {
+		return nil, err
+	}
+	return s.dir.Seal(nonce, nonce, plaintext, name), nil
+}
+
+// open decrypts a directory entry.
+func (s *obfuscatedStore) open(sealed []byte, name []byte) ([]byte, error) {
+	size := s.dir.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("invalid directory entry")
+	}
+	return s.dir.Open(nil, sealed[:size], sealed[size:], name)
+}
+
+// update calls fn inside of a read-write transaction if the store supports them.
+func (s *obfuscatedStore) update(fn func(t *obfuscatedTx) error) error {
+	if store, ok := s.store.(storage.Transactional); ok {
+		return store.Update(func(tx storage.Tx) error {
+			return fn(s.newTx(tx))
+		})
+	}
+	return fn(s.newTx(s.store))
+}
+
+// view calls fn inside of a read-only transaction if the store supports them.
+func (s *obfuscatedStore) view(fn func(t *obfuscatedTx) error) error {
+	if store, ok := s.store.(storage.Transactional); ok {
+		return store.View(func(tx storage.Tx) error {
+			return fn(s.newTx(tx))
+		})
+	}
+	return fn(s.newTx(s.store))
+}
+
+// Open opens the store.
+func (s *obfuscatedStore) Open() error {
+	return s.store.Open()
+}
+
+// Put a value in the store.
+func (s *obfuscatedStore) Put(name string, key []byte, value []byte) error {
+	return s.update(func(t *obfuscatedTx) error {
+		return t.Put(name, key, value)
+	})
+}
+
+// PutTTL puts a value in the store that expires after the ttl.
+func (s *obfuscatedStore) PutTTL(name string, key []byte, value []byte, ttl time.Duration) error {
+	return s.update(func(t *obfuscatedTx) error {
+		return t.PutTTL(name, key, value, ttl)
+	})
+}
+
+// Get a value from the store.
+func (s *obfuscatedStore) Get(name string, key []byte) (value []byte, err error) {
+	err = s.view(func(t *obfuscatedTx) error {
+		value, err = t.Get(name, key)
+		return err
+	})
+	return
+}
+
+// Has checks for a key in the store.
+func (s *obfuscatedStore) Has(name string, key []byte) (has bool, err error) {
+	err = s.view(func(t *obfuscatedTx) error {
+		has, err = t.Has(name, key)
+		return err
+	})
+	return
+}
+
+// Save the value in v and stores the result at key.
+func (s *obfuscatedStore) Save(name string, key []byte, v interface{}) error {
+	b, err := jsoniter.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Put(name, key, b)
+}
+
+// Load the value at key and stores the result in v.
+func (s *obfuscatedStore) Load(name string, key []byte, v interface{}) error {
+	b, err := s.Get(name, key)
+	if err != nil {
+		return err
+	}
+	return jsoniter.Unmarshal(b, v)
+}
+
+// List returns a list of all keys in the namespace.
+func (s *obfuscatedStore) List(name string) (keys [][]byte, err error) {
+	err = s.view(func(t *obfuscatedTx) error {
+		keys, err = t.List(name)
+		return err
+	})
+	return
+}
+
+// ListAll returns a mapped list of all keys in the store.
+func (s *obfuscatedStore) ListAll() (map[string][][]byte, error) {
+	keyMap := map[string][][]byte{}
+	err := s.view(func(t *obfuscatedTx) error {
+		names, err := t.namespaces()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			keys, err := t.List(name)
+			if err != nil || len(keys) <= 0 {
+				// the namespace has no keys
+				continue
+			}
+			keyMap[name] = keys
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keyMap, nil
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (s *obfuscatedStore) ForEach(name string, fn storage.IterateFunc) error {
+	return s.view(func(t *obfuscatedTx) error {
+		return t.ForEach(name, fn)
+	})
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (s *obfuscatedStore) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	return s.view(func(t *obfuscatedTx) error {
+		return t.Scan(name, prefix, fn)
+	})
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (s *obfuscatedStore) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	return s.view(func(t *obfuscatedTx) error {
+		return t.Range(name, start, end, fn)
+	})
+}
+
+// Delete removes a key from the store.
+func (s *obfuscatedStore) Delete(name string, key []byte) error {
+	return s.update(func(t *obfuscatedTx) error {
+		return t.Delete(name, key)
+	})
+}
+
+// Close closes the store.
+func (s *obfuscatedStore) Close() error {
+	return s.store.Close()
+}
+
+// Export saves the store to path.
+func (s *obfuscatedStore) Export(path string) error {
+	return s.store.Export(path)
+}
+
+// Import reads the backup at path and puts its keys and values in the store. The
+// backup is imported as is, so it must have been saved with the same secret.
+func (s *obfuscatedStore) Import(path string) error {
+	return s.store.Import(path)
+}
+
+// ReadBackup calls fn for each original namespace, key, and value in the backup at path.
+// The directories in the backup are read first to find the original names.
+func (s *obfuscatedStore) ReadBackup(path string, fn storage.BackupFunc) error {
+	br, ok := s.store.(storage.BackupReader)
+	if !ok {
+		return errors.New("store cannot read backups")
+	}
+	names := map[string]string{}
+	err := br.ReadBackup(path, func(ns string, k []byte, sealed []byte) error {
+		if ns != obfuscatedNames {
+			return nil
+		}
+		name, err := s.open(sealed, k)
+		if err != nil {
+			return fmt.Errorf("namespace directory: %w", err)
+		}
+		names[string(k)] = string(name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	dirs := map[string]string{}
+	for _, name := range names {
+		dirs[s.directory(name)] = name
+	}
+	keys := map[string]map[string][]byte{}
+	err = br.ReadBackup(path, func(ns string, k []byte, sealed []byte) error {
+		name, ok := dirs[ns]
+		if !ok {
+			return nil
+		}
+		key, err := s.open(sealed, k)
+		if err != nil {
+			return fmt.Errorf("directory %s: %w", name, err)
+		}
+		if keys[name] == nil {
+			keys[name] = map[string][]byte{}
+		}
+		keys[name][string(k)] = key
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return br.ReadBackup(path, func(ns string, k []byte, value []byte) error {
+		name, ok := names[ns]
+		if !ok {
+			// a directory
+			return nil
+		}
+		key, ok := keys[name][string(k)]
+		if !ok {
+			return fmt.Errorf("key not in directory %s: %s", name, k)
+		}
+		return fn(name, key, value)
+	})
+}
+
+// Update executes fn inside of a read-write transaction.
+func (s *obfuscatedTxStore) Update(fn func(tx storage.Tx) error) error {
+	return s.update(func(t *obfuscatedTx) error {
+		return fn(t)
+	})
+}
+
+// View executes fn inside of a read-only transaction.
+func (s *obfuscatedTxStore) View(fn func(tx storage.Tx) error) error {
+	return s.view(func(t *obfuscatedTx) error {
+		return fn(t)
+	})
+}
+
+// obfuscatedTx is the storage Tx for an obfuscatedStore.
+type obfuscatedTx struct {
+	s  *obfuscatedStore
+	tx storage.Tx
+	// dirs caches the opened keys of each directory read in the transaction.
+	dirs map[string][][]byte
+}
+
+var _ storage.Tx = (*obfuscatedTx)(nil)
+
+var _ storage.Iterable = (*obfuscatedTx)(nil)
+
+var _ storage.Expiring = (*obfuscatedTx)(nil)
+
+func (s *obfuscatedStore) newTx(tx storage.Tx) *obfuscatedTx {
+	return &obfuscatedTx{s: s, tx: tx}
+}
+
+// Put a value in the transaction and adds the key to the directory.
+func (t *obfuscatedTx) Put(name string, key []byte, value []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	}
+	k := t.s.key(name, key)
+	if err := t.tx.Put(t.s.namespace(name), k, value); err != nil {
+		return err
+	}
+	if e, ok := t.tx.(storage.Expiring); ok {
+		// the entry may have been added with a ttl
+		if err := t.putEntry(e, t.s.directory(name), k, key, 0); err != nil {
+			return err
+		}
+	} else if err := t.addEntry(t.s.directory(name), k, key); err != nil {
+		return err
+	}
+	t.cacheKey(name, key)
+	return t.addEntry(obfuscatedNames, []byte(t.s.namespace(name)), []byte(name))
+}
+
+// PutTTL puts a value in the transaction that expires after the ttl. The directory
+// entry of the key expires with it. If the store does not support expiring keys,
+// the value is put without a ttl.
+func (t *obfuscatedTx) PutTTL(name string, key []byte, value []byte, ttl time.Duration) error {
+	e, ok := t.tx.(storage.Expiring)
+	if !ok {
+		return t.Put(name, key, value)
+	}
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	}
+	k := t.s.key(name, key)
+	if err := e.PutTTL(t.s.namespace(name), k, value, ttl); err != nil {
+		return err
+	}
+	if err := t.putEntry(e, t.s.directory(name), k, key, ttl); err != nil {
+		return err
+	}
+	t.cacheKey(name, key)
+	return t.addEntry(obfuscatedNames, []byte(t.s.namespace(name)), []byte(name))
+}
+
+// putEntry puts the sealed plaintext in the directory at key. If ttl > 0, the entry expires.
+func (t *obfuscatedTx) putEntry(e storage.Expiring, dir string, key []byte, plaintext []byte,
+	ttl time.Duration) error {
+	sealed, err := t.s.seal(plaintext, key)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return e.PutTTL(dir, key, sealed, ttl)
+	}
+	return t.tx.Put(dir, key, sealed)
+}
+
+// addEntry adds the sealed plaintext to the directory at key if it is not there yet.
+func (t *obfuscatedTx) addEntry(dir string, key []byte, plaintext []byte) error {
+	if has, _ := t.tx.Has(dir, key); has {
+		return nil
+	}
+	sealed, err := t.s.seal(plaintext, key)
+	if err != nil {
+		return err
+	}
+	return t.tx.Put(dir, key, sealed)
+}
+
+// Get a value from the transaction.
+func (t *obfuscatedTx) Get(name string, key []byte) ([]byte, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return nil, err
+	}
+	return t.tx.Get(t.s.namespace(name), t.s.key(name, key))
+}
+
+// Has checks for a key in the transaction.
+func (t *obfuscatedTx) Has(name string, key []byte) (bool, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return false, err
+	}
+	return t.tx.Has(t.s.namespace(name), t.s.key(name, key))
+}
+
+// List returns the original keys in the namespace from its directory.
+func (t *obfuscatedTx) List(name string) ([][]byte, error) {
+	keys, err := t.directoryKeys(name)
+	if err != nil {
+		return nil, err
+	}
+	return append([][]byte{}, keys...), nil
+}
+
+// directoryKeys returns the original keys in the namespace from its directory in
+// key order. The keys are cached, so the directory is only read and opened once
+// in the transaction. The cached keys are not changed, they are replaced.
+func (t *obfuscatedTx) directoryKeys(name string) ([][]byte, error) {
+	if keys, ok := t.dirs[name]; ok {
+		return keys, nil
+	}
+	dir := t.s.directory(name)
+	entries, err := t.tx.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(entries))
+	for _, k := range entries {
+		sealed, err := t.tx.Get(dir, k)
+		if err != nil {
+			return nil, err
+		}
+		key, err := t.s.open(sealed, k)
+		if err != nil {
+			return nil, fmt.Errorf("directory %s: %w", name, err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	if t.dirs == nil {
+		t.dirs = map[string][][]byte{}
+	}
+	t.dirs[name] = keys
+	return keys, nil
+}
+
+// cacheKey adds the key to the cached keys of the namespace, if they are cached.
+func (t *obfuscatedTx) cacheKey(name string, key []byte) {
+	keys, ok := t.dirs[name]
+	if !ok {
+		return
+	}
+	i := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], key) >= 0
+	})
+	if i < len(keys) && bytes.Equal(keys[i], key) {
+		return
+	}
+	cached := make([][]byte, 0, len(keys)+1)
+	cached = append(cached, keys[:i]...)
+	cached = append(cached, append([]byte{}, key...))
+	t.dirs[name] = append(cached, keys[i:]...)
+}
+
+// uncacheKey removes the key from the cached keys of the namespace, if they are cached.
+func (t *obfuscatedTx) uncacheKey(name string, key []byte) {
+	keys, ok := t.dirs[name]
+	if !ok {
+		return
+	}
+	i := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], key) >= 0
+	})
+	if i >= len(keys) || !bytes.Equal(keys[i], key) {
+		return
+	}
+	cached := make([][]byte, 0, len(keys)-1)
+	cached = append(cached, keys[:i]...)
+	t.dirs[name] = append(cached, keys[i+1:]...)
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (t *obfuscatedTx) ForEach(name string, fn storage.IterateFunc) error {
+	return t.iterate(name, func([]byte) bool {
+		return true
+	}, fn)
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (t *obfuscatedTx) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	return t.iterate(name, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}, fn)
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (t *obfuscatedTx) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	return t.iterate(name, func(key []byte) bool {
+		return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) <= 0
+	}, fn)
+}
+
+// iterate calls fn in key order for each original key in the namespace that matches.
+// The obfuscated keys are not in order, so the keys are read from the directory first.
+func (t *obfuscatedTx) iterate(name string, match func(key []byte) bool, fn storage.IterateFunc) error {
+	keys, err := t.directoryKeys(name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !match(key) {
+			continue
+		}
+		ns, k := t.s.namespace(name), t.s.key(name, key)
+		// the key expired after it was listed
+		if has, _ := t.tx.Has(ns, k); !has {
+			continue
+		}
+		value, err := t.tx.Get(ns, k)
+		if err != nil {
+			return err
+		}
+		if err = fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespaces returns the original namespaces from the namespace directory.
+func (t *obfuscatedTx) namespaces() ([]string, error) {
+	entries, err := t.tx.List(obfuscatedNames)
+	if err != nil {
+		// there are no namespaces yet
+		return nil, nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, n := range entries {
+		sealed, err := t.tx.Get(obfuscatedNames, n)
+		if err != nil {
+			return nil, err
+		}
+		name, err := t.s.open(sealed, n)
+		if err != nil {
+			return nil, fmt.Errorf("namespace directory: %w", err)
+		}
+		names = append(names, string(name))
+	}
+	return names, nil
+}
+
+// Delete removes a key and its directory entry from the transaction. The namespace
+// is left in the namespace directory, and is skipped by ListAll once it is empty.
+func (t *obfuscatedTx) Delete(name string, key []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	}
+	k := t.s.key(name, key)
+	if err := t.tx.Delete(t.s.namespace(name), k); err != nil {
+		return err
+	}
+	if err := t.tx.Delete(t.s.directory(name), k); err != nil {
+		return err
+	}
+	t.uncacheKey(name, key)
+	return nil
+}
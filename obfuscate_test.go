@@ -0,0 +1,176 @@
+package chestnut
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+const obfuscateName = "secret-namespace"
+
+var obfuscateSecret = crypto.TextSecret("i-am-an-obfuscation-secret")
+
+func (ts *ChestnutTestSuite) TestChestnut_KeyObfuscation() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithKeyObfuscation(obfuscateSecret))
+	_, ok := cn.store.(storage.Transactional)
+	ts.True(ok)
+	keys := []string{"user-1", "user-2", "user-3"}
+	for _, key := range keys {
+		err := cn.Put(obfuscateName, []byte(key), []byte(testValue))
+		ts.NoError(err)
+	}
+	err := cn.Save(obfuscateName, []byte("object"), &objectSrc)
+	ts.NoError(err)
+	err = cn.Update(func(tx *Tx) error {
+		return tx.Put(obfuscateName, []byte("tx"), []byte(testValue))
+	})
+	ts.NoError(err)
+	value, err := cn.Get(obfuscateName, []byte("user-1"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	obj := &TObject{}
+	err = cn.Load(obfuscateName, []byte("object"), obj)
+	ts.NoError(err)
+	ts.Equal(&objOut, obj)
+	has, err := cn.Has(obfuscateName, []byte("user-2"))
+	ts.NoError(err)
+	ts.True(has)
+	err = cn.Delete(obfuscateName, []byte("user-2"))
+	ts.NoError(err)
+	expected := []string{"object", "tx", "user-1", "user-3"}
+	list, err := cn.List(obfuscateName)
+	ts.NoError(err)
+	ts.ElementsMatch(expected, toStrings(list))
+	keyMap, err := cn.ListAll()
+	ts.NoError(err)
+	ts.Len(keyMap, 1)
+	ts.ElementsMatch(expected, toStrings(keyMap[obfuscateName]))
+	var found []string
+	err = cn.Scan(obfuscateName, []byte("user-"), func(key []byte, plaintext []byte) error {
+		found = append(found, string(key))
+		return nil
+	})
+	ts.NoError(err)
+	ts.Equal([]string{"user-1", "user-3"}, found)
+	// the names are not in the store
+	raw, err := ts.cn.store.ListAll()
+	ts.NoError(err)
+	ts.NotContains(raw, obfuscateName)
+	for name, keys := range raw {
+		ts.NotContains(name, "secret")
+		for _, key := range keys {
+			ts.False(bytes.Contains(key, []byte("user-")), "%s.%s", name, key)
+		}
+	}
+	// a different secret does not find them
+	other := NewChestnut(ts.cn.store, encryptorOpt, WithKeyObfuscation(crypto.TextSecret("other")))
+	has, _ = other.Has(obfuscateName, []byte("user-1"))
+	ts.False(has)
+	_, err = other.List(obfuscateName)
+	ts.Error(err)
+	// stores without transactions
+	cn = NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt, WithKeyObfuscation(obfuscateSecret))
+	_, ok = cn.store.(storage.Transactional)
+	ts.False(ok)
+	err = cn.Put(obfuscateName, []byte("user-4"), []byte(testValue))
+	ts.NoError(err)
+	list, err = cn.List(obfuscateName)
+	ts.NoError(err)
+	ts.ElementsMatch(append(expected, "user-4"), toStrings(list))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_KeyObfuscationStore() {
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithKeyObfuscation(obfuscateSecret))
+	it, ok := cn.store.(storage.Iterable)
+	ts.Require().True(ok)
+	for _, key := range []string{"c", "a", "b"} {
+		err := cn.store.Put(obfuscateName, []byte(key), []byte(key))
+		ts.NoError(err)
+	}
+	var found []string
+	err := it.Range(obfuscateName, []byte("b"), []byte("c"), func(key []byte, value []byte) error {
+		ts.Equal(string(key), string(value))
+		found = append(found, string(key))
+		return nil
+	})
+	ts.NoError(err)
+	ts.Equal([]string{"b", "c"}, found)
+	// the directory is cached in the transaction and kept up to date
+	s, ok := cn.store.(*obfuscatedTxStore)
+	ts.Require().True(ok)
+	err = s.update(func(t *obfuscatedTx) error {
+		if err := t.ForEach(obfuscateName, func([]byte, []byte) error { return nil }); err != nil {
+			return err
+		}
+		ts.Len(t.dirs[obfuscateName], 3)
+		if err := t.Put(obfuscateName, []byte("bb"), []byte("bb")); err != nil {
+			return err
+		}
+		if err := t.Delete(obfuscateName, []byte("c")); err != nil {
+			return err
+		}
+		var keys []string
+		err := t.Scan(obfuscateName, []byte("b"), func(key []byte, _ []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		ts.Equal([]string{"b", "bb"}, keys)
+		return err
+	})
+	ts.NoError(err)
+	// the namespace is not listed once its last key is deleted
+	for _, key := range []string{"a", "b", "bb"} {
+		err = cn.Delete(obfuscateName, []byte(key))
+		ts.NoError(err)
+	}
+	keyMap, err := cn.ListAll()
+	ts.NoError(err)
+	ts.NotContains(keyMap, obfuscateName)
+	// keys expire natively if the store supports it
+	e, ok := cn.store.(storage.Expiring)
+	ts.Require().True(ok)
+	err = e.PutTTL(obfuscateName, []byte("ttl"), []byte(testValue), time.Second)
+	ts.NoError(err)
+	has, err := cn.store.Has(obfuscateName, []byte("ttl"))
+	ts.NoError(err)
+	ts.True(has)
+	time.Sleep(2 * time.Second)
+	_, native := ts.cn.store.(storage.Expiring)
+	has, _ = cn.store.Has(obfuscateName, []byte("ttl"))
+	ts.Equal(!native, has)
+	keys, _ := cn.store.List(obfuscateName)
+	ts.Equal(!native, len(keys) == 1)
+	// an expired key can be put again
+	err = cn.store.Put(obfuscateName, []byte("ttl"), []byte(testValue))
+	ts.NoError(err)
+	keys, err = cn.store.List(obfuscateName)
+	ts.NoError(err)
+	ts.Equal([]string{"ttl"}, toStrings(keys))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_ListAll() {
+	err := ts.cn.Put(iterName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	err = ts.cn.Put(iterName, []byte("expired"), []byte(testValue), WithTTL(1))
+	ts.NoError(err)
+	err = ts.cn.PutStream(iterName, []byte("stream"), strings.NewReader(testValue))
+	ts.NoError(err)
+	keyMap, err := ts.cn.ListAll()
+	ts.NoError(err)
+	ts.NotContains(keyMap, streamNamespace)
+	ts.ElementsMatch([]string{"a", "stream"}, toStrings(keyMap[iterName]))
+	list, err := ts.cn.List(testName)
+	ts.NoError(err)
+	ts.ElementsMatch(toStrings(list), toStrings(keyMap[testName]))
+}
+
+func toStrings(keys [][]byte) []string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = string(key)
+	}
+	return strs
+}
@@ -1,6 +1,8 @@
 package chestnut
 
 import (
+	"time"
+
 	"github.com/jrapoport/chestnut/encoding/compress"
 	"github.com/jrapoport/chestnut/encryptor"
 	"github.com/jrapoport/chestnut/encryptor/crypto"
@@ -20,7 +22,37 @@ type ChestOptions struct {
 	// if Overwrite is false, overwrite are disabled and successive calls to save data
 	// 	with the same key will fail with an error. The existing data will not be overwritten.
 	overwrites bool
-	log        log.Logger
+	// readOnly prevents the storage chest from writing to the store.
+	// if readOnly is true, writes fail with ErrReadOnly before anything is encrypted.
+	readOnly bool
+	// rekeyEncryptor is the other encryptor of an incomplete rekey.
+	// if rekeyEncryptor is nil, Open fails if it finds an incomplete rekey.
+	rekeyEncryptor crypto.Encryptor
+	// reapInterval is the interval at which the reaper deletes expired records.
+	// if reapInterval is 0, the reaper is disabled.
+	reapInterval time.Duration
+	// chunkSize is the size of the chunks a stream is split into.
+	// if chunkSize is 0, DefaultChunkSize is used.
+	chunkSize int
+	// watchHistory is the number of events kept for subscribers to replay.
+	// if watchHistory is 0, DefaultWatchHistory is used.
+	watchHistory int
+	// obfuscation is the secret used to obfuscate namespaces and keys.
+	// if obfuscation is nil, namespaces and keys are stored as is.
+	obfuscation crypto.Secret
+	// index is the secret used to key the blind indexes.
+	// if index is nil, blind indexes are disabled.
+	index crypto.Secret
+	// cache enables the cache of decrypted values.
+	// if cache is false, every Get decrypts the value.
+	cache bool
+	// cacheEntries is the most values the cache holds. if cacheEntries is 0, it is not limited.
+	cacheEntries int
+	// cacheBytes is the most bytes the cache holds. if cacheBytes is 0, it is not limited.
+	cacheBytes int
+	// cacheTTL is how long a value is cached. if cacheTTL is 0, values do not expire.
+	cacheTTL time.Duration
+	log      log.Logger
 }
 
 // DefaultChestOptions represents the recommended default ChestOptions for a store.
@@ -95,6 +127,18 @@ func WithEncryptor(e crypto.Encryptor) ChestOption {
 	})
 }
 
+// WithRekeyEncryptor returns a ChestOption that specifies the other encryptor of a rekey
+// that did not complete. If Open finds the checkpoint of an incomplete rekey, the storage
+// chest needs both the encryptor the records were rekeyed from and the encryptor they were
+// rekeyed to. One is set with WithEncryptor (or WithAES), and the other with this option,
+// in either order. The storage chest then writes with the encryptor the records are being
+// rekeyed to, and reads with both until Rekey is called again to complete the rekey.
+func WithRekeyEncryptor(e crypto.Encryptor) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.rekeyEncryptor = e
+	})
+}
+
 // WithEncryptorChain returns a ChestOption that specifies an encryptor chain.
 // for encrypted stores. The first encryptor will be the outer most,
 // while the last encryptor will be the inner most wrapper around the real call.
@@ -109,8 +153,9 @@ func WithEncryptorChain(encryptors ...crypto.Encryptor) ChestOption {
 
 // WithAES is a convenience that returns a ChestOption which sets the encryptor
 // to be an AESEncryptor initialized with a key length, cipher mode, and Secret.
-func WithAES(keyLen crypto.KeyLen, mode crypto.Mode, secret crypto.Secret) ChestOption {
-	return WithEncryptor(encryptor.NewAESEncryptor(keyLen, mode, secret))
+func WithAES(keyLen crypto.KeyLen, mode crypto.Mode, secret crypto.Secret,
+	opt ...encryptor.AESOption) ChestOption {
+	return WithEncryptor(encryptor.NewAESEncryptor(keyLen, mode, secret, opt...))
 }
 
 // WithCompressors instructs the storage chest to compress/decompress data with these compressor
@@ -140,6 +185,84 @@ func OverwritesForbidden() ChestOption {
 	})
 }
 
+// ReadOnly returns a ChestOption that prevents the storage chest from writing to the
+// store. Writes return ErrReadOnly before any encryption work is done, and the reaper
+// is not started. To keep the backing database from being opened for writing too,
+// open the store with storage.ReadOnly. A nuts store still locks its directory
+// exclusively when opened read-only, so it cannot be shared with a writer.
+func ReadOnly() ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.readOnly = true
+	})
+}
+
+// WithReaper returns a ChestOption that starts a background reaper when the storage
+// chest is opened. The reaper deletes expired records (SEE: WithTTL) at each interval
+// until the storage chest is closed. If interval is 0, the reaper is disabled.
+func WithReaper(interval time.Duration) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.reapInterval = interval
+	})
+}
+
+// WithChunkSize returns a ChestOption that sets the size of the chunks PutStream
+// splits a stream into. Larger chunks use more memory, but fewer records. If size
+// is 0, DefaultChunkSize is used. Changing the chunk size only affects new streams.
+func WithChunkSize(size int) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.chunkSize = size
+	})
+}
+
+// WithWatchHistory returns a ChestOption that sets the number of events kept in
+// memory for Watch. A subscriber can replay any event in the history (SEE: WatchFrom)
+// and can fall this many events behind before it misses events. If size is 0,
+// DefaultWatchHistory is used.
+func WithWatchHistory(size int) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.watchHistory = size
+	})
+}
+
+// WithKeyObfuscation returns a ChestOption that hides the namespaces and keys from
+// the store. Namespaces and keys are replaced with their HMAC using a key derived from
+// the secret before they are stored, and the original names are kept in an encrypted
+// directory so they can still be listed. The secret should be a long random value,
+// and it must be the same each time the storage chest is opened.
+func WithKeyObfuscation(secret crypto.Secret) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.obfuscation = secret
+	})
+}
+
+// WithBlindIndex returns a ChestOption that enables blind indexes. When a struct is
+// saved, each string field with the index tag option is added to an index using its
+// HMAC with a key derived from the secret, so the struct can be found by the value of
+// the field with FindBy. The secret must be the same each time the storage chest is
+// opened, or the records saved before it changed will not be found.
+func WithBlindIndex(secret crypto.Secret) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.index = secret
+	})
+}
+
+// WithCache returns a ChestOption that caches the plaintexts returned by Get, so a
+// hot key is only decrypted once. The least recently used values are evicted when
+// the cache holds more than maxEntries values or maxBytes bytes, and values are
+// cached for at most the ttl. If maxEntries or maxBytes is 0, that limit is not
+// enforced, but at least one of them must be set. If ttl is 0, values stay in the
+// cache until they are evicted or expire (SEE: WithTTL). Put, Save, Delete, and
+// Import on the storage chest remove the values they change from the cache, but
+// writes to the store made by anything else do not. Removed values are zeroed.
+func WithCache(maxEntries int, maxBytes int, ttl time.Duration) ChestOption {
+	return newFuncOption(func(o *ChestOptions) {
+		o.cache = true
+		o.cacheEntries = maxEntries
+		o.cacheBytes = maxBytes
+		o.cacheTTL = ttl
+	})
+}
+
 // WithLogger returns a StoreOption which sets the logger to use for the encrypted store.
 func WithLogger(l log.Logger) ChestOption {
 	return newFuncOption(func(o *ChestOptions) {
@@ -0,0 +1,271 @@
+package chestnut
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Query operators supported by Where.
+const (
+	OpEqual        = "="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+)
+
+// Query finds the sparse structs in a namespace by the plaintext values of their fields.
+// The predicates are evaluated against the plaintext of each struct, so nothing is
+// decrypted to find a struct. Only structs with secure fields are stored sparsely (SEE:
+// Sparse), other structs are fully encrypted and never match. Secure fields are
+// replaced by a lookup token in the plaintext and never match either.
+type Query struct {
+	cn    *Chestnut
+	name  string
+	preds []predicate
+	limit int
+	err   error
+}
+
+// predicate compares the plaintext value of a field with a value.
+type predicate struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+// QueryResult is a struct found by a Query.
+type QueryResult struct {
+	// Key is the key of the struct.
+	Key   []byte
+	cn    *Chestnut
+	value []byte
+}
+
+// Query returns a new Query of the structs in the namespace.
+func (cn *Chestnut) Query(name string) *Query {
+	return &Query{cn: cn, name: name}
+}
+
+// Where adds a predicate to the query. A struct matches the query if it matches
+// every predicate. The field is the JSON name of the field, and the fields of
+// nested structs are separated by a '.', e.g. "address.city". The operator is
+// one of =, !=, <, <=, >, or >=. Strings and numbers can be compared with any
+// operator, bools and nil only with = and !=. Structs without the field never match.
+func (q *Query) Where(field string, op string, value interface{}) *Query {
+	if q.err != nil {
+		return q
+	}
+	switch op {
+	case OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		break
+	default:
+		q.err = fmt.Errorf("invalid query operator: %s", op)
+		return q
+	}
+	if field == "" {
+		q.err = errors.New("query field required")
+		return q
+	}
+	v, ok := queryValue(value)
+	if !ok {
+		q.err = fmt.Errorf("invalid query value: %T", value)
+		return q
+	}
+	q.preds = append(q.preds, predicate{strings.Split(field, "."), op, v})
+	return q
+}
+
+// Limit limits the number of structs the query finds to n. If n is 0, there is no limit.
+func (q *Query) Limit(n int) *Query {
+	if q.err == nil && n < 0 {
+		q.err = fmt.Errorf("invalid query limit: %d", n)
+	}
+	q.limit = n
+	return q
+}
+
+// Keys returns the keys of the structs that match the query in key order.
+func (q *Query) Keys() ([][]byte, error) {
+	results, err := q.Results()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(results))
+	for i, res := range results {
+		keys[i] = res.Key
+	}
+	return keys, nil
+}
+
+// Results returns the structs that match the query in key order.
+func (q *Query) Results() ([]*QueryResult, error) {
+	cn := q.cn
+	cn.log.Debugf("query: namespace: %s", q.name)
+	if q.err != nil {
+		return nil, cn.logError("query", q.err)
+	}
+	results := []*QueryResult{}
+	err := cn.iterate("query", q.name, cn.forEach(q.name), func(key []byte, value []byte) error {
+		if !q.match(value) {
+			return nil
+		}
+		// values are only valid for the life of the call
+		res := &QueryResult{Key: key, cn: cn, value: append([]byte{}, value...)}
+		results = append(results, res)
+		if q.limit > 0 && len(results) >= q.limit {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cn.log.Debugf("query: found %d structs", len(results))
+	return results, nil
+}
+
+// match returns true if the sparse plaintext of the stored value matches the query.
+func (q *Query) match(value []byte) bool {
+	pkg, err := packager.DecodePackage(value)
+	if err != nil || pkg.Format != packager.Sparse {
+		return false
+	}
+	encoded := pkg.Encoded
+	if pkg.Compressed {
+		if encoded, err = q.cn.decompress(encoded); err != nil {
+			return false
+		}
+	}
+	doc := map[string]interface{}{}
+	if err = jsoniter.Unmarshal(encoded, &doc); err != nil {
+		return false
+	}
+	for _, p := range q.preds {
+		actual, ok := fieldValue(doc, p.path)
+		// secure fields are replaced by a lookup token
+		if s, isString := actual.(string); !ok || isString && strings.HasPrefix(s, pkg.Token) {
+			return false
+		}
+		if !p.match(actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns true if the actual value matches the predicate.
+func (p predicate) match(actual interface{}) bool {
+	c, ordered, ok := compare(actual, p.value)
+	if !ok {
+		return p.op == OpNotEqual
+	}
+	switch p.op {
+	case OpEqual:
+		return c == 0
+	case OpNotEqual:
+		return c != 0
+	}
+	if !ordered {
+		return false
+	}
+	switch p.op {
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	case OpGreater:
+		return c > 0
+	case OpGreaterEqual:
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+// Sparse decodes the struct into v without decrypting its secure fields. SEE: Sparse.
+func (r *QueryResult) Sparse(v interface{}) error {
+	return r.cn.logError("sparse", r.cn.unmarshal(r.value, v, true))
+}
+
+// Load decrypts the struct and decodes it into v. SEE: Load.
+func (r *QueryResult) Load(v interface{}) error {
+	return r.cn.logError("load", r.cn.unmarshal(r.value, v, false))
+}
+
+// fieldValue returns the value at the path in the JSON document.
+func fieldValue(doc map[string]interface{}, path []string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, name := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// queryValue returns the value as it is decoded from JSON. Numbers are float64.
+func queryValue(value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return nil, false
+	}
+}
+
+// compare compares a and b. ordered is true if a and b can be ordered, and ok
+// is false if a and b are different types and cannot be compared at all.
+func compare(a interface{}, b interface{}) (c int, ordered bool, ok bool) {
+	switch av := a.(type) {
+	case string:
+		if bv, isString := b.(string); isString {
+			return strings.Compare(av, bv), true, true
+		}
+	case float64:
+		if bv, isFloat := b.(float64); isFloat {
+			switch {
+			case av < bv:
+				return -1, true, true
+			case av > bv:
+				return 1, true, true
+			default:
+				return 0, true, true
+			}
+		}
+	case bool:
+		if bv, isBool := b.(bool); isBool {
+			if av == bv {
+				return 0, false, true
+			}
+			return 1, false, true
+		}
+	case nil:
+		if b == nil {
+			return 0, false, true
+		}
+	}
+	return 0, false, false
+}
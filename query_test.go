@@ -0,0 +1,125 @@
+package chestnut
+
+import (
+	"github.com/jrapoport/chestnut/encoding/compress"
+)
+
+const queryName = "query-namespace"
+
+type TAddress struct {
+	City string `json:"city"`
+}
+
+type TQuery struct {
+	Status  string   `json:"status"`
+	Age     int      `json:"age"`
+	Active  bool     `json:"active"`
+	Address TAddress `json:"address"`
+	Secret  string   `json:"secret,secure"`
+}
+
+var queryObjs = map[string]*TQuery{
+	"a": {"active", 20, true, TAddress{"paris"}, "secret-a"},
+	"b": {"active", 30, true, TAddress{"london"}, "secret-b"},
+	"c": {"inactive", 40, false, TAddress{"paris"}, "secret-c"},
+	"d": {"active", 50, false, TAddress{"rome"}, "secret-d"},
+}
+
+func (ts *ChestnutTestSuite) putQuery(cn *Chestnut) {
+	for key, obj := range queryObjs {
+		err := cn.Save(queryName, []byte(key), obj)
+		ts.Require().NoError(err)
+	}
+	// structs without secure fields are not sparse
+	err := cn.Save(queryName, []byte("e"), &struct {
+		Status string `json:"status"`
+	}{"active"})
+	ts.Require().NoError(err)
+	err = cn.Put(queryName, []byte("f"), []byte(testValue))
+	ts.Require().NoError(err)
+}
+
+func (ts *ChestnutTestSuite) queryKeys(q *Query) []string {
+	keys, err := q.Keys()
+	ts.NoError(err)
+	return toStrings(keys)
+}
+
+func (ts *ChestnutTestSuite) testQuery(cn *Chestnut) {
+	ts.putQuery(cn)
+	queryTests := []struct {
+		field string
+		op    string
+		value interface{}
+		keys  []string
+	}{
+		{"status", OpEqual, "active", []string{"a", "b", "d"}},
+		{"status", OpNotEqual, "active", []string{"c"}},
+		{"age", OpLess, 30, []string{"a"}},
+		{"age", OpLessEqual, 30, []string{"a", "b"}},
+		{"age", OpGreater, 30.5, []string{"c", "d"}},
+		{"age", OpGreaterEqual, uint8(50), []string{"d"}},
+		{"active", OpEqual, false, []string{"c", "d"}},
+		{"active", OpGreater, false, []string{}},
+		{"address.city", OpEqual, "paris", []string{"a", "c"}},
+		{"address.zip", OpEqual, "paris", []string{}},
+		{"status", OpEqual, nil, []string{}},
+		{"status", OpLess, 1, []string{}},
+		// secure fields are not in the plaintext
+		{"secret", OpEqual, "secret-a", []string{}},
+		{"secret", OpNotEqual, "secret-a", []string{}},
+	}
+	for _, test := range queryTests {
+		q := cn.Query(queryName).Where(test.field, test.op, test.value)
+		ts.Equal(test.keys, ts.queryKeys(q), "%s %s %v", test.field, test.op, test.value)
+	}
+	q := cn.Query(queryName).
+		Where("status", OpEqual, "active").
+		Where("address.city", OpNotEqual, "rome")
+	ts.Equal([]string{"a", "b"}, ts.queryKeys(q))
+	ts.Equal([]string{"a"}, ts.queryKeys(q.Limit(1)))
+	// no predicates match every sparse struct
+	ts.Equal([]string{"a", "b", "c", "d"}, ts.queryKeys(cn.Query(queryName)))
+	results, err := cn.Query(queryName).Where("age", OpEqual, 40).Results()
+	ts.NoError(err)
+	ts.Require().Len(results, 1)
+	ts.Equal("c", string(results[0].Key))
+	sparse := &TQuery{}
+	err = results[0].Sparse(sparse)
+	ts.NoError(err)
+	ts.Equal(queryObjs["c"].Address, sparse.Address)
+	ts.Empty(sparse.Secret)
+	loaded := &TQuery{}
+	err = results[0].Load(loaded)
+	ts.NoError(err)
+	ts.Equal(queryObjs["c"], loaded)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Query() {
+	ts.testQuery(ts.cn)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_QueryNonIterable() {
+	ts.testQuery(NewChestnut(&nonIterableStore{ts.cn.store}, encryptorOpt))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_QueryCompressed() {
+	ts.testQuery(NewChestnut(ts.cn.store, encryptorOpt, WithCompression(compress.Zstd)))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_QueryInvalid() {
+	ts.putQuery(ts.cn)
+	queries := []*Query{
+		ts.cn.Query(queryName).Where("status", "~", "active"),
+		ts.cn.Query(queryName).Where("", OpEqual, "active"),
+		ts.cn.Query(queryName).Where("status", OpEqual, []string{"active"}),
+		ts.cn.Query(queryName).Limit(-1),
+		ts.cn.Query(queryName).Where("status", "~", "active").Where("status", OpEqual, "active"),
+	}
+	for _, q := range queries {
+		_, err := q.Keys()
+		ts.Error(err)
+		_, err = q.Results()
+		ts.Error(err)
+	}
+}
@@ -0,0 +1,352 @@
+package chestnut
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+// rekeyNamespace is the reserved namespace used to store the rekey checkpoint.
+const rekeyNamespace = "__chestnut_rekey"
+
+var rekeyCheckpointKey = []byte("checkpoint")
+
+// ErrRekeyIncomplete the storage chest has a rekey that did not complete.
+var ErrRekeyIncomplete = errors.New("incomplete rekey")
+
+// RekeyProgress describes the progress of a call to Rekey.
+type RekeyProgress struct {
+	// Namespace is the namespace of the last record processed.
+	Namespace string
+	// Key is the key of the last record processed.
+	Key []byte
+	// Done is the number of records processed so far, including
+	// any records that were skipped when resuming from a checkpoint.
+	Done int
+	// Total is the total number of records in the storage chest.
+	Total int
+}
+
+// RekeyProgressFunc is the prototype for the rekey progress callback.
+type RekeyProgressFunc func(p RekeyProgress)
+
+// RekeyOptions provides the options for a call to Rekey.
+type RekeyOptions struct {
+	// dryRun decrypts and re-encrypts every record without writing it.
+	dryRun bool
+	// progress is called after each record is processed.
+	progress RekeyProgressFunc
+}
+
+// A RekeyOption sets options such as dry-run mode and progress callbacks.
+type RekeyOption interface {
+	apply(*RekeyOptions)
+}
+
+// rekeyFuncOption wraps a function that modifies RekeyOptions
+// into an implementation of the RekeyOption interface.
+type rekeyFuncOption struct {
+	f func(*RekeyOptions)
+}
+
+// apply applies an Option to RekeyOptions.
+func (fdo *rekeyFuncOption) apply(do *RekeyOptions) {
+	fdo.f(do)
+}
+
+func newRekeyFuncOption(f func(*RekeyOptions)) *rekeyFuncOption {
+	return &rekeyFuncOption{
+		f: f,
+	}
+}
+
+// RekeyDryRun returns a RekeyOption that decrypts and re-encrypts every
+// record with the new encryptor, but does not write the result. This is
+// useful to check that every record in the storage chest can be rekeyed.
+func RekeyDryRun() RekeyOption {
+	return newRekeyFuncOption(func(o *RekeyOptions) {
+		o.dryRun = true
+	})
+}
+
+// WithRekeyProgress returns a RekeyOption that calls fn after each record is processed.
+func WithRekeyProgress(fn RekeyProgressFunc) RekeyOption {
+	return newRekeyFuncOption(func(o *RekeyOptions) {
+		o.progress = fn
+	})
+}
+
+// rekeyCheckpoint records the progress of an interrupted rekey. All records before
+// Namespace.Key have been rekeyed, and the record at Namespace.Key is being
+// replaced by a ciphertext with the sha256 Digest. ID and Name describe the
+// encryptor the records are rekeyed to, and FromID and FromName the encryptor
+// they are rekeyed from.
+type rekeyCheckpoint struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	FromID    string `json:"from_id"`
+	FromName  string `json:"from_name"`
+	Namespace string `json:"namespace"`
+	Key       []byte `json:"key"`
+	Digest    []byte `json:"digest"`
+}
+
+// to returns true if the records are rekeyed to e.
+func (cp *rekeyCheckpoint) to(e crypto.Encryptor) bool {
+	return cp.ID == e.ID() && cp.Name == e.Name()
+}
+
+// from returns true if the records are rekeyed from e.
+func (cp *rekeyCheckpoint) from(e crypto.Encryptor) bool {
+	return cp.FromID == e.ID() && cp.FromName == e.Name()
+}
+
+// before returns true if the record at name.key was rekeyed before the checkpoint.
+func (cp *rekeyCheckpoint) before(name string, key []byte) bool {
+	if name != cp.Namespace {
+		return name < cp.Namespace
+	}
+	return bytes.Compare(key, cp.Key) < 0
+}
+
+// Rekey decrypts every record in the storage chest with the current encryptor and
+// re-encrypts it with the new encryptor e. Both values stored with Put and structs
+// stored with Save are rekeyed. Only the ciphertext of a sparse struct is re-encrypted,
+// its sparse plaintext is left as is. Writes to the storage chest block until Rekey
+// returns. Reads do not, they try e first and fall back to the current encryptor.
+// Once Rekey starts, the storage chest uses e for all further writes.
+//
+// Each record is replaced individually, and a checkpoint is written before it is
+// replaced. If Rekey fails, the storage chest keeps reading with both encryptors,
+// so calling Rekey again with the same encryptor will resume from the checkpoint. If
+// the process exits before Rekey completes, records before the checkpoint can only be
+// read with e and the rest with the old encryptor. Open finds the checkpoint and fails
+// with ErrRekeyIncomplete unless the other encryptor is set with WithRekeyEncryptor.
+// Opened with both, the storage chest can read every record, and calling Rekey again
+// with e resumes from the checkpoint.
+func (cn *Chestnut) Rekey(e crypto.Encryptor, opt ...RekeyOption) error {
+	if e == nil {
+		err := errors.New("encryptor is required")
+		return cn.logError("rekey", err)
+	}
+	opts := RekeyOptions{}
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	if !opts.dryRun {
+		if err := cn.writable("rekey"); err != nil {
+			return err
+		}
+		cn.writeMu.Lock()
+		defer cn.writeMu.Unlock()
+	}
+	cn.log.Infof("rekey: from %s to %s", cn.encryptor().Name(), e.Name())
+	keyMap, err := cn.store.ListAll()
+	if err != nil {
+		return cn.logError("rekey", err)
+	}
+	delete(keyMap, rekeyNamespace)
+	// stream chunks are sealed with the stream key, which is rekeyed in its manifest
+	delete(keyMap, streamNamespace)
+	// blind index entries are keyed with the index secret
+	delete(keyMap, indexNamespace)
+	names := make([]string, 0, len(keyMap))
+	var total int
+	for name, keys := range keyMap {
+		names = append(names, name)
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+		total += len(keys)
+	}
+	sort.Strings(names)
+	from := cn.encryptor()
+	if from == e {
+		// resume a rekey to e, which the storage chest already writes with
+		cn.cryptMu.RLock()
+		if len(cn.rekeyFrom) > 0 {
+			from = cn.rekeyFrom[0]
+		}
+		cn.cryptMu.RUnlock()
+	}
+	cp := cn.rekeyCheckpoint(e)
+	if opts.dryRun {
+		cn.log.Info("rekey: dry run")
+		cp = nil
+	} else {
+		if cp != nil {
+			cn.log.Infof("rekey: resume from key: %s.%s", cp.Namespace, cp.Key)
+		}
+		cn.startRekey(e)
+	}
+	progress := RekeyProgress{Total: total}
+	for _, name := range names {
+		for _, key := range keyMap[name] {
+			if err = cn.rekeyRecord(e, from, name, key, cp, opts.dryRun); err != nil {
+				return cn.logError("rekey", err)
+			}
+			progress.Namespace = name
+			progress.Key = key
+			progress.Done++
+			if opts.progress != nil {
+				opts.progress(progress)
+			}
+		}
+	}
+	if opts.dryRun {
+		cn.log.Infof("rekey: dry run complete: %d records", progress.Done)
+		return nil
+	}
+	if err = cn.store.Delete(rekeyNamespace, rekeyCheckpointKey); err != nil {
+		return cn.logError("rekey", err)
+	}
+	cn.cryptMu.Lock()
+	cn.rekeyFrom = nil
+	cn.cryptMu.Unlock()
+	cn.log.Infof("rekey: rekeyed %d records with %s", progress.Done, e.Name())
+	return nil
+}
+
+// startRekey switches the storage chest to e, and keeps the current encryptor to read
+// the records that have not been rekeyed yet.
+func (cn *Chestnut) startRekey(e crypto.Encryptor) {
+	cn.cryptMu.Lock()
+	defer cn.cryptMu.Unlock()
+	if cn.opts.encryptor == e {
+		return
+	}
+	cn.rekeyFrom = append([]crypto.Encryptor{cn.opts.encryptor}, cn.rekeyFrom...)
+	cn.opts.encryptor = e
+}
+
+// rekeyRecord rekeys a single record from the encryptor from to e. If the record
+// was already rekeyed according to the checkpoint cp, it is skipped.
+func (cn *Chestnut) rekeyRecord(e, from crypto.Encryptor, name string, key []byte,
+	cp *rekeyCheckpoint, dryRun bool) error {
+	if cp != nil && cp.before(name, key) {
+		cn.log.Debugf("rekey: skip rekeyed key: %s.%s", name, key)
+		return nil
+	}
+	value, err := cn.store.Get(name, key)
+	if err != nil {
+		return err
+	}
+	if cp != nil && name == cp.Namespace && bytes.Equal(key, cp.Key) {
+		digest := sha256.Sum256(value)
+		if bytes.Equal(digest[:], cp.Digest) {
+			cn.log.Debugf("rekey: skip rekeyed key: %s.%s", name, key)
+			return nil
+		}
+	}
+	// records with a ttl keep their expiry time
+	value, expiry := decodeExpiry(value)
+	rekeyed, err := cn.recrypt(value, e)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	digest := sha256.Sum256(encodeExpiry(rekeyed, expiry))
+	next := &rekeyCheckpoint{
+		ID:        e.ID(),
+		Name:      e.Name(),
+		FromID:    from.ID(),
+		FromName:  from.Name(),
+		Namespace: name,
+		Key:       key,
+		Digest:    digest[:],
+	}
+	if err = cn.store.Save(rekeyNamespace, rekeyCheckpointKey, next); err != nil {
+		return err
+	}
+	cn.log.Debugf("rekey: put %d rekeyed bytes to key: %s.%s", len(rekeyed), name, key)
+	return cn.putExpiry(cn.store, name, key, rekeyed, expiry)
+}
+
+// rekeyCheckpoint returns the checkpoint of an interrupted rekey to e, or nil
+// if there is none. Checkpoints for another encryptor are ignored.
+func (cn *Chestnut) rekeyCheckpoint(e crypto.Encryptor) *rekeyCheckpoint {
+	if has, _ := cn.store.Has(rekeyNamespace, rekeyCheckpointKey); !has {
+		return nil
+	}
+	cp := &rekeyCheckpoint{}
+	if err := cn.store.Load(rekeyNamespace, rekeyCheckpointKey, cp); err != nil {
+		cn.log.Warnf("rekey: ignoring invalid checkpoint: %s", err)
+		return nil
+	}
+	if !cp.to(e) {
+		cn.log.Warnf("rekey: ignoring checkpoint for %s", cp.Name)
+		return nil
+	}
+	return cp
+}
+
+// openRekey checks the store for the checkpoint of an incomplete rekey when the storage
+// chest is opened. If there is one, the storage chest writes with the encryptor of the
+// checkpoint and reads with the other one too, so every record can be read until the
+// rekey is resumed. If the rekey encryptor is not set, or the encryptors do not match the
+// checkpoint, an error wrapping ErrRekeyIncomplete is returned.
+func (cn *Chestnut) openRekey() error {
+	if has, _ := cn.store.Has(rekeyNamespace, rekeyCheckpointKey); !has {
+		return nil
+	}
+	cp := &rekeyCheckpoint{}
+	if err := cn.store.Load(rekeyNamespace, rekeyCheckpointKey, cp); err != nil {
+		return fmt.Errorf("%w: invalid checkpoint: %s", ErrRekeyIncomplete, err)
+	}
+	e, from := cn.opts.encryptor, cn.opts.rekeyEncryptor
+	if from == nil {
+		return fmt.Errorf("%w: to %s, open with WithRekeyEncryptor to resume", ErrRekeyIncomplete, cp.Name)
+	}
+	if cp.to(from) {
+		e, from = from, e
+	}
+	if !cp.to(e) {
+		return fmt.Errorf("%w: no encryptor for the rekey to %s", ErrRekeyIncomplete, cp.Name)
+	}
+	if cp.FromID != "" && !cp.from(from) {
+		return fmt.Errorf("%w: no encryptor for the rekey from %s", ErrRekeyIncomplete, cp.FromName)
+	}
+	cn.cryptMu.Lock()
+	cn.opts.encryptor = e
+	cn.rekeyFrom = []crypto.Encryptor{from}
+	cn.cryptMu.Unlock()
+	cn.log.Warnf("rekey: incomplete rekey to %s found, call Rekey with it to resume", e.Name())
+	return nil
+}
+
+// recrypt decrypts a stored value with the current encryptor and returns it encrypted
+// with e. The value can be either ciphertext stored by Put, a package stored by Save,
+// or a stream manifest stored by PutStream.
+func (cn *Chestnut) recrypt(value []byte, e crypto.Encryptor) ([]byte, error) {
+	if isStream(value) {
+		m, err := cn.decodeStreamManifest(value)
+		if err != nil {
+			return nil, err
+		}
+		return cn.encodeStreamManifest(m, e)
+	}
+	if pkg, err := packager.DecodePackage(value); err == nil {
+		plaintext, err := cn.decryptSealed(pkg.Cipher)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := e.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return packager.EncodePackage(pkg.EncoderID, pkg.Token,
+			ciphertext, pkg.Encoded, pkg.Compressed)
+	}
+	plaintext, err := cn.decryptSealed(value)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encrypt(plaintext)
+}
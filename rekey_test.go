@@ -0,0 +1,184 @@
+package chestnut
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+)
+
+var rekeySecret = crypto.TextSecret("i-am-a-new-secret")
+
+// failingStore fails all calls to Put after the first n calls.
+type failingStore struct {
+	storage.Storage
+	n int
+}
+
+func (s *failingStore) Put(name string, key []byte, value []byte) error {
+	if s.n <= 0 {
+		return errors.New("put failed")
+	}
+	s.n--
+	return s.Storage.Put(name, key, value)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Rekey() {
+	ts.TestChestnut_Save()
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	var progress []RekeyProgress
+	err := ts.cn.Rekey(newEncryptor, WithRekeyProgress(func(p RekeyProgress) {
+		progress = append(progress, p)
+	}))
+	ts.NoError(err)
+	ts.NotEmpty(progress)
+	last := progress[len(progress)-1]
+	ts.Equal(last.Total, last.Done)
+	ts.Equal(newEncryptor.Name(), ts.cn.opts.encryptor.Name())
+	ts.assertReadable(ts.cn)
+	has, _ := ts.cn.store.Has(rekeyNamespace, rekeyCheckpointKey)
+	ts.False(has)
+	old := NewChestnut(ts.cn.store, encryptorOpt)
+	_, err = old.Get(testName, []byte("b"))
+	ts.Error(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_RekeyDryRun() {
+	ts.TestChestnut_Save()
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	err := ts.cn.Rekey(newEncryptor, RekeyDryRun())
+	ts.NoError(err)
+	ts.NotEqual(newEncryptor.Name(), ts.cn.opts.encryptor.Name())
+	ts.assertReadable(ts.cn)
+	err = ts.cn.Rekey(nil)
+	ts.Error(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_RekeyResume() {
+	ts.TestChestnut_Save()
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	// fail part way through the rekey
+	store := &failingStore{Storage: ts.cn.store, n: 5}
+	cn := NewChestnut(store, encryptorOpt)
+	err := cn.Rekey(newEncryptor)
+	ts.Error(err)
+	has, _ := ts.cn.store.Has(rekeyNamespace, rekeyCheckpointKey)
+	ts.True(has)
+	// the failed rekey can still read every record
+	ts.assertReadable(cn)
+	// a checkpoint for a different encryptor is ignored
+	other := encryptor.NewAESEncryptor(crypto.Key128, aes.GCM, rekeySecret)
+	ts.Nil(ts.cn.rekeyCheckpoint(other))
+	// resume
+	err = ts.cn.Rekey(newEncryptor)
+	ts.NoError(err)
+	ts.assertReadable(ts.cn)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_RekeyCrash() {
+	ts.TestChestnut_Save()
+	oldEncryptor := ts.cn.opts.encryptor
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	// fail part way through the rekey, and close the store as if the process exited
+	store := &failingStore{Storage: ts.cn.store, n: 5}
+	err := NewChestnut(store, encryptorOpt).Rekey(newEncryptor)
+	ts.Require().Error(err)
+	err = ts.cn.Close()
+	ts.Require().NoError(err)
+	// the rekey encryptor is required
+	cn := NewChestnut(ts.cn.store, encryptorOpt)
+	err = cn.Open()
+	ts.ErrorIs(err, ErrRekeyIncomplete)
+	// the encryptors must match the checkpoint
+	other := encryptor.NewAESEncryptor(crypto.Key128, aes.GCM, rekeySecret)
+	cn = NewChestnut(ts.cn.store, encryptorOpt, WithRekeyEncryptor(other))
+	err = cn.Open()
+	ts.ErrorIs(err, ErrRekeyIncomplete)
+	cn = NewChestnut(ts.cn.store, WithEncryptor(newEncryptor), WithRekeyEncryptor(other))
+	err = cn.Open()
+	ts.ErrorIs(err, ErrRekeyIncomplete)
+	// every record can be read with the encryptors in either order
+	opts := [][]ChestOption{
+		{WithEncryptor(newEncryptor), WithRekeyEncryptor(oldEncryptor)},
+		{WithEncryptor(oldEncryptor), WithRekeyEncryptor(newEncryptor)},
+	}
+	for _, opt := range opts {
+		cn = NewChestnut(ts.cn.store, opt...)
+		err = cn.Open()
+		ts.Require().NoError(err)
+		ts.assertReadable(cn)
+		err = cn.Close()
+		ts.Require().NoError(err)
+	}
+	// resume
+	ts.cn = NewChestnut(ts.cn.store, WithEncryptor(oldEncryptor), WithRekeyEncryptor(newEncryptor))
+	err = ts.cn.Open()
+	ts.Require().NoError(err)
+	err = ts.cn.Rekey(newEncryptor)
+	ts.NoError(err)
+	has, _ := ts.cn.store.Has(rekeyNamespace, rekeyCheckpointKey)
+	ts.False(has)
+	ts.assertReadable(NewChestnut(ts.cn.store, WithEncryptor(newEncryptor)))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_RekeyWrites() {
+	ts.TestChestnut_Save()
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	key := []byte("rekey-write")
+	done := make(chan error, 1)
+	err := ts.cn.Rekey(newEncryptor, WithRekeyProgress(func(p RekeyProgress) {
+		if p.Done != 1 {
+			return
+		}
+		go func() {
+			done <- ts.cn.Put(testName, key, []byte(testValue))
+		}()
+		// writes are blocked until the rekey completes
+		select {
+		case err := <-done:
+			ts.Fail("put did not block")
+			done <- err
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	ts.Require().NoError(err)
+	ts.NoError(<-done)
+	// the write was encrypted with the new encryptor
+	cn := NewChestnut(ts.cn.store, WithEncryptor(newEncryptor))
+	value, err := cn.Get(testName, key)
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	ts.assertReadable(cn)
+}
+
+// assertReadable asserts the records written by TestChestnut_Put
+// and TestChestnut_Save can be read by cn.
+func (ts *ChestnutTestSuite) assertReadable(cn *Chestnut) {
+	saved := map[string]bool{}
+	for _, test := range objTests {
+		saved[test.key] = test.src != nil
+	}
+	for i, test := range putTests {
+		if test.value == "" || saved[test.key] {
+			continue
+		}
+		value, err := cn.Get(testName, []byte(test.key))
+		ts.NoError(err, "%d test key: %s", i, test.key)
+		ts.Equal(test.value, string(value), "%d test key: %s", i, test.key)
+	}
+	keys, err := cn.List(testName)
+	ts.NoError(err)
+	for _, key := range keys {
+		for _, test := range objTests {
+			if test.dst == nil || test.key != string(key) {
+				continue
+			}
+			err = cn.Load(testName, key, test.dst)
+			ts.NoError(err, "test key: %s", test.key)
+			ts.Equal(test.out, test.dst)
+		}
+	}
+}
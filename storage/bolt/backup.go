@@ -0,0 +1,84 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jrapoport/chestnut/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ storage.BackupReader = (*boltStore)(nil)
+
+// Import reads the backup at path saved by Export and puts its keys and
+// values in the store. The backup is imported inside of a single transaction.
+func (s *boltStore) Import(path string) error {
+	s.log.Debugf("import: from path: %s", path)
+	if s.opts.ReadOnly() {
+		return s.logError("import", storage.ErrReadOnly)
+	}
+	var n int
+	importBackup := func(tx *bolt.Tx) error {
+		t := s.newTx(tx)
+		n = 0
+		return s.ReadBackup(path, func(name string, key []byte, value []byte) error {
+			n++
+			return t.Put(name, key, value)
+		})
+	}
+	if err := s.db.Update(importBackup); err != nil {
+		return s.logError("import", err)
+	}
+	s.log.Debugf("import: imported %d keys from path: %s", n, path)
+	return nil
+}
+
+// ReadBackup calls fn for each namespace, key, and value in the backup at path.
+func (s *boltStore) ReadBackup(path string, fn storage.BackupFunc) error {
+	s.log.Debugf("read backup: from path: %s", path)
+	path, err := backupPath(path)
+	if err != nil {
+		return s.logError("read backup", err)
+	}
+	if s.db != nil && s.db.Path() == path {
+		err = fmt.Errorf("path cannot be store path: %s", path)
+		return s.logError("read backup", err)
+	}
+	opts := &bolt.Options{ReadOnly: true, Timeout: time.Second}
+	db, err := bolt.Open(path, 0600, opts)
+	if err != nil {
+		return s.logError("read backup", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	readBackup := func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return b.ForEach(func(k, v []byte) error {
+				return fn(string(name), k, v)
+			})
+		})
+	}
+	return s.logError("read backup", db.View(readBackup))
+}
+
+// backupPath returns the path of the backup file saved by Export to path.
+func backupPath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path not found")
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		// if we have a directory, then append our default name
+		path = filepath.Join(path, storeName)
+	}
+	if filepath.Ext(path) == "" {
+		path += storeExt
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
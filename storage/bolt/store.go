@@ -48,12 +48,19 @@ func (s *boltStore) Options() storage.StoreOptions {
 func (s *boltStore) Open() (err error) {
 	s.log.Debugf("opening store at path: %s", s.path)
 	var path string
-	path, err = ensureDBPath(s.path)
+	var opts *bolt.Options
+	if s.opts.ReadOnly() {
+		// a read-only db is opened with a shared lock and must already exist
+		path, err = dbPath(s.path)
+		opts = &bolt.Options{ReadOnly: true}
+	} else {
+		path, err = ensureDBPath(s.path)
+	}
 	if err != nil {
 		err = s.logError("open", err)
 		return
 	}
-	s.db, err = bolt.Open(path, 0600, nil)
+	s.db, err = bolt.Open(path, 0600, opts)
 	if err != nil {
 		err = s.logError("open", err)
 		return
@@ -75,15 +82,11 @@ func (s *boltStore) Put(name string, key []byte, value []byte) error {
 	} else if len(value) <= 0 {
 		err = errors.New("value cannot be empty")
 		return s.logError("put", err)
+	} else if s.opts.ReadOnly() {
+		return s.logError("put", storage.ErrReadOnly)
 	}
 	putValue := func(tx *bolt.Tx) error {
-		s.log.Debugf("put: tx %d bytes to key: %s.%s",
-			len(value), name, string(key))
-		b, err := tx.CreateBucketIfNotExists([]byte(name))
-		if err != nil {
-			return err
-		}
-		return b.Put(key, value)
+		return s.newTx(tx).Put(name, key, value)
 	}
 	return s.logError("put", s.db.Update(putValue))
 }
@@ -95,20 +98,9 @@ func (s *boltStore) Get(name string, key []byte) ([]byte, error) {
 		return nil, s.logError("get", err)
 	}
 	var value []byte
-	getValue := func(tx *bolt.Tx) error {
-		s.log.Debugf("get: tx key: %s.%s", name, key)
-		b := tx.Bucket([]byte(name))
-		if b == nil {
-			return fmt.Errorf("bucket not found: %s", name)
-		}
-		v := b.Get(key)
-		if len(v) <= 0 {
-			return errors.New("nil value")
-		}
-		value = v
-		s.log.Debugf("get: tx key: %s.%s value (%d bytes)",
-			name, string(key), len(value))
-		return nil
+	getValue := func(tx *bolt.Tx) (err error) {
+		value, err = s.newTx(tx).Get(name, key)
+		return err
 	}
 	if err := s.db.View(getValue); err != nil {
 		return nil, s.logError("get", err)
@@ -141,19 +133,9 @@ func (s *boltStore) Has(name string, key []byte) (bool, error) {
 		return false, s.logError("has", err)
 	}
 	var has bool
-	hasKey := func(tx *bolt.Tx) error {
-		s.log.Debugf("has: tx get namespace: %s", name)
-		b := tx.Bucket([]byte(name))
-		if b == nil {
-			err := fmt.Errorf("bucket not found: %s", name)
-			return err
-		}
-		v := b.Get(key)
-		has = len(v) > 0
-		if has {
-			s.log.Debugf("has: tx key found: %s.%s", name, string(key))
-		}
-		return nil
+	hasKey := func(tx *bolt.Tx) (err error) {
+		has, err = s.newTx(tx).Has(name, key)
+		return err
 	}
 	if err := s.db.View(hasKey); err != nil {
 		return false, s.logError("has", err)
@@ -167,17 +149,11 @@ func (s *boltStore) Delete(name string, key []byte) error {
 	s.log.Debugf("delete: key: %s", key)
 	if err := storage.ValidKey(name, key); err != nil {
 		return s.logError("delete", err)
+	} else if s.opts.ReadOnly() {
+		return s.logError("delete", storage.ErrReadOnly)
 	}
 	del := func(tx *bolt.Tx) error {
-		s.log.Debugf("delete: tx key: %s.%s", name, string(key))
-		b := tx.Bucket([]byte(name))
-		if b == nil {
-			err := fmt.Errorf("bucket not found: %s", name)
-			// an error just means we couldn't find the bucket
-			s.log.Warn(err)
-			return nil
-		}
-		return b.Delete(key)
+		return s.newTx(tx).Delete(name, key)
 	}
 	return s.logError("delete", s.db.Update(del))
 }
@@ -186,12 +162,7 @@ func (s *boltStore) Delete(name string, key []byte) error {
 func (s *boltStore) List(name string) (keys [][]byte, err error) {
 	s.log.Debugf("list: keys in namespace: %s", name)
 	listKeys := func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(name))
-		if b == nil {
-			err = fmt.Errorf("bucket not found: %s", name)
-			return err
-		}
-		keys, err = s.listKeys(name, b)
+		keys, err = s.newTx(tx).List(name)
 		return err
 	}
 	if err = s.db.View(listKeys); err != nil {
@@ -201,26 +172,6 @@ func (s *boltStore) List(name string) (keys [][]byte, err error) {
 	return
 }
 
-func (s *boltStore) listKeys(name string, b *bolt.Bucket) ([][]byte, error) {
-	if b == nil {
-		err := fmt.Errorf("invalid bucket: %s", name)
-		return nil, err
-	}
-	var keys [][]byte
-	s.log.Debugf("list: tx scan namespace: %s", name)
-	count := b.Stats().KeyN
-	keys = make([][]byte, count)
-	s.log.Debugf("list: tx found %d keys in: %s", count, name)
-	var i int
-	_ = b.ForEach(func(k, _ []byte) error {
-		s.log.Debugf("list: tx found key: %s.%s", name, string(k))
-		keys[i] = k
-		i++
-		return nil
-	})
-	return keys, nil
-}
-
 // ListAll returns a mapped list of all keys in the store.
 func (s *boltStore) ListAll() (map[string][][]byte, error) {
 	s.log.Debugf("list: all keys")
@@ -228,7 +179,7 @@ func (s *boltStore) ListAll() (map[string][][]byte, error) {
 	allKeys := map[string][][]byte{}
 	listKeys := func(tx *bolt.Tx) error {
 		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			keys, err := s.listKeys(string(name), b)
+			keys, err := s.newTx(tx).listKeys(string(name), b)
 			if err != nil {
 				return err
 			}
@@ -294,31 +245,17 @@ func (s *boltStore) logError(name string, err error) error {
 }
 
 func ensureDBPath(path string) (string, error) {
-	if path == "" {
-		return "", errors.New("path not found")
-	}
-	// does the path exist?
-	info, err := os.Stat(path)
-	exists := !os.IsNotExist(err)
-	// this is some kind of actual error
-	if err != nil && exists {
+	path, err := dbPath(path)
+	if err != nil {
 		return "", err
 	}
-	if exists && info.Mode().IsDir() {
-		// if we have a directory, then append our default name
-		path = filepath.Join(path, storeName)
-	}
-	ext := filepath.Ext(path)
-	if ext == "" {
-		path += storeExt
-	}
 	dir, _ := filepath.Split(path)
 	// make sure the directory path exists
 	if err = os.MkdirAll(dir, 0700); err != nil {
 		return "", err
 	}
 	_, err = os.Stat(path)
-	exists = !os.IsNotExist(err)
+	exists := !os.IsNotExist(err)
 	// this is some kind of actual error
 	if err != nil && exists {
 		return "", err
@@ -333,3 +270,26 @@ func ensureDBPath(path string) (string, error) {
 	defer f.Close()
 	return path, nil
 }
+
+// dbPath returns the path of the db file at path without creating it.
+func dbPath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path not found")
+	}
+	// does the path exist?
+	info, err := os.Stat(path)
+	exists := !os.IsNotExist(err)
+	// this is some kind of actual error
+	if err != nil && exists {
+		return "", err
+	}
+	if exists && info.Mode().IsDir() {
+		// if we have a directory, then append our default name
+		path = filepath.Join(path, storeName)
+	}
+	ext := filepath.Ext(path)
+	if ext == "" {
+		path += storeExt
+	}
+	return path, nil
+}
@@ -1,11 +1,39 @@
 package bolt
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/jrapoport/chestnut/storage/store_test"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestStore(t *testing.T) {
 	store_test.TestStore(t, NewStore)
 }
+
+func TestStore_Values(t *testing.T) {
+	const (
+		name  = "test-name"
+		key   = "test-key"
+		value = "test-value"
+	)
+	store := NewStore(t.TempDir())
+	err := store.Open()
+	assert.NoError(t, err)
+	// enough values that the bucket is not inlined
+	for i := 0; i < 100; i++ {
+		err = store.Put(name, []byte(fmt.Sprintf("%s-%03d", key, i)), []byte(value))
+		assert.NoError(t, err)
+	}
+	v, err := store.Get(name, []byte(key+"-000"))
+	assert.NoError(t, err)
+	keys, err := store.List(name)
+	assert.NoError(t, err)
+	err = store.Close()
+	assert.NoError(t, err)
+	// values and keys are still valid after the database is closed
+	assert.Equal(t, value, string(v))
+	assert.Len(t, keys, 100)
+	assert.Equal(t, key+"-000", string(keys[0]))
+}
@@ -0,0 +1,223 @@
+package bolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/jrapoport/chestnut/log"
+	"github.com/jrapoport/chestnut/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltTx is an implementation the storage Tx interface for a bbolt transaction.
+type boltTx struct {
+	tx  *bolt.Tx
+	log log.Logger
+}
+
+var _ storage.Tx = (*boltTx)(nil)
+
+var _ storage.Iterable = (*boltTx)(nil)
+
+var _ storage.Transactional = (*boltStore)(nil)
+
+var _ storage.Iterable = (*boltStore)(nil)
+
+func (s *boltStore) newTx(tx *bolt.Tx) *boltTx {
+	return &boltTx{tx: tx, log: s.log}
+}
+
+// Update executes fn inside of a read-write bbolt transaction.
+func (s *boltStore) Update(fn func(tx storage.Tx) error) error {
+	s.log.Debug("update: begin tx")
+	if s.opts.ReadOnly() {
+		return s.logError("update", storage.ErrReadOnly)
+	}
+	update := func(tx *bolt.Tx) error {
+		return fn(s.newTx(tx))
+	}
+	if err := s.db.Update(update); err != nil {
+		return s.logError("update", err)
+	}
+	s.log.Debug("update: tx committed")
+	return nil
+}
+
+// View executes fn inside of a read-only bbolt transaction.
+func (s *boltStore) View(fn func(tx storage.Tx) error) error {
+	s.log.Debug("view: begin tx")
+	view := func(tx *bolt.Tx) error {
+		return fn(s.newTx(tx))
+	}
+	return s.logError("view", s.db.View(view))
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (s *boltStore) ForEach(name string, fn storage.IterateFunc) error {
+	s.log.Debugf("for each: namespace: %s", name)
+	forEach := func(tx *bolt.Tx) error {
+		return s.newTx(tx).ForEach(name, fn)
+	}
+	return s.logError("for each", s.db.View(forEach))
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (s *boltStore) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	s.log.Debugf("scan: namespace: %s prefix: %s", name, prefix)
+	scan := func(tx *bolt.Tx) error {
+		return s.newTx(tx).Scan(name, prefix, fn)
+	}
+	return s.logError("scan", s.db.View(scan))
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (s *boltStore) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	s.log.Debugf("range: namespace: %s from: %s to: %s", name, start, end)
+	rangeScan := func(tx *bolt.Tx) error {
+		return s.newTx(tx).Range(name, start, end, fn)
+	}
+	return s.logError("range", s.db.View(rangeScan))
+}
+
+// Put an entry in the transaction.
+func (t *boltTx) Put(name string, key []byte, value []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	} else if len(value) <= 0 {
+		return errors.New("value cannot be empty")
+	}
+	t.log.Debugf("put: tx %d bytes to key: %s.%s",
+		len(value), name, string(key))
+	b, err := t.tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+// Get a value from the transaction.
+func (t *boltTx) Get(name string, key []byte) ([]byte, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return nil, err
+	}
+	t.log.Debugf("get: tx key: %s.%s", name, key)
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, fmt.Errorf("bucket not found: %s", name)
+	}
+	v := b.Get(key)
+	if len(v) <= 0 {
+		return nil, errors.New("nil value")
+	}
+	// values are only valid for the life of the transaction
+	value := make([]byte, len(v))
+	copy(value, v)
+	t.log.Debugf("get: tx key: %s.%s value (%d bytes)",
+		name, string(key), len(value))
+	return value, nil
+}
+
+// Has checks for a key in the transaction.
+func (t *boltTx) Has(name string, key []byte) (bool, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return false, err
+	}
+	t.log.Debugf("has: tx get namespace: %s", name)
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return false, fmt.Errorf("bucket not found: %s", name)
+	}
+	has := len(b.Get(key)) > 0
+	if has {
+		t.log.Debugf("has: tx key found: %s.%s", name, string(key))
+	}
+	return has, nil
+}
+
+// Delete removes a key from the transaction.
+func (t *boltTx) Delete(name string, key []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	}
+	t.log.Debugf("delete: tx key: %s.%s", name, string(key))
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		err := fmt.Errorf("bucket not found: %s", name)
+		// an error just means we couldn't find the bucket
+		t.log.Warn(err)
+		return nil
+	}
+	return b.Delete(key)
+}
+
+// List returns a list of all keys in the namespace.
+func (t *boltTx) List(name string) ([][]byte, error) {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, fmt.Errorf("bucket not found: %s", name)
+	}
+	return t.listKeys(name, b)
+}
+
+func (t *boltTx) listKeys(name string, b *bolt.Bucket) ([][]byte, error) {
+	if b == nil {
+		err := fmt.Errorf("invalid bucket: %s", name)
+		return nil, err
+	}
+	t.log.Debugf("list: tx scan namespace: %s", name)
+	count := b.Stats().KeyN
+	keys := make([][]byte, 0, count)
+	t.log.Debugf("list: tx found %d keys in: %s", count, name)
+	_ = b.ForEach(func(k, _ []byte) error {
+		t.log.Debugf("list: tx found key: %s.%s", name, string(k))
+		// keys are only valid for the life of the transaction
+		key := make([]byte, len(k))
+		copy(key, k)
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, nil
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (t *boltTx) ForEach(name string, fn storage.IterateFunc) error {
+	t.log.Debugf("for each: tx namespace: %s", name)
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return fmt.Errorf("bucket not found: %s", name)
+	}
+	return b.ForEach(fn)
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (t *boltTx) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	t.log.Debugf("scan: tx namespace: %s prefix: %s", name, prefix)
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return fmt.Errorf("bucket not found: %s", name)
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (t *boltTx) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	t.log.Debugf("range: tx namespace: %s from: %s to: %s", name, start, end)
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return fmt.Errorf("bucket not found: %s", name)
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// migrateNamespace is the namespace in the destination store used
+// to store the checkpoints of a migration until it is verified.
+const migrateNamespace = "__chestnut_migrate"
+
+// TransformFunc is the prototype for the migration transform hook. It is called
+// with each value before it is copied, and returns the value to put in the
+// destination store. If the destination store retries a transaction, it may
+// be called more than once for the same key.
+type TransformFunc func(namespace string, key []byte, value []byte) ([]byte, error)
+
+// MigrateResult describes a completed migration.
+type MigrateResult struct {
+	// Namespaces is the number of namespaces migrated.
+	Namespaces int
+	// Keys is the total number of keys migrated.
+	Keys int
+	// Copied is the number of keys copied.
+	Copied int
+	// Skipped is the number of keys skipped because they were
+	// already copied by an interrupted migration.
+	Skipped int
+}
+
+// MigrateOptions provides the options for a call to Migrate.
+type MigrateOptions struct {
+	// transform is called with each value before it is copied.
+	transform TransformFunc
+}
+
+// A MigrateOption sets options such as the transform hook.
+type MigrateOption interface {
+	apply(*MigrateOptions)
+}
+
+// migrateFuncOption wraps a function that modifies MigrateOptions
+// into an implementation of the MigrateOption interface.
+type migrateFuncOption struct {
+	f func(*MigrateOptions)
+}
+
+// apply applies a MigrateOption to MigrateOptions.
+func (fdo *migrateFuncOption) apply(do *MigrateOptions) {
+	fdo.f(do)
+}
+
+func newMigrateFuncOption(f func(*MigrateOptions)) *migrateFuncOption {
+	return &migrateFuncOption{
+		f: f,
+	}
+}
+
+// WithTransform returns a MigrateOption that calls fn with each value before it is
+// copied, and puts the value it returns in the destination store. If a transform
+// is set, the values in the destination store are not compared to the source when
+// the migration is verified.
+func WithTransform(fn TransformFunc) MigrateOption {
+	return newMigrateFuncOption(func(o *MigrateOptions) {
+		o.transform = fn
+	})
+}
+
+// Migrate copies every namespace, key, and value in src to dst. Both stores must be
+// open. If dst supports transactions, each namespace is copied inside of a transaction.
+//
+// A checkpoint with the checksum of the source value is put in dst with each key. If
+// Migrate is interrupted, calling it again resumes the migration, and the keys that
+// were already copied are skipped unless their source value has changed. When the
+// copy completes, the number of keys in each namespace and the checksum of each value
+// is verified against src, and the checkpoints are deleted.
+func Migrate(src Storage, dst Storage, opt ...MigrateOption) (MigrateResult, error) {
+	opts := MigrateOptions{}
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	var res MigrateResult
+	if src == nil || dst == nil {
+		return res, errors.New("migrate: store required")
+	} else if src == dst {
+		return res, errors.New("migrate: source and destination must be different")
+	}
+	keyMap, err := src.ListAll()
+	if err != nil {
+		return res, fmt.Errorf("migrate: %w", err)
+	}
+	delete(keyMap, migrateNamespace)
+	names := make([]string, 0, len(keyMap))
+	for name, keys := range keyMap {
+		names = append(names, name)
+		res.Keys += len(keys)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var copied, skipped int
+		migrate := func(tx Tx) error {
+			copied, skipped = 0, 0
+			for _, key := range keyMap[name] {
+				value, err := src.Get(name, key)
+				if err != nil {
+					return err
+				}
+				ok, err := migrateKey(tx, name, key, value, opts.transform)
+				if err != nil {
+					return err
+				}
+				if ok {
+					copied++
+				} else {
+					skipped++
+				}
+			}
+			return nil
+		}
+		if store, ok := dst.(Transactional); ok {
+			err = store.Update(migrate)
+		} else {
+			err = migrate(dst)
+		}
+		if err != nil {
+			return res, fmt.Errorf("migrate: namespace %s: %w", name, err)
+		}
+		res.Namespaces++
+		res.Copied += copied
+		res.Skipped += skipped
+	}
+	if err = verifyMigration(src, dst, keyMap, opts.transform == nil); err != nil {
+		return res, fmt.Errorf("migrate: verify: %w", err)
+	}
+	if err = deleteCheckpoints(dst); err != nil {
+		return res, fmt.Errorf("migrate: %w", err)
+	}
+	return res, nil
+}
+
+// migrateKey copies the value at key to tx and puts its checkpoint. If the
+// checkpoint shows the value was already copied, the key is skipped and
+// migrateKey returns false.
+func migrateKey(tx Tx, name string, key []byte, value []byte, transform TransformFunc) (bool, error) {
+	// values and keys may be only valid for the life of the transaction
+	key = append([]byte{}, key...)
+	value = append([]byte{}, value...)
+	sum := sha256.Sum256(value)
+	cp := checkpointKey(name, key)
+	if prev, err := tx.Get(migrateNamespace, cp); err == nil && bytes.Equal(prev, sum[:]) {
+		if has, _ := tx.Has(name, key); has {
+			return false, nil
+		}
+	}
+	if transform != nil {
+		var err error
+		if value, err = transform(name, key, value); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Put(name, key, value); err != nil {
+		return false, err
+	}
+	if err := tx.Put(migrateNamespace, cp, sum[:]); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyMigration checks that every key in the key map was copied to dst, and
+// that the checksum of each value in src matches its checkpoint. If compare is
+// true, the value in dst must also match the value in src.
+func verifyMigration(src Storage, dst Storage, keyMap map[string][][]byte, compare bool) error {
+	for name, keys := range keyMap {
+		dstKeys, err := dst.List(name)
+		if err != nil && len(keys) > 0 {
+			return fmt.Errorf("namespace %s: %w", name, err)
+		}
+		found := make(map[string]bool, len(dstKeys))
+		for _, key := range dstKeys {
+			found[string(key)] = true
+		}
+		var n int
+		for _, key := range keys {
+			if found[string(key)] {
+				n++
+			}
+		}
+		if n != len(keys) {
+			return fmt.Errorf("namespace %s: expected %d keys, found %d", name, len(keys), n)
+		}
+		for _, key := range keys {
+			value, err := src.Get(name, key)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(value)
+			cp, err := dst.Get(migrateNamespace, checkpointKey(name, key))
+			if err != nil || !bytes.Equal(cp, sum[:]) {
+				return fmt.Errorf("key %s.%s: source checksum mismatch", name, key)
+			}
+			if !compare {
+				continue
+			}
+			value, err = dst.Get(name, key)
+			if err != nil {
+				return err
+			}
+			if dstSum := sha256.Sum256(value); dstSum != sum {
+				return fmt.Errorf("key %s.%s: checksum mismatch", name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteCheckpoints deletes the migration checkpoints from dst.
+func deleteCheckpoints(dst Storage) error {
+	keys, err := dst.List(migrateNamespace)
+	if err != nil || len(keys) <= 0 {
+		// there are no checkpoints
+		return nil
+	}
+	deleteKeys := func(tx Tx) error {
+		for _, key := range keys {
+			if err = tx.Delete(migrateNamespace, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if store, ok := dst.(Transactional); ok {
+		return store.Update(deleteKeys)
+	}
+	return deleteKeys(dst)
+}
+
+// checkpointKey returns the key of the checkpoint for name.key.
+func checkpointKey(name string, key []byte) []byte {
+	cp := binary.AppendUvarint(nil, uint64(len(name)))
+	cp = append(cp, name...)
+	return append(cp, key...)
+}
@@ -0,0 +1,135 @@
+package nuts
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/jrapoport/chestnut/storage"
+	"github.com/nutsdb/nutsdb"
+)
+
+var _ storage.BackupReader = (*nutsDBStore)(nil)
+
+// Import reads the backup at path saved by Export and puts its keys and
+// values in the store. The backup is imported inside of a single transaction.
+func (s *nutsDBStore) Import(path string) error {
+	s.log.Debugf("import: from path: %s", path)
+	if s.opts.ReadOnly() {
+		return s.logError("import", storage.ErrReadOnly)
+	}
+	var n int
+	importBackup := func(tx storage.Tx) error {
+		n = 0
+		return s.ReadBackup(path, func(name string, key []byte, value []byte) error {
+			n++
+			// the backup is closed before the transaction is committed
+			return tx.Put(name, copyBytes(key), copyBytes(value))
+		})
+	}
+	if err := s.Update(importBackup); err != nil {
+		return s.logError("import", err)
+	}
+	s.log.Debugf("import: imported %d keys from path: %s", n, path)
+	return nil
+}
+
+// ReadBackup calls fn for each namespace, key, and value in the backup at path.
+// nutsdb writes to the directory it opens, so the backup is read from a copy.
+func (s *nutsDBStore) ReadBackup(path string, fn storage.BackupFunc) error {
+	s.log.Debugf("read backup: from path: %s", path)
+	if path == "" {
+		err := fmt.Errorf("invalid path: %s", path)
+		return s.logError("read backup", err)
+	} else if s.path == path {
+		err := fmt.Errorf("path cannot be store path: %s", path)
+		return s.logError("read backup", err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		return s.logError("read backup", err)
+	} else if !info.IsDir() {
+		err = fmt.Errorf("invalid backup: %s", path)
+		return s.logError("read backup", err)
+	}
+	// nutsdb would open an empty directory as an empty store
+	if files, _ := filepath.Glob(filepath.Join(path, "*"+nutsdb.DataSuffix)); len(files) <= 0 {
+		err := fmt.Errorf("invalid backup: %s", path)
+		return s.logError("read backup", err)
+	}
+	tmp, err := os.MkdirTemp("", "chestnut-backup-")
+	if err != nil {
+		return s.logError("read backup", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmp)
+	}()
+	if err = copyDir(path, tmp); err != nil {
+		return s.logError("read backup", err)
+	}
+	opt := nutsdb.DefaultOptions
+	opt.Dir = tmp
+	db, err := nutsdb.Open(opt)
+	if err != nil {
+		return s.logError("read backup", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	readBackup := func(tx *nutsdb.Tx) error {
+		buckets, err := listBuckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
+			keys, values, err := tx.GetAll(bucket)
+			if err != nil {
+				// the bucket is empty
+				continue
+			}
+			for i := range keys {
+				if err = fn(bucket, keys[i], values[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return s.logError("read backup", db.View(readBackup))
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// copyDir copies the files in the directory src to dst.
+func copyDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(out, in); err != nil {
+			_ = out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/jrapoport/chestnut/log"
 	"github.com/jrapoport/chestnut/storage"
@@ -24,6 +26,8 @@ type nutsDBStore struct {
 
 var _ storage.Storage = (*nutsDBStore)(nil)
 
+var _ storage.Expiring = (*nutsDBStore)(nil)
+
 // NewStore is used to instantiate a datastore backed by nutsdb.
 func NewStore(path string, opt ...storage.StoreOption) storage.Storage {
 	opts := storage.ApplyOptions(storage.DefaultStoreOptions, opt...)
@@ -42,6 +46,15 @@ func (s *nutsDBStore) Options() storage.StoreOptions {
 // Open opens the store.
 func (s *nutsDBStore) Open() (err error) {
 	s.log.Debugf("opening store at path: %s", s.path)
+	if s.opts.ReadOnly() {
+		// nutsdb cannot be opened read-only, so the store must already exist
+		// and writes are rejected by the store instead. nutsdb still takes the
+		// exclusive directory lock, so the store cannot be shared with a writer.
+		if _, err = os.Stat(s.path); err != nil {
+			err = s.logError("open", err)
+			return
+		}
+	}
 	opt := nutsdb.DefaultOptions
 	opt.Dir = s.path
 	if s.db, err = nutsdb.Open(opt); err != nil {
@@ -59,12 +72,23 @@ func (s *nutsDBStore) Open() (err error) {
 
 // Put an entry in the store.
 func (s *nutsDBStore) Put(name string, key []byte, value []byte) error {
+	return s.put(name, key, value, nutsdb.Persistent)
+}
+
+// PutTTL puts an entry in the store that expires after the ttl.
+func (s *nutsDBStore) PutTTL(name string, key []byte, value []byte, ttl time.Duration) error {
+	return s.put(name, key, value, ttlSeconds(ttl))
+}
+
+func (s *nutsDBStore) put(name string, key []byte, value []byte, ttl uint32) error {
 	s.log.Debugf("put: %d value bytes to key: %s", len(value), key)
 	if err := storage.ValidKey(name, key); err != nil {
 		return s.logError("put", err)
 	} else if len(value) <= 0 {
 		err = errors.New("value cannot be empty")
 		return s.logError("put", err)
+	} else if s.opts.ReadOnly() {
+		return s.logError("put", storage.ErrReadOnly)
 	}
 	newBucket := func(tx *nutsdb.Tx) error {
 		e := tx.NewBucket(nutsdb.DataStructureBTree, name)
@@ -79,7 +103,7 @@ func (s *nutsDBStore) Put(name string, key []byte, value []byte) error {
 	putValue := func(tx *nutsdb.Tx) error {
 		s.log.Debugf("put: tx %d bytes to key: %s.%s",
 			len(value), name, string(key))
-		return tx.Put(name, key, value, 0)
+		return tx.Put(name, key, value, ttl)
 	}
 	return s.logError("put", s.db.Update(putValue))
 }
@@ -161,6 +185,8 @@ func (s *nutsDBStore) Delete(name string, key []byte) error {
 	s.log.Debugf("delete: key: %s", key)
 	if err := storage.ValidKey(name, key); err != nil {
 		return s.logError("delete", err)
+	} else if s.opts.ReadOnly() {
+		return s.logError("delete", storage.ErrReadOnly)
 	}
 	del := func(tx *nutsdb.Tx) error {
 		s.log.Debugf("delete: tx key: %s.%s", name, string(key))
@@ -207,19 +233,20 @@ func (s *nutsDBStore) ListAll() (map[string][][]byte, error) {
 	var total int
 	allKeys := map[string][][]byte{}
 	listKeys := func(tx *nutsdb.Tx) error {
-		err := tx.IterateBuckets(nutsdb.DataStructureBTree, "*", func(bucket string) bool {
+		buckets, err := listBuckets(tx)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
 			keys, err := s.listKeys(bucket, tx)
-			if err != nil {
-				return false
-			}
-			if len(keys) <= 0 {
-				return true
+			if err != nil || len(keys) <= 0 {
+				// the bucket is empty
+				continue
 			}
 			allKeys[bucket] = keys
 			total += len(keys)
-			return true
-		})
-		return err
+		}
+		return nil
 	}
 	if err := s.db.View(listKeys); err != nil {
 		return nil, s.logError("list", err)
@@ -254,6 +281,36 @@ func (s *nutsDBStore) Close() error {
 	return s.logError("close", err)
 }
 
+// maxBucketDepth is the most path separators a namespace can have and still be found
+// by listBuckets. nutsdb matches bucket names with filepath.Match, so "*" does not
+// match a name with a separator in it.
+const maxBucketDepth = 32
+
+// listBuckets returns the names of all the buckets.
+func listBuckets(tx *nutsdb.Tx) ([]string, error) {
+	var buckets []string
+	pattern := "*"
+	for depth := 0; depth <= maxBucketDepth; depth++ {
+		err := tx.IterateBuckets(nutsdb.DataStructureBTree, pattern, func(bucket string) bool {
+			buckets = append(buckets, bucket)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		pattern += "/*"
+	}
+	return buckets, nil
+}
+
+// ttlSeconds returns the ttl rounded up to the nearest second.
+func ttlSeconds(ttl time.Duration) uint32 {
+	if ttl <= 0 {
+		return nutsdb.Persistent
+	}
+	return uint32((ttl + time.Second - 1) / time.Second)
+}
+
 func (s *nutsDBStore) logError(name string, err error) error {
 	if err == nil {
 		return nil
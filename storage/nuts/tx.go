@@ -0,0 +1,401 @@
+package nuts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jrapoport/chestnut/log"
+	"github.com/jrapoport/chestnut/storage"
+	"github.com/nutsdb/nutsdb"
+)
+
+// errBucketRequired is returned when a transaction writes to a bucket that does not
+// exist. nutsdb cannot write to a bucket created inside the same transaction.
+var errBucketRequired = errors.New("bucket required")
+
+// nutsTx is an implementation the storage Tx interface for a nutsdb transaction.
+// nutsdb transactions cannot delete a key written by the same transaction, so the
+// writes are buffered and applied to the nutsdb transaction before it is committed.
+type nutsTx struct {
+	tx       *nutsdb.Tx
+	log      log.Logger
+	writable bool
+	writes   map[string]map[string][]byte
+	ttls     map[string]map[string]uint32
+}
+
+var _ storage.Tx = (*nutsTx)(nil)
+
+var _ storage.Expiring = (*nutsTx)(nil)
+
+var _ storage.Iterable = (*nutsTx)(nil)
+
+var _ storage.Transactional = (*nutsDBStore)(nil)
+
+var _ storage.Iterable = (*nutsDBStore)(nil)
+
+func (s *nutsDBStore) newTx(tx *nutsdb.Tx, writable bool) *nutsTx {
+	return &nutsTx{
+		tx:       tx,
+		log:      s.log,
+		writable: writable,
+		writes:   map[string]map[string][]byte{},
+		ttls:     map[string]map[string]uint32{},
+	}
+}
+
+// Update executes fn inside of a read-write nutsdb transaction. If fn writes to a
+// namespace that does not exist, the transaction is rolled back, the namespace is
+// created, and fn is called again.
+func (s *nutsDBStore) Update(fn func(tx storage.Tx) error) error {
+	s.log.Debug("update: begin tx")
+	if s.opts.ReadOnly() {
+		return s.logError("update", storage.ErrReadOnly)
+	}
+	for {
+		var buckets []string
+		update := func(tx *nutsdb.Tx) error {
+			t := s.newTx(tx, true)
+			if err := fn(t); err != nil {
+				return err
+			}
+			if buckets = t.newBuckets(); len(buckets) > 0 {
+				return errBucketRequired
+			}
+			return t.commit()
+		}
+		err := s.db.Update(update)
+		if !errors.Is(err, errBucketRequired) {
+			if err != nil {
+				return s.logError("update", err)
+			}
+			s.log.Debug("update: tx committed")
+			return nil
+		}
+		s.log.Debugf("update: tx requires namespaces: %s", buckets)
+		if err = s.newBuckets(buckets); err != nil {
+			return s.logError("update", err)
+		}
+	}
+}
+
+// View executes fn inside of a read-only nutsdb transaction.
+func (s *nutsDBStore) View(fn func(tx storage.Tx) error) error {
+	s.log.Debug("view: begin tx")
+	view := func(tx *nutsdb.Tx) error {
+		return fn(s.newTx(tx, false))
+	}
+	return s.logError("view", s.db.View(view))
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (s *nutsDBStore) ForEach(name string, fn storage.IterateFunc) error {
+	s.log.Debugf("for each: namespace: %s", name)
+	forEach := func(tx *nutsdb.Tx) error {
+		return s.newTx(tx, false).ForEach(name, fn)
+	}
+	return s.logError("for each", s.db.View(forEach))
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (s *nutsDBStore) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	s.log.Debugf("scan: namespace: %s prefix: %s", name, prefix)
+	scan := func(tx *nutsdb.Tx) error {
+		return s.newTx(tx, false).Scan(name, prefix, fn)
+	}
+	return s.logError("scan", s.db.View(scan))
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (s *nutsDBStore) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	s.log.Debugf("range: namespace: %s from: %s to: %s", name, start, end)
+	rangeScan := func(tx *nutsdb.Tx) error {
+		return s.newTx(tx, false).Range(name, start, end, fn)
+	}
+	return s.logError("range", s.db.View(rangeScan))
+}
+
+// newBuckets creates the buckets if they do not exist.
+func (s *nutsDBStore) newBuckets(names []string) error {
+	newBucket := func(tx *nutsdb.Tx) error {
+		for _, name := range names {
+			e := tx.NewBucket(nutsdb.DataStructureBTree, name)
+			if e != nil && !errors.Is(e, nutsdb.ErrBucketAlreadyExist) {
+				return e
+			}
+		}
+		return nil
+	}
+	return s.db.Update(newBucket)
+}
+
+// Put an entry in the transaction.
+func (t *nutsTx) Put(name string, key []byte, value []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	} else if len(value) <= 0 {
+		return errors.New("value cannot be empty")
+	}
+	t.log.Debugf("put: tx %d bytes to key: %s.%s",
+		len(value), name, string(key))
+	return t.write(name, key, value, nutsdb.Persistent)
+}
+
+// PutTTL puts an entry in the transaction that expires after the ttl.
+func (t *nutsTx) PutTTL(name string, key []byte, value []byte, ttl time.Duration) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	} else if len(value) <= 0 {
+		return errors.New("value cannot be empty")
+	}
+	t.log.Debugf("put: tx %d bytes to key: %s.%s ttl: %s",
+		len(value), name, string(key), ttl)
+	return t.write(name, key, value, ttlSeconds(ttl))
+}
+
+// Get a value from the transaction.
+func (t *nutsTx) Get(name string, key []byte) ([]byte, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return nil, err
+	}
+	t.log.Debugf("get: tx key: %s.%s", name, key)
+	if value, ok := t.writes[name][string(key)]; ok {
+		if value == nil {
+			return nil, nutsdb.ErrKeyNotFound
+		}
+		return value, nil
+	}
+	value, err := t.tx.Get(name, key)
+	if err != nil {
+		return nil, err
+	}
+	t.log.Debugf("get: tx key: %s.%s value (%d bytes)",
+		name, string(key), len(value))
+	return value, nil
+}
+
+// Has checks for a key in the transaction.
+func (t *nutsTx) Has(name string, key []byte) (bool, error) {
+	if err := storage.ValidKey(name, key); err != nil {
+		return false, err
+	}
+	t.log.Debugf("has: tx get namespace: %s", name)
+	if value, ok := t.writes[name][string(key)]; ok {
+		return value != nil, nil
+	}
+	_, err := t.tx.Get(name, key)
+	if errors.Is(err, nutsdb.ErrKeyNotFound) || errors.Is(err, nutsdb.ErrNotFoundKey) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	t.log.Debugf("has: tx key found: %s.%s", name, string(key))
+	return true, nil
+}
+
+// Delete removes a key from the transaction.
+func (t *nutsTx) Delete(name string, key []byte) error {
+	if err := storage.ValidKey(name, key); err != nil {
+		return err
+	}
+	t.log.Debugf("delete: tx key: %s.%s", name, string(key))
+	return t.write(name, key, nil, nutsdb.Persistent)
+}
+
+// List returns a list of all keys in the namespace.
+func (t *nutsTx) List(name string) ([][]byte, error) {
+	t.log.Debugf("list: tx scan namespace: %s", name)
+	keys, err := t.tx.GetKeys(name)
+	writes, ok := t.writes[name]
+	if !ok {
+		return keys, err
+	} else if err != nil && t.tx.ExistBucket(nutsdb.DataStructureBTree, name) {
+		return nil, err
+	}
+	list := make([][]byte, 0, len(keys)+len(writes))
+	for _, key := range keys {
+		if _, ok = writes[string(key)]; !ok {
+			list = append(list, key)
+		}
+	}
+	for key, value := range writes {
+		if value != nil {
+			list = append(list, []byte(key))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i], list[j]) < 0
+	})
+	t.log.Debugf("list: tx found %d keys in: %s", len(list), name)
+	return list, nil
+}
+
+// write buffers a write to key. A nil value deletes the key.
+func (t *nutsTx) write(name string, key []byte, value []byte, ttl uint32) error {
+	if !t.writable {
+		return nutsdb.ErrTxNotWritable
+	}
+	if _, ok := t.writes[name]; !ok {
+		t.writes[name] = map[string][]byte{}
+		t.ttls[name] = map[string]uint32{}
+	}
+	if value != nil {
+		value = append([]byte{}, value...)
+	}
+	t.writes[name][string(key)] = value
+	t.ttls[name][string(key)] = ttl
+	return nil
+}
+
+// newBuckets returns the names of the buckets written to that do not exist.
+func (t *nutsTx) newBuckets() []string {
+	var names []string
+	for name, writes := range t.writes {
+		if t.tx.ExistBucket(nutsdb.DataStructureBTree, name) {
+			continue
+		}
+		for _, value := range writes {
+			if value != nil {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commit applies the buffered writes to the nutsdb transaction.
+func (t *nutsTx) commit() error {
+	for name, writes := range t.writes {
+		exists := t.tx.ExistBucket(nutsdb.DataStructureBTree, name)
+		for key, value := range writes {
+			if value != nil {
+				ttl := t.ttls[name][key]
+				if err := t.tx.Put(name, []byte(key), value, ttl); err != nil {
+					return err
+				}
+				continue
+			}
+			if !exists {
+				continue
+			}
+			err := t.tx.Delete(name, []byte(key))
+			if errors.Is(err, nutsdb.ErrKeyNotFound) ||
+				errors.Is(err, nutsdb.ErrNotFoundBucket) {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ForEach calls fn for each key and value in the namespace.
+func (t *nutsTx) ForEach(name string, fn storage.IterateFunc) error {
+	t.log.Debugf("for each: tx namespace: %s", name)
+	keys, values, err := t.tx.GetAll(name)
+	if err != nil && !t.buffered(name) {
+		return err
+	}
+	match := func([]byte) bool { return true }
+	return t.iterate(name, keys, values, match, fn)
+}
+
+// Scan calls fn for each key with the prefix and its value in the namespace.
+func (t *nutsTx) Scan(name string, prefix []byte, fn storage.IterateFunc) error {
+	t.log.Debugf("scan: tx namespace: %s prefix: %s", name, prefix)
+	match := func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}
+	keys, values, err := t.match(name, match)
+	if err != nil {
+		return err
+	}
+	return t.iterate(name, keys, values, match, fn)
+}
+
+// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+func (t *nutsTx) Range(name string, start []byte, end []byte, fn storage.IterateFunc) error {
+	t.log.Debugf("range: tx namespace: %s from: %s to: %s", name, start, end)
+	match := func(key []byte) bool {
+		return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) <= 0
+	}
+	keys, values, err := t.match(name, match)
+	if err != nil {
+		return err
+	}
+	return t.iterate(name, keys, values, match, fn)
+}
+
+// buffered returns true if the transaction has buffered writes to the namespace.
+func (t *nutsTx) buffered(name string) bool {
+	_, ok := t.writes[name]
+	return ok
+}
+
+// match returns the keys and values in the namespace that match. The keys and values
+// are read together by a single nutsdb scan, so a key that expires during the scan
+// is skipped with its value.
+func (t *nutsTx) match(name string, match func(key []byte) bool) ([][]byte, [][]byte, error) {
+	keys, values, err := t.tx.GetAll(name)
+	if err != nil {
+		if t.buffered(name) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	matchedKeys, matchedValues := keys[:0], values[:0]
+	for i, key := range keys {
+		if match(key) {
+			matchedKeys = append(matchedKeys, key)
+			matchedValues = append(matchedValues, values[i])
+		}
+	}
+	return matchedKeys, matchedValues, nil
+}
+
+// iterate merges the buffered writes to the namespace that match with the keys
+// and values, and calls fn for each key and value in key order.
+func (t *nutsTx) iterate(name string, keys [][]byte, values [][]byte,
+	match func(key []byte) bool, fn storage.IterateFunc) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("found %d keys but %d values", len(keys), len(values))
+	}
+	if writes, ok := t.writes[name]; ok {
+		merged := make(map[string][]byte, len(keys))
+		for i, key := range keys {
+			merged[string(key)] = values[i]
+		}
+		for key, value := range writes {
+			if !match([]byte(key)) {
+				continue
+			} else if value == nil {
+				delete(merged, key)
+				continue
+			}
+			merged[key] = value
+		}
+		keys = make([][]byte, 0, len(merged))
+		for key := range merged {
+			keys = append(keys, []byte(key))
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+		values = make([][]byte, len(keys))
+		for i, key := range keys {
+			values[i] = merged[string(key)]
+		}
+	}
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ import "github.com/jrapoport/chestnut/log"
 // StoreOptions provides a default implementation for common storage Options stores should support.
 type StoreOptions struct {
 	log log.Logger
+	// readOnly opens the store without write access.
+	// if readOnly is true, writes to the store fail with ErrReadOnly.
+	readOnly bool
 }
 
 // Logger returns the configured logger for the store.
@@ -12,6 +15,11 @@ func (o StoreOptions) Logger() log.Logger {
 	return o.log
 }
 
+// ReadOnly returns true if the store is opened without write access.
+func (o StoreOptions) ReadOnly() bool {
+	return o.readOnly
+}
+
 // DefaultStoreOptions represents the recommended default StoreOptions for a store.
 var DefaultStoreOptions = StoreOptions{
 	log: log.Log,
@@ -60,6 +68,17 @@ func WithLogger(l log.Logger) StoreOption {
 	})
 }
 
+// ReadOnly returns a StoreOption which opens the store without write access. The store
+// must already exist, and writes to the store return ErrReadOnly. Stores that support
+// it open the backing database read-only, so it can be shared with a writer. nutsdb
+// cannot be opened read-only, so a read-only nuts store still takes the exclusive
+// lock on its directory and cannot be opened while another process has it open.
+func ReadOnly() StoreOption {
+	return newFuncOption(func(o *StoreOptions) {
+		o.readOnly = true
+	})
+}
+
 // WithStdLogger is a convenience that returns a StoreOption for a standard err logger.
 func WithStdLogger(lvl log.Level) StoreOption {
 	return WithLogger(log.NewStdLoggerWithLevel(lvl))
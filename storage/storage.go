@@ -3,6 +3,7 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Storage provides a management interface for a datastore.
@@ -39,11 +40,88 @@ type Storage interface {
 
 	// Export saves the store to path.
 	Export(path string) error
+
+	// Import reads the backup at path saved by Export and puts its keys
+	// and values in the store. Values with the same keys are replaced.
+	Import(path string) error
+}
+
+// Tx provides access to the store inside of a transaction.
+type Tx interface {
+	// Put a value in the store.
+	Put(namespace string, key []byte, value []byte) error
+
+	// Get a value from the store.
+	Get(namespace string, key []byte) (value []byte, err error)
+
+	// Has checks for a key in the store.
+	Has(namespace string, key []byte) (bool, error)
+
+	// List returns a list of all keys in the namespace.
+	List(namespace string) ([][]byte, error)
+
+	// Delete removes a key from the store.
+	Delete(namespace string, key []byte) error
+}
+
+// Transactional is implemented by stores that support atomic transactions.
+type Transactional interface {
+	// Update executes fn inside of a read-write transaction. If fn returns nil
+	// the transaction is committed, otherwise it is rolled back and none of its
+	// writes are applied. The store may call fn more than once, so fn should not
+	// have side effects outside of the transaction.
+	Update(fn func(tx Tx) error) error
+
+	// View executes fn inside of a read-only transaction.
+	View(fn func(tx Tx) error) error
+}
+
+// Expiring is implemented by stores (and transactions) that support expiring keys natively.
+type Expiring interface {
+	// PutTTL puts a value in the store that expires after the ttl.
+	PutTTL(namespace string, key []byte, value []byte, ttl time.Duration) error
+}
+
+// IterateFunc is the prototype for the function called for each key and value
+// by an Iterable store. The key and value are only valid for the life of the
+// call. If IterateFunc returns an error, the iteration stops and the error
+// is returned.
+type IterateFunc func(key []byte, value []byte) error
+
+// Iterable is implemented by stores (and transactions) that support iterating over
+// the keys and values in a namespace in key order without listing the keys first.
+type Iterable interface {
+	// ForEach calls fn for each key and value in the namespace.
+	ForEach(namespace string, fn IterateFunc) error
+
+	// Scan calls fn for each key with the prefix and its value in the namespace.
+	Scan(namespace string, prefix []byte, fn IterateFunc) error
+
+	// Range calls fn for each key from start to end (inclusive) and its value in the namespace.
+	Range(namespace string, start []byte, end []byte, fn IterateFunc) error
+}
+
+// BackupFunc is the prototype for the function called for each namespace, key,
+// and value in a backup by ReadBackup. If BackupFunc returns an error, reading
+// stops and the error is returned.
+type BackupFunc func(namespace string, key []byte, value []byte) error
+
+// BackupReader is implemented by stores that can read a backup saved by Export
+// without importing it.
+type BackupReader interface {
+	// ReadBackup calls fn for each namespace, key, and value in the backup at path.
+	ReadBackup(path string, fn BackupFunc) error
 }
 
 // ErrInvalidKey the storage key is invalid.
 var ErrInvalidKey = errors.New("invalid storage key")
 
+// ErrTxNotSupported the store does not support transactions.
+var ErrTxNotSupported = errors.New("transactions not supported")
+
+// ErrReadOnly the store was opened read-only.
+var ErrReadOnly = errors.New("store is read-only")
+
 // ValidKey returns nil if the key is valid, otherwise ErrInvalidKey.
 func ValidKey(name string, key []byte) error {
 	if name == "" {
@@ -1,9 +1,12 @@
 package store_test
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jrapoport/chestnut/log"
@@ -209,6 +212,102 @@ func (ts *storeTestSuite) TestStoreDelete() {
 	}
 }
 
+// TestStoreImport
+func (ts *storeTestSuite) TestStoreImport() {
+	path := ts.T().TempDir()
+	err := ts.store.Export(path)
+	ts.NoError(err)
+	err = ts.store.Delete(testName, []byte(testKey))
+	ts.NoError(err)
+	err = ts.store.Put(testName, []byte("b"), []byte("changed"))
+	ts.NoError(err)
+	err = ts.store.Put(testName, []byte("imported"), []byte(testValue))
+	ts.NoError(err)
+	err = ts.store.Import(path)
+	ts.NoError(err)
+	v, err := ts.store.Get(testName, []byte(testKey))
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	v, err = ts.store.Get(testName, []byte("b"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	has, err := ts.store.Has(testName, []byte("imported"))
+	ts.NoError(err)
+	ts.True(has)
+	br, ok := ts.store.(storage.BackupReader)
+	ts.Require().True(ok)
+	var n int
+	err = br.ReadBackup(path, func(name string, key []byte, value []byte) error {
+		ts.Equal(testValue, string(value))
+		n++
+		return nil
+	})
+	ts.NoError(err)
+	ts.Equal(7, n)
+	err = ts.store.Import(ts.path)
+	ts.Error(err)
+	err = ts.store.Import(filepath.Join(path, "not-found"))
+	ts.Error(err)
+}
+
+// TestStoreMigrate
+func (ts *storeTestSuite) TestStoreMigrate() {
+	dst := ts.storeFunc(ts.T().TempDir())
+	err := dst.Open()
+	ts.Require().NoError(err)
+	defer func() {
+		err = dst.Close()
+		ts.NoError(err)
+	}()
+	errTransform := errors.New("transform failed")
+	interrupt := true
+	transform := storage.WithTransform(func(name string, key []byte, value []byte) ([]byte, error) {
+		if interrupt && name == testName {
+			return nil, errTransform
+		}
+		return append([]byte("migrated-"), value...), nil
+	})
+	// interrupt the migration on the last namespace
+	_, err = storage.Migrate(ts.store, dst, transform)
+	ts.ErrorIs(err, errTransform)
+	has, err := dst.Has("b", []byte(testKey))
+	ts.NoError(err)
+	ts.True(has)
+	// a changed value is copied again
+	err = ts.store.Put("b", []byte(testKey), []byte("changed"))
+	ts.NoError(err)
+	interrupt = false
+	res, err := storage.Migrate(ts.store, dst, transform)
+	ts.NoError(err)
+	ts.Equal(storage.MigrateResult{Namespaces: 4, Keys: 7, Copied: 5, Skipped: 2}, res)
+	keyMap, err := dst.ListAll()
+	ts.NoError(err)
+	ts.Len(keyMap, 4)
+	v, err := dst.Get("b", []byte(testKey))
+	ts.NoError(err)
+	ts.Equal("migrated-changed", string(v))
+	v, err = dst.Get(testName, []byte(testKey))
+	ts.NoError(err)
+	ts.Equal("migrated-"+testValue, string(v))
+	// without a transform the values are compared
+	res, err = storage.Migrate(ts.store, dst)
+	ts.NoError(err)
+	ts.Equal(7, res.Copied)
+	v, err = dst.Get(testName, []byte(testKey))
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	// the source changed during the migration
+	_, err = storage.Migrate(ts.store, dst, storage.WithTransform(
+		func(name string, key []byte, value []byte) ([]byte, error) {
+			return value, ts.store.Put(name, key, []byte("changed-again"))
+		}))
+	ts.ErrorContains(err, "checksum mismatch")
+	_, err = storage.Migrate(ts.store, ts.store)
+	ts.Error(err)
+	_, err = storage.Migrate(ts.store, nil)
+	ts.Error(err)
+}
+
 // TestStoreExport
 func (ts *storeTestSuite) TestStoreExport() {
 	exTests := []struct {
@@ -236,6 +335,262 @@ func (ts *storeTestSuite) TestStoreExport() {
 	}
 }
 
+// TestStoreUpdate
+func (ts *storeTestSuite) TestStoreUpdate() {
+	store, ok := ts.store.(storage.Transactional)
+	ts.Require().True(ok)
+	const newName = "new-name"
+	err := store.Update(func(tx storage.Tx) error {
+		if err := tx.Put(testName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Put(newName, []byte(testKey), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("b")); err != nil {
+			return err
+		}
+		// a key written and deleted in the same tx
+		if err := tx.Put(testName, []byte("e"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("e")); err != nil {
+			return err
+		}
+		// reads see the writes of the tx
+		value, err := tx.Get(newName, []byte(testKey))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+		has, err := tx.Has(testName, []byte("b"))
+		ts.NoError(err)
+		ts.False(has)
+		_, err = tx.Get(testName, []byte("e"))
+		ts.Error(err)
+		keys, err := tx.List(testName)
+		ts.NoError(err)
+		ts.Len(keys, 4)
+		return nil
+	})
+	ts.NoError(err)
+	value, err := ts.store.Get(testName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	value, err = ts.store.Get(newName, []byte(testKey))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	has, _ := ts.store.Has(testName, []byte("b"))
+	ts.False(has)
+	has, _ = ts.store.Has(testName, []byte("e"))
+	ts.False(has)
+	err = store.Update(func(tx storage.Tx) error {
+		return tx.Put("", []byte(testKey), []byte(testValue))
+	})
+	ts.Error(err)
+}
+
+// TestStoreUpdateRollback
+func (ts *storeTestSuite) TestStoreUpdateRollback() {
+	store, ok := ts.store.(storage.Transactional)
+	ts.Require().True(ok)
+	errRollback := errors.New("rollback")
+	err := store.Update(func(tx storage.Tx) error {
+		if err := tx.Put(testName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Put("rollback-name", []byte(testKey), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("b")); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	ts.ErrorIs(err, errRollback)
+	has, _ := ts.store.Has(testName, []byte("a"))
+	ts.False(has)
+	has, _ = ts.store.Has(testName, []byte("b"))
+	ts.True(has)
+	has, _ = ts.store.Has("rollback-name", []byte(testKey))
+	ts.False(has)
+}
+
+// TestStoreView
+func (ts *storeTestSuite) TestStoreView() {
+	store, ok := ts.store.(storage.Transactional)
+	ts.Require().True(ok)
+	err := store.View(func(tx storage.Tx) error {
+		value, err := tx.Get(testName, []byte("b"))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+		has, err := tx.Has(testName, []byte(testKey))
+		ts.NoError(err)
+		ts.True(has)
+		keys, err := tx.List(testName)
+		ts.NoError(err)
+		ts.Len(keys, 4)
+		err = tx.Delete(testName, []byte("b"))
+		ts.Error(err)
+		return tx.Put(testName, []byte("a"), []byte(testValue))
+	})
+	ts.Error(err)
+	has, _ := ts.store.Has(testName, []byte("a"))
+	ts.False(has)
+}
+
+// TestStorePutTTL
+func (ts *storeTestSuite) TestStorePutTTL() {
+	store, ok := ts.store.(storage.Expiring)
+	if !ok {
+		ts.T().Skip("store does not support ttl")
+	}
+	const ttl = time.Second
+	err := store.PutTTL(testName, []byte("a"), []byte(testValue), ttl)
+	ts.NoError(err)
+	err = store.PutTTL(testName, []byte("e"), nil, ttl)
+	ts.Error(err)
+	err = ts.store.(storage.Transactional).Update(func(tx storage.Tx) error {
+		return tx.(storage.Expiring).PutTTL(testName, []byte("f"), []byte(testValue), ttl)
+	})
+	ts.NoError(err)
+	for _, key := range []string{"a", "f"} {
+		value, err := ts.store.Get(testName, []byte(key))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+	}
+	time.Sleep(ttl + ttl/2)
+	for _, key := range []string{"a", "f"} {
+		_, err = ts.store.Get(testName, []byte(key))
+		ts.Error(err)
+	}
+	// expired keys are skipped with their values
+	var keys []string
+	collect := func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		ts.Equal(testValue, string(value))
+		return nil
+	}
+	it := ts.store.(storage.Iterable)
+	err = it.Range(testName, []byte("a"), []byte("f"), collect)
+	ts.NoError(err)
+	ts.Equal([]string{"b", "c/c"}, keys)
+	keys = nil
+	err = it.Scan(testName, []byte("a"), collect)
+	ts.NoError(err)
+	ts.Empty(keys)
+	value, err := ts.store.Get(testName, []byte("b"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+}
+
+// TestStoreIterate
+func (ts *storeTestSuite) TestStoreIterate() {
+	store, ok := ts.store.(storage.Iterable)
+	ts.Require().True(ok)
+	var keys []string
+	collect := func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		ts.Equal(testValue, string(value))
+		return nil
+	}
+	err := store.ForEach(testName, collect)
+	ts.NoError(err)
+	ts.Equal([]string{".d", "b", "c/c", testKey}, keys)
+	keys = nil
+	err = store.Scan(testName, []byte("c/"), collect)
+	ts.NoError(err)
+	ts.Equal([]string{"c/c"}, keys)
+	keys = nil
+	err = store.Scan(testName, []byte("not-found"), collect)
+	ts.NoError(err)
+	ts.Empty(keys)
+	keys = nil
+	err = store.Range(testName, []byte("b"), []byte("c/c"), collect)
+	ts.NoError(err)
+	ts.Equal([]string{"b", "c/c"}, keys)
+	keys = nil
+	err = store.Range(testName, []byte("x"), []byte("z"), collect)
+	ts.NoError(err)
+	ts.Empty(keys)
+	errStop := errors.New("stop")
+	err = store.ForEach(testName, func([]byte, []byte) error {
+		return errStop
+	})
+	ts.ErrorIs(err, errStop)
+	err = store.ForEach("not-found", collect)
+	ts.Error(err)
+}
+
+// TestStoreIterateTx
+func (ts *storeTestSuite) TestStoreIterateTx() {
+	store, ok := ts.store.(storage.Transactional)
+	ts.Require().True(ok)
+	err := store.Update(func(tx storage.Tx) error {
+		it, ok := tx.(storage.Iterable)
+		ts.Require().True(ok)
+		if err := tx.Put(testName, []byte("c/a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("b")); err != nil {
+			return err
+		}
+		var keys []string
+		collect := func(key []byte, value []byte) error {
+			keys = append(keys, string(key))
+			ts.Equal(testValue, string(value))
+			return nil
+		}
+		ts.NoError(it.ForEach(testName, collect))
+		ts.Equal([]string{".d", "c/a", "c/c", testKey}, keys)
+		keys = nil
+		ts.NoError(it.Scan(testName, []byte("c/"), collect))
+		ts.Equal([]string{"c/a", "c/c"}, keys)
+		keys = nil
+		ts.NoError(it.Range(testName, []byte("a"), []byte("c/b"), collect))
+		ts.Equal([]string{"c/a"}, keys)
+		return nil
+	})
+	ts.NoError(err)
+}
+
+// TestStoreReadOnly
+func (ts *storeTestSuite) TestStoreReadOnly() {
+	err := ts.store.Close()
+	ts.Require().NoError(err)
+	ts.store = ts.storeFunc(ts.path, storage.ReadOnly())
+	err = ts.store.Open()
+	ts.Require().NoError(err)
+	v, err := ts.store.Get(testName, []byte(testKey))
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	keys, err := ts.store.List(testName)
+	ts.NoError(err)
+	ts.Len(keys, 4)
+	err = ts.store.Put(testName, []byte(testKey), []byte("changed"))
+	ts.ErrorIs(err, storage.ErrReadOnly)
+	err = ts.store.Save(testName, []byte(testKey), testObj)
+	ts.ErrorIs(err, storage.ErrReadOnly)
+	err = ts.store.Delete(testName, []byte(testKey))
+	ts.ErrorIs(err, storage.ErrReadOnly)
+	err = ts.store.Import(ts.T().TempDir())
+	ts.ErrorIs(err, storage.ErrReadOnly)
+	tx, ok := ts.store.(storage.Transactional)
+	ts.Require().True(ok)
+	err = tx.Update(func(tx storage.Tx) error {
+		return tx.Put(testName, []byte(testKey), []byte("changed"))
+	})
+	ts.ErrorIs(err, storage.ErrReadOnly)
+	err = tx.View(func(tx storage.Tx) error {
+		v, err = tx.Get(testName, []byte(testKey))
+		return err
+	})
+	ts.NoError(err)
+	ts.Equal(testValue, string(v))
+	// a read-only store must already exist
+	store := ts.storeFunc(filepath.Join(ts.T().TempDir(), "not-found"), storage.ReadOnly())
+	err = store.Open()
+	ts.Error(err)
+}
+
 // TestStoreWithLogger
 func (ts *storeTestSuite) TestStoreWithLogger() {
 	levels := []log.Level{
@@ -0,0 +1,343 @@
+package chestnut
+
+import (
+	"bytes"
+	goaes "crypto/aes"
+	gocipher "crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// streamNamespace is the reserved namespace used to store the stream chunks.
+	streamNamespace = "__chestnut_stream"
+
+	// DefaultChunkSize is the default size of a stream chunk.
+	DefaultChunkSize = 64 * 1024
+
+	// streamVersion is the current stream manifest version.
+	streamVersion = 1
+
+	// streamNoncePrefix is the length of the random nonce prefix. The rest of the
+	// 12 byte GCM nonce is the 4 byte chunk counter and the 1 byte last chunk flag.
+	streamNoncePrefix = 7
+)
+
+var (
+	streamTag = []byte{0x5, 0x7, 0xE, 0xA, 0x4, 0x5, 0x7, 0xE}
+	streamSep = []byte{0x1e} // US-ASCII Record Separator
+)
+
+// streamManifest describes a stream stored by PutStream. The manifest is encrypted
+// with the encryptor and stored at the key of the stream. The chunks are sealed with
+// AES256-GCM using the stream Key and stored in the reserved stream namespace. Expiry
+// is the expiry time of the stream in Unix nanoseconds, or zero if it does not expire.
+type streamManifest struct {
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	ChunkSize int    `json:"chunk_size"`
+	Chunks    uint32 `json:"chunks"`
+	Size      int64  `json:"size"`
+	Nonce     []byte `json:"nonce"`
+	Key       []byte `json:"key"`
+	Expiry    int64  `json:"expiry,omitempty"`
+}
+
+// expiry returns the expiry time of the stream, or a zero time if it does not expire.
+func (m *streamManifest) expiry() time.Time {
+	if m.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.Expiry)
+}
+
+// chunkKey returns the storage key of the chunk i.
+func (m *streamManifest) chunkKey(i uint32) []byte {
+	return []byte(fmt.Sprintf("%s/%08x", m.ID, i))
+}
+
+// nonce returns the nonce of the chunk i. SEE: STREAM https://eprint.iacr.org/2015/189.pdf
+func (m *streamManifest) nonce(i uint32, last bool) []byte {
+	nonce := make([]byte, 0, streamNoncePrefix+5)
+	nonce = append(nonce, m.Nonce...)
+	nonce = binary.BigEndian.AppendUint32(nonce, i)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// aead returns the AES256-GCM cipher for the stream key.
+func (m *streamManifest) aead() (gocipher.AEAD, error) {
+	block, err := goaes.NewCipher(m.Key)
+	if err != nil {
+		return nil, err
+	}
+	return gocipher.NewGCM(block)
+}
+
+// isStream returns true if the stored value is a stream manifest.
+func isStream(value []byte) bool {
+	return bytes.HasPrefix(value, append(streamTag, streamSep...))
+}
+
+// PutStream reads r until EOF and stores it at key as a stream. Unlike Put, the value
+// is never held in memory. It is split into fixed-size chunks (SEE: WithChunkSize) and
+// each chunk is sealed with AES256-GCM using a random stream key and a nonce derived
+// from the chunk counter. The stream key is stored in a manifest at key, which is
+// encrypted with the encryptor. Compression is not applied to streams. If a stream
+// already exists at key, it is replaced. The chunks are written to the store before
+// the manifest and not in a transaction, so if the process stops before the manifest
+// is stored, the chunks written so far are left in the store and are not reachable.
+func (cn *Chestnut) PutStream(name string, key []byte, r io.Reader, opt ...PutOption) error {
+	cn.log.Debugf("put stream: to key: %s", key)
+	if err := cn.writable("put stream"); err != nil {
+		return err
+	}
+	defer cn.lockWrites()()
+	if err := storage.ValidKey(name, key); err != nil {
+		return cn.logError("put stream", err)
+	} else if r == nil {
+		err = errors.New("reader cannot be nil")
+		return cn.logError("put stream", err)
+	} else if err = cn.CanPut(name, key); err != nil {
+		return cn.logError("put stream", err)
+	}
+	// the chunks and the manifest expire together
+	expiry := applyPutOptions(PutOptions{}, opt...).expiry()
+	m, err := cn.newStreamManifest()
+	if err != nil {
+		return cn.logError("put stream", err)
+	}
+	if !expiry.IsZero() {
+		m.Expiry = expiry.UnixNano()
+	}
+	if err = cn.putChunks(m, r, expiry); err != nil {
+		cn.deleteChunks(m)
+		return cn.logError("put stream", err)
+	}
+	old, _ := cn.streamManifest(cn.store, name, key)
+	if err = cn.putStreamManifest(name, key, m, expiry); err != nil {
+		cn.deleteChunks(m)
+		return cn.logError("put stream", err)
+	}
+	if old != nil {
+		cn.deleteChunks(old)
+	}
+	cn.publish(putEvent(name, key, nil))
+	cn.log.Debugf("put stream: put %d bytes in %d chunks to key: %s",
+		m.Size, m.Chunks, key)
+	return nil
+}
+
+// GetStream decrypts the stream at key and writes it to w. Each chunk is authenticated
+// before it is written, so w may have been written to when an error is returned. If
+// the chunks were truncated, reordered, or modified an error is returned.
+func (cn *Chestnut) GetStream(name string, key []byte, w io.Writer) error {
+	cn.log.Debugf("get stream: from key: %s", key)
+	if w == nil {
+		err := errors.New("writer cannot be nil")
+		return cn.logError("get stream", err)
+	}
+	m, err := cn.streamManifest(cn.store, name, key)
+	if err != nil {
+		return cn.logError("get stream", err)
+	}
+	aead, err := m.aead()
+	if err != nil {
+		return cn.logError("get stream", err)
+	}
+	var size int64
+	for i := uint32(0); i < m.Chunks; i++ {
+		sealed, err := cn.getExpiring(cn.store, streamNamespace, m.chunkKey(i))
+		if err != nil {
+			err = fmt.Errorf("chunk %d: %w", i, err)
+			return cn.logError("get stream", err)
+		}
+		last := i == m.Chunks-1
+		chunk, err := aead.Open(nil, m.nonce(i, last), sealed, nil)
+		if err != nil {
+			err = fmt.Errorf("chunk %d: %w", i, err)
+			return cn.logError("get stream", err)
+		}
+		if _, err = w.Write(chunk); err != nil {
+			return cn.logError("get stream", err)
+		}
+		size += int64(len(chunk))
+	}
+	if size != m.Size {
+		err = fmt.Errorf("invalid stream size: %d expected: %d", size, m.Size)
+		return cn.logError("get stream", err)
+	}
+	cn.log.Debugf("get stream: got %d bytes from key: %s", size, key)
+	return nil
+}
+
+// newStreamManifest returns a manifest for a new stream with a random id, nonce
+// prefix, and stream key.
+func (cn *Chestnut) newStreamManifest() (*streamManifest, error) {
+	id, err := crypto.MakeRand(16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := crypto.MakeRand(streamNoncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.MakeRand(uint(crypto.Key256))
+	if err != nil {
+		return nil, err
+	}
+	size := cn.opts.chunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	return &streamManifest{
+		Version:   streamVersion,
+		ID:        hex.EncodeToString(id),
+		ChunkSize: size,
+		Nonce:     nonce,
+		Key:       key,
+	}, nil
+}
+
+// putChunks reads r and stores it in sealed chunks. The chunk after the current
+// chunk is read before the current chunk is sealed to find the last chunk.
+func (cn *Chestnut) putChunks(m *streamManifest, r io.Reader, expiry time.Time) error {
+	aead, err := m.aead()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, m.ChunkSize)
+	next := make([]byte, m.ChunkSize)
+	n, err := io.ReadFull(r, buf)
+	for {
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		last := err != nil
+		var nn int
+		if !last {
+			nn, err = io.ReadFull(r, next)
+			// the current chunk is the last chunk if there is nothing after it
+			last = nn == 0 && errors.Is(err, io.EOF)
+		}
+		if m.Chunks == ^uint32(0) {
+			return errors.New("stream too large")
+		}
+		sealed := aead.Seal(nil, m.nonce(m.Chunks, last), buf[:n], nil)
+		if err := cn.putExpiry(cn.store, streamNamespace, m.chunkKey(m.Chunks), sealed, expiry); err != nil {
+			return err
+		}
+		m.Chunks++
+		m.Size += int64(n)
+		if last {
+			return nil
+		}
+		buf, next, n = next, buf, nn
+	}
+}
+
+// putStreamManifest encrypts the stream manifest and stores it at key.
+func (cn *Chestnut) putStreamManifest(name string, key []byte, m *streamManifest, expiry time.Time) error {
+	value, err := cn.encodeStreamManifest(m, cn.encryptor())
+	if err != nil {
+		return err
+	}
+	return cn.putExpiry(cn.store, name, key, value, expiry)
+}
+
+// encodeStreamManifest returns the stream manifest encrypted with e.
+func (cn *Chestnut) encodeStreamManifest(m *streamManifest, e crypto.Encryptor) ([]byte, error) {
+	b, err := jsoniter.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := e.Encrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join([][]byte{streamTag, ciphertext}, streamSep), nil
+}
+
+// streamManifest returns the decrypted stream manifest at key. If the stream has
+// expired, an error wrapping ErrNotFound is returned.
+func (cn *Chestnut) streamManifest(tx storage.Tx, name string, key []byte) (*streamManifest, error) {
+	value, err := cn.getExpiring(tx, name, key)
+	if err != nil {
+		return nil, err
+	}
+	m, err := cn.decodeStreamManifest(value)
+	if err != nil {
+		return nil, err
+	}
+	if expired(m.expiry()) {
+		return nil, fmt.Errorf("%w: key expired: %s", ErrNotFound, key)
+	}
+	return m, nil
+}
+
+// decodeStreamManifest returns the stream manifest decrypted with the encryptor.
+func (cn *Chestnut) decodeStreamManifest(value []byte) (*streamManifest, error) {
+	if !isStream(value) {
+		return nil, errors.New("stream not found")
+	}
+	ciphertext := value[len(streamTag)+len(streamSep):]
+	b, err := cn.decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	m := new(streamManifest)
+	if err = jsoniter.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	if m.Version != streamVersion {
+		return nil, fmt.Errorf("unsupported stream version: %d", m.Version)
+	}
+	return m, nil
+}
+
+// deleteStream deletes the chunks of the stream stored at key in tx. If the
+// value at key is not a stream, or the key is not found, it does nothing.
+func (cn *Chestnut) deleteStream(tx storage.Tx, name string, key []byte) error {
+	value, err := tx.Get(name, key)
+	if err != nil {
+		return nil
+	}
+	if value, _ = decodeExpiry(value); !isStream(value) {
+		return nil
+	}
+	m, err := cn.decodeStreamManifest(value)
+	if err != nil {
+		return err
+	}
+	return deleteStreamChunks(tx, m)
+}
+
+// deleteStreamChunks deletes the chunks of the stream in tx.
+func deleteStreamChunks(tx storage.Tx, m *streamManifest) error {
+	for i := uint32(0); i < m.Chunks; i++ {
+		if err := tx.Delete(streamNamespace, m.chunkKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChunks deletes the chunks of the stream. Errors are logged and ignored.
+func (cn *Chestnut) deleteChunks(m *streamManifest) {
+	cn.log.Debugf("delete stream: %d chunks of stream: %s", m.Chunks, m.ID)
+	for i := uint32(0); i < m.Chunks; i++ {
+		if err := cn.store.Delete(streamNamespace, m.chunkKey(i)); err != nil {
+			cn.log.Warnf("delete stream: chunk %d: %s", i, err)
+		}
+	}
+}
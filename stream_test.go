@@ -0,0 +1,189 @@
+package chestnut
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+const (
+	streamName      = "stream-namespace"
+	testChunkSize   = 1024
+	testStreamKey   = "stream-key"
+	testStreamLarge = 5*testChunkSize + 7
+)
+
+// streamChunkCount returns the number of chunks in a stream of size.
+func streamChunkCount(size int) uint32 {
+	if size <= 0 {
+		return 1
+	}
+	return uint32((size + testChunkSize - 1) / testChunkSize)
+}
+
+func (ts *ChestnutTestSuite) streamChest() *Chestnut {
+	return NewChestnut(ts.cn.store, encryptorOpt, WithChunkSize(testChunkSize))
+}
+
+func (ts *ChestnutTestSuite) putStream(cn *Chestnut, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	ts.Require().NoError(err)
+	err = cn.PutStream(streamName, []byte(testStreamKey), bytes.NewReader(data))
+	ts.Require().NoError(err)
+	return data
+}
+
+func (ts *ChestnutTestSuite) streamChunks(cn *Chestnut) *streamManifest {
+	m, err := cn.streamManifest(cn.store, streamName, []byte(testStreamKey))
+	ts.Require().NoError(err)
+	return m
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Stream() {
+	cn := ts.streamChest()
+	sizes := []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, testStreamLarge}
+	for _, size := range sizes {
+		data := ts.putStream(cn, size)
+		m := ts.streamChunks(cn)
+		ts.Equal(int64(size), m.Size)
+		ts.Equal(streamChunkCount(size), m.Chunks)
+		buf := &bytes.Buffer{}
+		err := cn.GetStream(streamName, []byte(testStreamKey), buf)
+		ts.NoError(err)
+		ts.True(bytes.Equal(data, buf.Bytes()))
+	}
+	has, err := cn.Has(streamName, []byte(testStreamKey))
+	ts.NoError(err)
+	ts.True(has)
+	_, err = cn.Get(streamName, []byte(testStreamKey))
+	ts.Error(err)
+	err = cn.PutStream(streamName, []byte(testStreamKey), nil)
+	ts.Error(err)
+	err = cn.GetStream(streamName, []byte(testStreamKey), nil)
+	ts.Error(err)
+	err = cn.GetStream(streamName, []byte("not-found"), io.Discard)
+	ts.Error(err)
+	err = cn.Put(streamName, []byte("value"), []byte(testValue))
+	ts.NoError(err)
+	err = cn.GetStream(streamName, []byte("value"), io.Discard)
+	ts.Error(err)
+	// the chunks of a replaced stream are deleted
+	chunks, err := cn.store.List(streamNamespace)
+	ts.NoError(err)
+	ts.Len(chunks, int(streamChunkCount(testStreamLarge)))
+	err = cn.Delete(streamName, []byte(testStreamKey))
+	ts.NoError(err)
+	chunks, err = cn.store.List(streamNamespace)
+	ts.NoError(err)
+	ts.Len(chunks, 0)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_StreamTampered() {
+	cn := ts.streamChest()
+	key := []byte(testStreamKey)
+	ts.putStream(cn, testStreamLarge)
+	m := ts.streamChunks(cn)
+	// reorder the chunks
+	c0, err := cn.store.Get(streamNamespace, m.chunkKey(0))
+	ts.Require().NoError(err)
+	c1, err := cn.store.Get(streamNamespace, m.chunkKey(1))
+	ts.Require().NoError(err)
+	ts.Require().NoError(cn.store.Put(streamNamespace, m.chunkKey(0), c1))
+	ts.Require().NoError(cn.store.Put(streamNamespace, m.chunkKey(1), c0))
+	err = cn.GetStream(streamName, key, io.Discard)
+	ts.Error(err)
+	// modify a chunk
+	ts.Require().NoError(cn.store.Put(streamNamespace, m.chunkKey(0), c0))
+	c1[0] ^= 0xff
+	ts.Require().NoError(cn.store.Put(streamNamespace, m.chunkKey(1), c1))
+	err = cn.GetStream(streamName, key, io.Discard)
+	ts.Error(err)
+	c1[0] ^= 0xff
+	ts.Require().NoError(cn.store.Put(streamNamespace, m.chunkKey(1), c1))
+	err = cn.GetStream(streamName, key, io.Discard)
+	ts.NoError(err)
+	// truncate the stream
+	truncated := *m
+	truncated.Chunks--
+	err = cn.putStreamManifest(streamName, key, &truncated, time.Time{})
+	ts.Require().NoError(err)
+	err = cn.GetStream(streamName, key, io.Discard)
+	ts.Error(err)
+	// delete a chunk
+	err = cn.putStreamManifest(streamName, key, m, time.Time{})
+	ts.Require().NoError(err)
+	ts.Require().NoError(cn.store.Delete(streamNamespace, m.chunkKey(2)))
+	err = cn.GetStream(streamName, key, io.Discard)
+	ts.Error(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_StreamOverwrite() {
+	cn := ts.streamChest()
+	key := []byte(testStreamKey)
+	overwrites := []func() error{
+		func() error {
+			return cn.Put(streamName, key, []byte(testValue))
+		},
+		func() error {
+			return cn.Save(streamName, key, &TObject{ValueA: testValue})
+		},
+		func() error {
+			return cn.Update(func(tx *Tx) error {
+				return tx.Put(streamName, key, []byte(testValue))
+			})
+		},
+		func() error {
+			return cn.Update(func(tx *Tx) error {
+				return tx.Save(streamName, key, &TObject{ValueA: testValue})
+			})
+		},
+	}
+	for _, overwrite := range overwrites {
+		ts.putStream(cn, testStreamLarge)
+		chunks, err := cn.store.List(streamNamespace)
+		ts.NoError(err)
+		ts.NotEmpty(chunks)
+		err = overwrite()
+		ts.NoError(err)
+		// the chunks of the stream are deleted with it
+		chunks, err = cn.store.List(streamNamespace)
+		ts.NoError(err)
+		ts.Empty(chunks)
+	}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_StreamTTL() {
+	cn := ts.streamChest()
+	data := make([]byte, testStreamLarge)
+	err := cn.PutStream(streamName, []byte(testStreamKey), bytes.NewReader(data), WithTTL(testTTL))
+	ts.NoError(err)
+	err = cn.GetStream(streamName, []byte(testStreamKey), io.Discard)
+	ts.NoError(err)
+	time.Sleep(testTTL)
+	err = cn.GetStream(streamName, []byte(testStreamKey), io.Discard)
+	ts.ErrorIs(err, ErrNotFound)
+	n, err := cn.Reap()
+	ts.NoError(err)
+	ts.Equal(int(streamChunkCount(testStreamLarge))+1, n)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_StreamRekey() {
+	cn := ts.streamChest()
+	data := ts.putStream(cn, testStreamLarge)
+	secret := crypto.TextSecret("i-am-a-new-secret")
+	err := cn.Rekey(encryptor.NewAESEncryptor(crypto.Key256, aes.CFB, secret))
+	ts.Require().NoError(err)
+	buf := &bytes.Buffer{}
+	err = cn.GetStream(streamName, []byte(testStreamKey), buf)
+	ts.NoError(err)
+	ts.Equal(data, buf.Bytes())
+	// the old encryptor can no longer read the manifest
+	err = ts.streamChest().GetStream(streamName, []byte(testStreamKey), io.Discard)
+	ts.Error(err)
+}
@@ -0,0 +1,303 @@
+package chestnut
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jrapoport/chestnut/storage"
+)
+
+// PutOptions provides the options for a call to Put or Save.
+type PutOptions struct {
+	// ttl is the time-to-live of the record.
+	ttl time.Duration
+}
+
+// A PutOption sets options such as the time-to-live of a record.
+type PutOption interface {
+	apply(*PutOptions)
+}
+
+// putFuncOption wraps a function that modifies PutOptions
+// into an implementation of the PutOption interface.
+type putFuncOption struct {
+	f func(*PutOptions)
+}
+
+// apply applies an Option to PutOptions.
+func (fdo *putFuncOption) apply(do *PutOptions) {
+	fdo.f(do)
+}
+
+func newPutFuncOption(f func(*PutOptions)) *putFuncOption {
+	return &putFuncOption{
+		f: f,
+	}
+}
+
+// expiry returns the expiry time of a record put now with the options,
+// or a zero time if the record does not expire.
+func (o PutOptions) expiry() time.Time {
+	if o.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(o.ttl)
+}
+
+// applyPutOptions accepts a PutOptions struct and applies the PutOption(s) to it.
+func applyPutOptions(opts PutOptions, opt ...PutOption) PutOptions {
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	return opts
+}
+
+// WithTTL returns a PutOption that expires the record after the ttl. Once a record
+// has expired it is treated as not found, and it will be deleted by the reaper
+// (SEE: WithReaper) or the next call to Reap. The expiry time is sealed with the
+// encrypted record, so it cannot be removed or changed in the store. Sparse
+// does not decrypt the record and only checks the copy stored in front of it.
+// A ttl <= 0 means the record will not expire.
+func WithTTL(ttl time.Duration) PutOption {
+	return newPutFuncOption(func(o *PutOptions) {
+		o.ttl = ttl
+	})
+}
+
+var (
+	expiryTag = []byte{0xE, 0xE, 0xE, 0x0, 0x0, 0x0, 0x0, 0x5}
+	expirySep = []byte{0x1e} // US-ASCII Record Separator
+)
+
+// expiryGrace is how long after the expiry time a store that supports
+// expiring keys natively is allowed to delete the key.
+const expiryGrace = time.Second
+
+// expiryLen is the length of the expiry header.
+var expiryLen = len(expiryTag) + len(expirySep) + 8
+
+// sealTag starts the header sealed in front of the plaintext of every record. It is
+// followed by a format byte, and the expiry time if the format has one. Plaintext
+// without the tag was encrypted before the header was added, and has no expiry time.
+var sealTag = []byte{0xE, 0xE, 0xE, 0x0, 0x0, 0x0, 0x0, 0x6}
+
+const (
+	// sealNoExpiry the record does not expire.
+	sealNoExpiry byte = iota
+	// sealExpires the format byte is followed by the expiry time of the record.
+	sealExpires
+)
+
+// encodeExpiry adds the expiry time to the stored data. The expiry time in front of
+// the ciphertext is not authenticated, it is only used to find expired records
+// without decrypting them. SEE: sealExpiry
+func encodeExpiry(data []byte, expiry time.Time) []byte {
+	if expiry.IsZero() || len(data) <= 0 {
+		return data
+	}
+	buf := make([]byte, 0, expiryLen+len(data))
+	buf = append(buf, expiryTag...)
+	buf = append(buf, expirySep...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expiry.UnixNano()))
+	return append(buf, data...)
+}
+
+// decodeExpiry removes and returns the expiry time from the stored data. If
+// no expiry time is found decodeExpiry returns the original data and a zero time.
+func decodeExpiry(data []byte) ([]byte, time.Time) {
+	if len(data) <= expiryLen || !bytes.HasPrefix(data, expiryTag) {
+		return data, time.Time{}
+	}
+	if !bytes.Equal(data[len(expiryTag):len(expiryTag)+len(expirySep)], expirySep) {
+		return data, time.Time{}
+	}
+	nano := binary.BigEndian.Uint64(data[expiryLen-8 : expiryLen])
+	return data[expiryLen:], time.Unix(0, int64(nano))
+}
+
+// sealExpiry adds the seal header with the expiry time to the plaintext before it
+// is encrypted, so the expiry time of a record is authenticated by the encryptor.
+// The header is always added, so a record that does not expire can be told apart
+// from plaintext that only happens to start with an expiry time.
+func sealExpiry(plaintext []byte, expiry time.Time) []byte {
+	buf := make([]byte, 0, len(sealTag)+1+8+len(plaintext))
+	buf = append(buf, sealTag...)
+	if expiry.IsZero() {
+		buf = append(buf, sealNoExpiry)
+	} else {
+		buf = append(buf, sealExpires)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(expiry.UnixNano()))
+	}
+	return append(buf, plaintext...)
+}
+
+// openExpiry removes the seal header from the decrypted plaintext and returns the
+// sealed expiry time. If the plaintext does not have a seal header, it is returned
+// as is with a zero time.
+func openExpiry(plaintext []byte) ([]byte, time.Time) {
+	n := len(sealTag)
+	if len(plaintext) <= n || !bytes.HasPrefix(plaintext, sealTag) {
+		return plaintext, time.Time{}
+	}
+	switch plaintext[n] {
+	case sealNoExpiry:
+		return plaintext[n+1:], time.Time{}
+	case sealExpires:
+		if len(plaintext) < n+1+8 {
+			return plaintext, time.Time{}
+		}
+		nano := binary.BigEndian.Uint64(plaintext[n+1 : n+1+8])
+		return plaintext[n+1+8:], time.Unix(0, int64(nano))
+	default:
+		return plaintext, time.Time{}
+	}
+}
+
+// expired returns true if the expiry time is set and has passed.
+func expired(expiry time.Time) bool {
+	return !expiry.IsZero() && !time.Now().Before(expiry)
+}
+
+// putExpiry stores the value at key in tx with the expiry time. If the
+// expiry time is zero, the value is stored without an expiry time.
+func (cn *Chestnut) putExpiry(tx storage.Tx, name string, key []byte,
+	value []byte, expiry time.Time) error {
+	if expiry.IsZero() {
+		return tx.Put(name, key, value)
+	}
+	cn.log.Debugf("put: expire key %s at %s", key, expiry)
+	value = encodeExpiry(value, expiry)
+	// let the store expire the key too if it can. the store expires the key after
+	// a grace period so until then an expired key is reported as ErrNotFound.
+	if e, ok := tx.(storage.Expiring); ok {
+		if ttl := time.Until(expiry); ttl > 0 {
+			return e.PutTTL(name, key, value, ttl+expiryGrace)
+		}
+	}
+	return tx.Put(name, key, value)
+}
+
+// getExpiring returns the value at key in tx without its expiry time.
+// If the value has expired, an error wrapping ErrNotFound is returned.
+func (cn *Chestnut) getExpiring(tx storage.Tx, name string, key []byte) ([]byte, error) {
+	value, _, err := cn.getExpiry(tx, name, key)
+	return value, err
+}
+
+// getExpiry returns the value at key in tx and its expiry time. The expiry time is
+// zero if the value does not expire. If the value has expired, an error wrapping
+// ErrNotFound is returned.
+func (cn *Chestnut) getExpiry(tx storage.Tx, name string, key []byte) ([]byte, time.Time, error) {
+	value, err := tx.Get(name, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	value, expiry := decodeExpiry(value)
+	if expired(expiry) {
+		return nil, expiry, fmt.Errorf("%w: key expired: %s", ErrNotFound, key)
+	}
+	return value, expiry, nil
+}
+
+// Reap deletes all the expired records in the storage chest and returns the number
+// of records deleted. If the store supports transactions, each namespace is reaped
+// inside of a transaction so a record that is written while it is being reaped is
+// not deleted.
+func (cn *Chestnut) Reap() (int, error) {
+	cn.log.Debug("reap: expired keys")
+	if err := cn.writable("reap"); err != nil {
+		return 0, err
+	}
+	defer cn.lockWrites()()
+	keyMap, err := cn.store.ListAll()
+	if err != nil {
+		return 0, cn.logError("reap", err)
+	}
+	var total int
+	for name := range keyMap {
+		var n int
+		reap := func(tx storage.Tx) (err error) {
+			n, err = cn.reap(tx, name)
+			return
+		}
+		if store, ok := cn.store.(storage.Transactional); ok {
+			err = store.Update(reap)
+		} else {
+			err = reap(cn.store)
+		}
+		total += n
+		if err != nil {
+			return total, cn.logError("reap", err)
+		}
+	}
+	if total > 0 {
+		cn.log.Infof("reap: deleted %d expired keys", total)
+	}
+	return total, nil
+}
+
+// reap deletes the expired records in the namespace.
+func (cn *Chestnut) reap(tx storage.Tx, name string) (int, error) {
+	keys, err := tx.List(name)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, key := range keys {
+		value, err := tx.Get(name, key)
+		if err != nil {
+			// the key may have expired in the store
+			continue
+		}
+		if _, expiry := decodeExpiry(value); !expired(expiry) {
+			continue
+		}
+		cn.log.Debugf("reap: delete expired key: %s.%s", name, key)
+		if err = tx.Delete(name, key); err != nil {
+			return 0, err
+		}
+		if err = cn.unindex(tx, name, key); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// startReaper starts the background reaper if it is enabled.
+func (cn *Chestnut) startReaper() {
+	interval := cn.opts.reapInterval
+	if interval <= 0 || cn.reaper != nil || cn.opts.readOnly {
+		return
+	}
+	cn.log.Infof("reaping expired keys every %s", interval)
+	done := make(chan struct{})
+	cn.reaper = done
+	cn.reaperWG.Add(1)
+	go func() {
+		defer cn.reaperWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, _ = cn.Reap()
+			}
+		}
+	}()
+}
+
+// stopReaper stops the background reaper and waits for it to exit.
+func (cn *Chestnut) stopReaper() {
+	if cn.reaper == nil {
+		return
+	}
+	close(cn.reaper)
+	cn.reaperWG.Wait()
+	cn.reaper = nil
+	cn.log.Debug("reaper stopped")
+}
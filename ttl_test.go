@@ -0,0 +1,192 @@
+package chestnut
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jrapoport/chestnut/encryptor"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ttlName = "ttl-namespace"
+	testTTL = time.Second
+)
+
+func (ts *ChestnutTestSuite) putExpiring() {
+	err := ts.cn.Put(ttlName, []byte("a"), []byte(testValue), WithTTL(testTTL))
+	ts.NoError(err)
+	err = ts.cn.Save(ttlName, []byte("b"), &objectSrc, WithTTL(testTTL))
+	ts.NoError(err)
+	err = ts.cn.Update(func(tx *Tx) error {
+		return tx.Put(ttlName, []byte("c"), []byte(testValue), WithTTL(testTTL))
+	})
+	ts.NoError(err)
+	err = ts.cn.Put(ttlName, []byte("d"), []byte(testValue), WithTTL(0))
+	ts.NoError(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_TTL() {
+	ts.putExpiring()
+	value, err := ts.cn.Get(ttlName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	obj := &TObject{}
+	err = ts.cn.Load(ttlName, []byte("b"), obj)
+	ts.NoError(err)
+	ts.Equal(&objOut, obj)
+	has, err := ts.cn.Has(ttlName, []byte("c"))
+	ts.NoError(err)
+	ts.True(has)
+	keys, err := ts.cn.List(ttlName)
+	ts.NoError(err)
+	ts.Len(keys, 4)
+	time.Sleep(testTTL)
+	_, err = ts.cn.Get(ttlName, []byte("a"))
+	ts.ErrorIs(err, ErrNotFound)
+	err = ts.cn.Load(ttlName, []byte("b"), obj)
+	ts.ErrorIs(err, ErrNotFound)
+	err = ts.cn.Sparse(ttlName, []byte("b"), obj)
+	ts.ErrorIs(err, ErrNotFound)
+	has, err = ts.cn.Has(ttlName, []byte("c"))
+	ts.NoError(err)
+	ts.False(has)
+	keys, err = ts.cn.List(ttlName)
+	ts.NoError(err)
+	ts.Equal([][]byte{[]byte("d")}, keys)
+	// an expired key can be overwritten when overwrites are forbidden
+	cn := NewChestnut(ts.cn.store, encryptorOpt, OverwritesForbidden())
+	err = cn.Put(ttlName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	err = cn.Put(ttlName, []byte("d"), []byte(testValue))
+	ts.ErrorIs(err, ErrForbidden)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_TTLTampered() {
+	ts.putExpiring()
+	err := ts.cn.PutStream(ttlName, []byte("e"), strings.NewReader(lorumIpsum), WithTTL(testTTL))
+	ts.NoError(err)
+	time.Sleep(testTTL)
+	// the expiry time is removed, then moved into the future
+	for _, expiry := range []time.Time{{}, time.Now().Add(time.Hour)} {
+		for _, key := range []string{"a", "b", "e"} {
+			value, err := ts.cn.store.Get(ttlName, []byte(key))
+			ts.Require().NoError(err)
+			value, _ = decodeExpiry(value)
+			err = ts.cn.store.Put(ttlName, []byte(key), encodeExpiry(value, expiry))
+			ts.Require().NoError(err)
+		}
+		_, err = ts.cn.Get(ttlName, []byte("a"))
+		ts.ErrorIs(err, ErrNotFound)
+		err = ts.cn.Load(ttlName, []byte("b"), &TObject{})
+		ts.ErrorIs(err, ErrNotFound)
+		err = ts.cn.GetStream(ttlName, []byte("e"), &bytes.Buffer{})
+		ts.ErrorIs(err, ErrNotFound)
+	}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_TTLTag() {
+	past := binary.BigEndian.AppendUint64(nil, uint64(time.Now().Add(-time.Hour).UnixNano()))
+	values := [][]byte{
+		append(append(append([]byte{}, expiryTag...), expirySep...), past...),
+		append(append(append([]byte{}, sealTag...), sealExpires), past...),
+		append(append([]byte{}, sealTag...), sealNoExpiry),
+	}
+	for i, v := range values {
+		value := append(append([]byte{}, v...), testValue...)
+		key := []byte(fmt.Sprintf("tag-%d", i))
+		err := ts.cn.Put(ttlName, key, value)
+		ts.NoError(err)
+		got, err := ts.cn.Get(ttlName, key)
+		ts.NoError(err)
+		ts.Equal(value, got)
+	}
+	// records encrypted without a seal header are read as is
+	value := append(append(append([]byte{}, expiryTag...), expirySep...), past...)
+	legacy, err := ts.cn.encrypt(value)
+	ts.Require().NoError(err)
+	err = ts.cn.store.Put(ttlName, []byte("legacy"), legacy)
+	ts.NoError(err)
+	got, err := ts.cn.Get(ttlName, []byte("legacy"))
+	ts.NoError(err)
+	ts.Equal(value, got)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Reap() {
+	ts.putExpiring()
+	n, err := ts.cn.Reap()
+	ts.NoError(err)
+	ts.Equal(0, n)
+	time.Sleep(testTTL)
+	n, err = ts.cn.Reap()
+	ts.NoError(err)
+	ts.Equal(3, n)
+	keys, err := ts.cn.store.List(ttlName)
+	ts.NoError(err)
+	ts.Equal([][]byte{[]byte("d")}, keys)
+	// records without a ttl are not reaped
+	keys, err = ts.cn.List(testName)
+	ts.NoError(err)
+	ts.NotEmpty(keys)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Reaper() {
+	err := ts.cn.Close()
+	ts.NoError(err)
+	store := ts.storeFunc(ts.T(), ts.T().TempDir())
+	ts.cn = NewChestnut(store, encryptorOpt, WithReaper(10*time.Millisecond))
+	err = ts.cn.Open()
+	ts.NoError(err)
+	ts.putExpiring()
+	ts.Eventually(func() bool {
+		keys, _ := ts.cn.store.List(ttlName)
+		return len(keys) == 1
+	}, 2*testTTL, 10*time.Millisecond)
+	ts.Panics(func() {
+		NewChestnut(ts.cn.store, encryptorOpt, WithReaper(-1))
+	})
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_RekeyTTL() {
+	ts.putExpiring()
+	value, err := ts.cn.store.Get(ttlName, []byte("a"))
+	ts.NoError(err)
+	_, expiry := decodeExpiry(value)
+	newEncryptor := encryptor.NewAESEncryptor(crypto.Key256, aes.GCM, rekeySecret)
+	err = ts.cn.Rekey(newEncryptor)
+	ts.NoError(err)
+	// the rekeyed record keeps its expiry time
+	value, err = ts.cn.store.Get(ttlName, []byte("a"))
+	ts.NoError(err)
+	_, rekeyed := decodeExpiry(value)
+	ts.True(expiry.Equal(rekeyed))
+	value, err = ts.cn.Get(ttlName, []byte("d"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Expiry() {
+	data := []byte(testValue)
+	expiry := time.Unix(0, time.Now().UnixNano())
+	encoded := encodeExpiry(data, expiry)
+	decoded, exp := decodeExpiry(encoded)
+	ts.Equal(data, decoded)
+	ts.True(expiry.Equal(exp))
+	ts.True(expired(exp))
+	decoded, exp = decodeExpiry(data)
+	ts.Equal(data, decoded)
+	ts.True(exp.IsZero())
+	ts.False(expired(exp))
+	assert.Equal(ts.T(), data, encodeExpiry(data, time.Time{}))
+	ts.Nil(encodeExpiry(nil, expiry))
+	// a value that starts with the tag, but has no separator
+	bad := append(append([]byte{}, expiryTag...), data...)
+	decoded, exp = decodeExpiry(bad)
+	ts.Equal(bad, decoded)
+	ts.True(exp.IsZero())
+}
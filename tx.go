@@ -0,0 +1,148 @@
+package chestnut
+
+import (
+	"reflect"
+
+	"github.com/jrapoport/chestnut/storage"
+)
+
+// Tx is a storage chest transaction. Values written and read with a Tx are encrypted
+// and decrypted the same way as the storage chest, but are committed (or rolled back)
+// together. A Tx is only valid inside of the call to Update or View that created it.
+type Tx struct {
+	cn *Chestnut
+	tx storage.Tx
+	// events are published when the transaction is committed.
+	events []watchEvent
+}
+
+// Update executes fn inside of a read-write transaction. If fn returns nil all of
+// the writes made with the Tx are committed together. If fn returns an error, the
+// transaction is rolled back and none of the writes are applied. fn may be called
+// more than once, so it should not have side effects outside of the transaction.
+// fn must not call the storage chest directly, only through the Tx. If the
+// store does not support transactions, storage.ErrTxNotSupported is returned.
+func (cn *Chestnut) Update(fn func(tx *Tx) error) error {
+	cn.log.Debug("update: begin tx")
+	if err := cn.writable("update"); err != nil {
+		return err
+	}
+	defer cn.lockWrites()()
+	store, err := cn.transactional()
+	if err != nil {
+		return cn.logError("update", err)
+	}
+	var events []watchEvent
+	err = store.Update(func(tx storage.Tx) error {
+		t := &Tx{cn: cn, tx: tx}
+		err := fn(t)
+		// fn may be called again, only keep the last attempt
+		events = t.events
+		return err
+	})
+	if err != nil {
+		return cn.logError("update", err)
+	}
+	cn.log.Debug("update: tx committed")
+	cn.publish(events...)
+	return nil
+}
+
+// View executes fn inside of a read-only transaction. Writes made with the Tx
+// will return an error. If the store does not support transactions,
+// storage.ErrTxNotSupported is returned.
+func (cn *Chestnut) View(fn func(tx *Tx) error) error {
+	cn.log.Debug("view: begin tx")
+	store, err := cn.transactional()
+	if err != nil {
+		return cn.logError("view", err)
+	}
+	err = store.View(func(tx storage.Tx) error {
+		return fn(&Tx{cn: cn, tx: tx})
+	})
+	return cn.logError("view", err)
+}
+
+func (cn *Chestnut) transactional() (storage.Transactional, error) {
+	store, ok := cn.store.(storage.Transactional)
+	if !ok {
+		return nil, storage.ErrTxNotSupported
+	}
+	return store, nil
+}
+
+// Put encrypts the plaintext and stores it at key.
+func (t *Tx) Put(name string, key []byte, plaintext []byte, opt ...PutOption) error {
+	if err := t.cn.writable("put"); err != nil {
+		return err
+	}
+	ciphertext, err := t.cn.put(t.tx, name, key, plaintext, opt...)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, putEvent(name, key, ciphertext))
+	return nil
+}
+
+// Get decrypts the ciphertext at key and returns the plaintext.
+func (t *Tx) Get(name string, key []byte) ([]byte, error) {
+	return t.cn.get(t.tx, name, key)
+}
+
+// Save encrypts the struct in v and stores the encoded result at key.
+func (t *Tx) Save(name string, key []byte, v interface{}, opt ...PutOption) error {
+	if err := t.cn.writable("save"); err != nil {
+		return err
+	}
+	if err := t.cn.save(t.tx, name, key, v, opt...); err != nil {
+		return err
+	}
+	t.events = append(t.events, putEvent(name, key, nil))
+	return nil
+}
+
+// Load decrypts the struct at key and returns the decoded result in v.
+func (t *Tx) Load(name string, key []byte, v interface{}) error {
+	t.cn.log.Debugf("load: %v value at key: %s", reflect.TypeOf(v), key)
+	err := t.cn.load(t.tx, name, key, v, false)
+	return t.cn.logError("load", err)
+}
+
+// Sparse loads the struct at key and returns the sparsely decoded result in v.
+// SEE: Chestnut.Sparse.
+func (t *Tx) Sparse(name string, key []byte, v interface{}) error {
+	t.cn.log.Debugf("sparse: %v value at key: %s", reflect.TypeOf(v), key)
+	err := t.cn.load(t.tx, name, key, v, true)
+	return t.cn.logError("sparse", err)
+}
+
+// Has checks for a key in the transaction. Has returns true
+// if the key is found, otherwise false.
+func (t *Tx) Has(name string, key []byte) (bool, error) {
+	return t.cn.has(t.tx, name, key)
+}
+
+// Delete removes a key in the transaction. If the key is a stream,
+// the chunks of the stream are removed in the transaction too.
+func (t *Tx) Delete(name string, key []byte) error {
+	t.cn.log.Debugf("delete: key: %s", key)
+	if err := t.cn.writable("delete"); err != nil {
+		return err
+	}
+	if err := t.cn.deleteStream(t.tx, name, key); err != nil {
+		return t.cn.logError("delete", err)
+	}
+	if err := t.tx.Delete(name, key); err != nil {
+		return t.cn.logError("", err)
+	}
+	if err := t.cn.unindex(t.tx, name, key); err != nil {
+		return t.cn.logError("delete", err)
+	}
+	t.events = append(t.events, deleteEvent(name, key))
+	return nil
+}
+
+// List returns a list of keys in the namespace.
+func (t *Tx) List(namespace string) ([][]byte, error) {
+	return t.cn.list(t.tx, namespace)
+}
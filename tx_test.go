@@ -0,0 +1,145 @@
+package chestnut
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+
+	"github.com/jrapoport/chestnut/storage"
+)
+
+const txName = "tx-namespace"
+
+func (ts *ChestnutTestSuite) TestChestnut_Update() {
+	err := ts.cn.Update(func(tx *Tx) error {
+		for _, test := range objTests {
+			if test.src == nil {
+				continue
+			}
+			if err := tx.Save(txName, []byte(test.key), test.src); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(txName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("b")); err != nil {
+			return err
+		}
+		value, err := tx.Get(txName, []byte("a"))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+		has, err := tx.Has(testName, []byte("b"))
+		ts.NoError(err)
+		ts.False(has)
+		keys, err := tx.List(txName)
+		ts.NoError(err)
+		ts.NotEmpty(keys)
+		return nil
+	})
+	ts.NoError(err)
+	value, err := ts.cn.Get(txName, []byte("a"))
+	ts.NoError(err)
+	ts.Equal(testValue, string(value))
+	has, _ := ts.cn.Has(testName, []byte("b"))
+	ts.False(has)
+	err = ts.cn.View(func(tx *Tx) error {
+		for _, test := range objTests {
+			if test.dst == nil {
+				continue
+			}
+			typ := reflect.ValueOf(test.dst).Elem().Type()
+			ptr := reflect.New(typ).Interface()
+			if err = tx.Load(txName, []byte(test.key), ptr); err != nil {
+				return err
+			}
+			ts.Equal(test.out, ptr)
+			ptr = reflect.New(typ).Interface()
+			if err = tx.Sparse(txName, []byte(test.key), ptr); err != nil {
+				return err
+			}
+			ts.Equal(test.spr, ptr)
+		}
+		return nil
+	})
+	ts.NoError(err)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_UpdateDeleteStream() {
+	cn := ts.streamChest()
+	ts.putStream(cn, testStreamLarge)
+	chunks, err := cn.store.List(streamNamespace)
+	ts.NoError(err)
+	ts.Len(chunks, int(streamChunkCount(testStreamLarge)))
+	// the chunks are kept if the transaction is rolled back
+	errRollback := errors.New("rollback")
+	err = cn.Update(func(tx *Tx) error {
+		if err := tx.Delete(streamName, []byte(testStreamKey)); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	ts.ErrorIs(err, errRollback)
+	buf := &bytes.Buffer{}
+	err = cn.GetStream(streamName, []byte(testStreamKey), buf)
+	ts.NoError(err)
+	err = cn.Update(func(tx *Tx) error {
+		return tx.Delete(streamName, []byte(testStreamKey))
+	})
+	ts.NoError(err)
+	chunks, err = cn.store.List(streamNamespace)
+	ts.NoError(err)
+	ts.Empty(chunks)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_UpdateRollback() {
+	errRollback := errors.New("rollback")
+	err := ts.cn.Update(func(tx *Tx) error {
+		if err := tx.Put(txName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Delete(testName, []byte("b")); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	ts.ErrorIs(err, errRollback)
+	has, _ := ts.cn.Has(txName, []byte("a"))
+	ts.False(has)
+	has, _ = ts.cn.Has(testName, []byte("b"))
+	ts.True(has)
+	// an invalid write rolls back the transaction
+	err = ts.cn.Update(func(tx *Tx) error {
+		if err := tx.Put(txName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		return tx.Put(txName, []byte("b"), nil)
+	})
+	ts.Error(err)
+	has, _ = ts.cn.Has(txName, []byte("a"))
+	ts.False(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_View() {
+	err := ts.cn.View(func(tx *Tx) error {
+		value, err := tx.Get(testName, []byte("b"))
+		ts.NoError(err)
+		ts.Equal(testValue, string(value))
+		return tx.Put(testName, []byte("a"), []byte(testValue))
+	})
+	ts.Error(err)
+	has, _ := ts.cn.Has(testName, []byte("a"))
+	ts.False(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_TxNotSupported() {
+	cn := NewChestnut(&failingStore{Storage: ts.cn.store}, encryptorOpt)
+	err := cn.Update(func(tx *Tx) error {
+		return nil
+	})
+	ts.ErrorIs(err, storage.ErrTxNotSupported)
+	err = cn.View(func(tx *Tx) error {
+		return nil
+	})
+	ts.ErrorIs(err, storage.ErrTxNotSupported)
+}
@@ -0,0 +1,347 @@
+package chestnut
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+	"github.com/jrapoport/chestnut/storage"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// QuarantineSep separates the namespace and key of a record in the quarantine namespace.
+const QuarantineSep = "/"
+
+// RecordStatus is the result of verifying a record.
+type RecordStatus int
+
+const (
+	// StatusOK the record was decrypted and decoded.
+	StatusOK RecordStatus = iota
+	// StatusBadHeader the stored value, or its encryption header could not be decoded.
+	StatusBadHeader
+	// StatusWrongKey the record could not be decrypted with the encryptor, or the
+	// decrypted data is not valid. AES-CFB and AES-CTR data without a MAC (read with
+	// WithLegacyUnauthenticated) does not fail to decrypt with the wrong secret, so
+	// for it only records whose decrypted data can be checked (structs, streams, and
+	// compressed values) are reported. Other values decrypt to garbage and are OK.
+	StatusWrongKey
+	// StatusUnsupportedVersion the record was encoded with a newer format version.
+	StatusUnsupportedVersion
+	// StatusDecompressFailed the decrypted record could not be decompressed.
+	StatusDecompressFailed
+	// StatusCorrupt a chunk of a stream is missing, or failed authentication.
+	StatusCorrupt
+)
+
+// String returns the name of the status.
+func (s RecordStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusBadHeader:
+		return "bad header"
+	case StatusWrongKey:
+		return "wrong key"
+	case StatusUnsupportedVersion:
+		return "unsupported version"
+	case StatusDecompressFailed:
+		return "decompression failed"
+	case StatusCorrupt:
+		return "corrupt"
+	default:
+		return "unknown"
+	}
+}
+
+// RecordResult is the result of verifying a record.
+type RecordResult struct {
+	// Namespace is the namespace of the record.
+	Namespace string
+	// Key is the key of the record.
+	Key []byte
+	// Status is the result of verifying the record.
+	Status RecordStatus
+	// Err is the error for the status, or nil if the status is StatusOK.
+	Err error
+	// Quarantined is true if the record was moved to the quarantine namespace.
+	Quarantined bool
+}
+
+// Report is returned by Verify.
+type Report struct {
+	// Results are the results of the verified records ordered by namespace and key.
+	Results []RecordResult
+}
+
+// OK returns true if every record was verified.
+func (r Report) OK() bool {
+	return len(r.Bad()) <= 0
+}
+
+// Bad returns the results of the records that were not verified.
+func (r Report) Bad() []RecordResult {
+	var bad []RecordResult
+	for _, res := range r.Results {
+		if res.Status != StatusOK {
+			bad = append(bad, res)
+		}
+	}
+	return bad
+}
+
+// Count returns the number of records with the status.
+func (r Report) Count(status RecordStatus) int {
+	var n int
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// VerifyOptions provides the options for a call to Verify.
+type VerifyOptions struct {
+	// quarantine is the namespace bad records are moved to.
+	quarantine string
+}
+
+// A VerifyOption sets options such as the quarantine namespace.
+type VerifyOption interface {
+	apply(*VerifyOptions)
+}
+
+// verifyFuncOption wraps a function that modifies VerifyOptions
+// into an implementation of the VerifyOption interface.
+type verifyFuncOption struct {
+	f func(*VerifyOptions)
+}
+
+// apply applies an Option to VerifyOptions.
+func (fdo *verifyFuncOption) apply(do *VerifyOptions) {
+	fdo.f(do)
+}
+
+func newVerifyFuncOption(f func(*VerifyOptions)) *verifyFuncOption {
+	return &verifyFuncOption{
+		f: f,
+	}
+}
+
+// VerifyQuarantine returns a VerifyOption that moves the records that are not
+// verified to the namespace name. The stored value is moved as is, and its key
+// is the namespace and key of the record joined by QuarantineSep. The blind index
+// entries of a moved record are removed, and watchers get a delete event for it.
+// The chunks of a moved stream are left in place, so the stream can be recovered
+// from the quarantine namespace, and are only removed when it is deleted there.
+func VerifyQuarantine(name string) VerifyOption {
+	return newVerifyFuncOption(func(o *VerifyOptions) {
+		o.quarantine = name
+	})
+}
+
+// Verify reads every record in the storage chest and checks that it can be decoded,
+// decrypted, and decompressed. Values stored with Put, structs stored with Save, and
+// streams stored with PutStream (including their chunks) are verified. Expired records
+// and the namespaces reserved by the storage chest are skipped. The records in the
+// quarantine namespace are not verified. If ctx is done, Verify stops and returns the
+// report for the records verified so far with the error from ctx.
+//
+// Verify cannot always detect the wrong key. AES-CFB and AES-CTR data without a MAC
+// (read with WithLegacyUnauthenticated) decrypts without an error using any secret,
+// so a plain value stored that way is reported as StatusOK even if the key is wrong.
+func (cn *Chestnut) Verify(ctx context.Context, opt ...VerifyOption) (Report, error) {
+	opts := VerifyOptions{}
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	var report Report
+	if opts.quarantine != "" {
+		if err := storage.ValidKey(opts.quarantine, []byte(QuarantineSep)); err != nil {
+			return report, cn.logError("verify", err)
+		} else if isReserved(opts.quarantine) {
+			err = fmt.Errorf("%w: namespace is reserved: %s", ErrForbidden, opts.quarantine)
+			return report, cn.logError("verify", err)
+		} else if err = cn.writable("verify"); err != nil {
+			return report, err
+		}
+		defer cn.lockWrites()()
+		cn.log.Infof("verify: quarantine namespace: %s", opts.quarantine)
+	}
+	keyMap, err := cn.store.ListAll()
+	if err != nil {
+		return report, cn.logError("verify", err)
+	}
+	names := make([]string, 0, len(keyMap))
+	for name, keys := range keyMap {
+		if isReserved(name) || name == opts.quarantine {
+			continue
+		}
+		names = append(names, name)
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, key := range keyMap[name] {
+			if err = ctx.Err(); err != nil {
+				return report, cn.logError("verify", err)
+			}
+			value, err := cn.store.Get(name, key)
+			if err != nil {
+				// the key was deleted or expired by the store after it was listed
+				if has, _ := cn.store.Has(name, key); !has {
+					continue
+				}
+				return report, cn.logError("verify", err)
+			}
+			data, expiry := decodeExpiry(value)
+			if expired(expiry) {
+				continue
+			}
+			res := RecordResult{Namespace: name, Key: key}
+			res.Status, res.Err = cn.verifyRecord(data)
+			if res.Status != StatusOK {
+				cn.log.Warnf("verify: key: %s.%s %s: %s", name, key, res.Status, res.Err)
+				if opts.quarantine != "" {
+					if err = cn.quarantine(opts.quarantine, name, key, value); err != nil {
+						return report, cn.logError("verify", err)
+					}
+					res.Quarantined = true
+				}
+			}
+			report.Results = append(report.Results, res)
+		}
+	}
+	cn.log.Infof("verify: verified %d records, %d bad", len(report.Results), len(report.Bad()))
+	return report, nil
+}
+
+// verifyRecord returns the status of the stored value.
+func (cn *Chestnut) verifyRecord(value []byte) (RecordStatus, error) {
+	if isStream(value) {
+		return cn.verifyStream(value)
+	}
+	pkg, err := packager.DecodePackage(value)
+	if err == nil {
+		return cn.verifyPackage(pkg)
+	} else if errors.Is(err, packager.ErrUnsupportedVersion) {
+		return StatusUnsupportedVersion, err
+	}
+	plaintext, status, err := cn.verifyCiphertext(value)
+	if status != StatusOK {
+		return status, err
+	}
+	if _, err = cn.decompress(plaintext); err != nil {
+		return StatusDecompressFailed, err
+	}
+	return StatusOK, nil
+}
+
+// verifyCiphertext decrypts the ciphertext. If it cannot be decrypted, the crypto.Data
+// envelope is decoded to tell a bad header from a wrong key. Encryptors that do not use
+// the crypto.Data envelope (e.g. encryptor.AgeEncryptor) are only checked by decryption.
+func (cn *Chestnut) verifyCiphertext(ciphertext []byte) ([]byte, RecordStatus, error) {
+	plaintext, err := cn.openCiphertext(ciphertext)
+	if err == nil {
+		plaintext, _ = openExpiry(plaintext)
+		return plaintext, StatusOK, nil
+	}
+	data, headerErr := crypto.DecodeData(ciphertext)
+	if headerErr == nil {
+		headerErr = data.Valid()
+	}
+	if headerErr != nil {
+		return nil, StatusBadHeader, headerErr
+	}
+	return nil, StatusWrongKey, err
+}
+
+// verifyPackage decrypts and decompresses a package stored by Save.
+func (cn *Chestnut) verifyPackage(pkg *packager.Package) (RecordStatus, error) {
+	plaintext, status, err := cn.verifyCiphertext(pkg.Cipher)
+	if status != StatusOK {
+		return status, err
+	}
+	if pkg.Compressed {
+		if plaintext, err = cn.decompress(plaintext); err != nil {
+			return StatusDecompressFailed, err
+		}
+		if pkg.Format == packager.Sparse {
+			if _, err = cn.decompress(pkg.Encoded); err != nil {
+				return StatusDecompressFailed, err
+			}
+		}
+	}
+	// jsoniter.Valid ignores anything after the first value
+	if !stdjson.Valid(plaintext) {
+		return StatusWrongKey, errors.New("decrypted data is not valid")
+	}
+	return StatusOK, nil
+}
+
+// verifyStream decrypts a stream manifest and authenticates its chunks.
+func (cn *Chestnut) verifyStream(value []byte) (RecordStatus, error) {
+	ciphertext := value[len(streamTag)+len(streamSep):]
+	b, status, err := cn.verifyCiphertext(ciphertext)
+	if status != StatusOK {
+		return status, err
+	}
+	m := new(streamManifest)
+	if err = jsoniter.Unmarshal(b, m); err != nil {
+		return StatusWrongKey, err
+	}
+	if m.Version != streamVersion {
+		return StatusUnsupportedVersion, fmt.Errorf("unsupported stream version: %d", m.Version)
+	}
+	aead, err := m.aead()
+	if err != nil {
+		return StatusWrongKey, err
+	}
+	for i := uint32(0); i < m.Chunks; i++ {
+		sealed, err := cn.store.Get(streamNamespace, m.chunkKey(i))
+		if err != nil {
+			return StatusCorrupt, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		sealed, _ = decodeExpiry(sealed)
+		last := i == m.Chunks-1
+		if _, err = aead.Open(nil, m.nonce(i, last), sealed, nil); err != nil {
+			return StatusCorrupt, fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+	return StatusOK, nil
+}
+
+// quarantine moves the stored value at name.key to the quarantine namespace.
+func (cn *Chestnut) quarantine(namespace string, name string, key []byte, value []byte) error {
+	qkey := append([]byte(name+QuarantineSep), key...)
+	value = append([]byte{}, value...)
+	move := func(tx storage.Tx) error {
+		if err := tx.Put(namespace, qkey, value); err != nil {
+			return err
+		}
+		if err := tx.Delete(name, key); err != nil {
+			return err
+		}
+		return cn.unindex(tx, name, key)
+	}
+	cn.log.Infof("verify: quarantine key: %s.%s", name, key)
+	var err error
+	if store, ok := cn.store.(storage.Transactional); ok {
+		err = store.Update(move)
+	} else {
+		err = move(cn.store)
+	}
+	if err != nil {
+		return err
+	}
+	cn.publish(deleteEvent(name, key))
+	return nil
+}
@@ -0,0 +1,189 @@
+package chestnut
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"strings"
+
+	"github.com/jrapoport/chestnut/encoding/compress/zstd"
+	"github.com/jrapoport/chestnut/encoding/json/packager"
+	"github.com/jrapoport/chestnut/encryptor/aes"
+	"github.com/jrapoport/chestnut/encryptor/crypto"
+)
+
+const verifyName = "verify-namespace"
+
+func (ts *ChestnutTestSuite) putVerify() {
+	err := ts.cn.Put(verifyName, []byte("a"), []byte(testValue))
+	ts.Require().NoError(err)
+	err = ts.cn.Save(verifyName, []byte("b"), &objectSrc)
+	ts.Require().NoError(err)
+	err = ts.cn.PutStream(verifyName, []byte("stream"), strings.NewReader(lorumIpsum))
+	ts.Require().NoError(err)
+	err = ts.cn.Put(verifyName, []byte("expired"), []byte(testValue), WithTTL(1))
+	ts.Require().NoError(err)
+}
+
+func (ts *ChestnutTestSuite) verifyStatus(report Report, key string) RecordResult {
+	for _, res := range report.Results {
+		if res.Namespace == verifyName && string(res.Key) == key {
+			return res
+		}
+	}
+	ts.Failf("verify", "key not found: %s", key)
+	return RecordResult{}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Verify() {
+	ts.putVerify()
+	keys, err := ts.cn.List(testName)
+	ts.Require().NoError(err)
+	report, err := ts.cn.Verify(context.Background())
+	ts.NoError(err)
+	ts.True(report.OK())
+	ts.Empty(report.Bad())
+	// the expired key is skipped
+	ts.Len(report.Results, len(keys)+3)
+	ts.Equal(len(report.Results), report.Count(StatusOK))
+	for _, res := range report.Results {
+		ts.NotEqual(streamNamespace, res.Namespace)
+	}
+	// keyed structs are verified
+	err = ts.cn.SaveKeyed(keyedObj)
+	ts.NoError(err)
+	report, err = ts.cn.Verify(context.Background())
+	ts.NoError(err)
+	ts.True(report.OK())
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_VerifyBad() {
+	ts.putVerify()
+	// bad header
+	err := ts.cn.store.Put(verifyName, []byte("header"), []byte("not-ciphertext"))
+	ts.Require().NoError(err)
+	// unsupported version
+	buf := &bytes.Buffer{}
+	err = gob.NewEncoder(buf).Encode(&packager.Package{Version: "999.0.0"})
+	ts.Require().NoError(err)
+	err = ts.cn.store.Put(verifyName, []byte("version"), buf.Bytes())
+	ts.Require().NoError(err)
+	// wrong key
+	gcm := WithAES(crypto.Key256, aes.GCM, textSecret)
+	cn := NewChestnut(ts.cn.store, WithAES(crypto.Key256, aes.GCM, rekeySecret))
+	err = cn.Put(verifyName, []byte("gcm"), []byte(testValue))
+	ts.Require().NoError(err)
+	// decompression failed
+	cn = NewChestnut(ts.cn.store, gcm, WithCompressors(zstd.Compress, zstd.Decompress))
+	err = cn.Put(verifyName, []byte("compressed"), []byte(lorumIpsum))
+	ts.Require().NoError(err)
+	// missing stream chunk
+	value, err := ts.cn.store.Get(verifyName, []byte("stream"))
+	ts.Require().NoError(err)
+	m, err := ts.cn.decodeStreamManifest(value)
+	ts.Require().NoError(err)
+	err = ts.cn.store.Delete(streamNamespace, m.chunkKey(0))
+	ts.Require().NoError(err)
+	badDecompress := func([]byte) ([]byte, error) {
+		return nil, errors.New("decompression failed")
+	}
+	cn = NewChestnut(ts.cn.store, gcm, WithCompressors(zstd.Compress, badDecompress))
+	report, err := cn.Verify(context.Background())
+	ts.NoError(err)
+	ts.False(report.OK())
+	verifyTests := []struct {
+		key    string
+		status RecordStatus
+	}{
+		{"header", StatusBadHeader},
+		{"version", StatusUnsupportedVersion},
+		{"gcm", StatusWrongKey},
+		{"compressed", StatusDecompressFailed},
+		{"stream", StatusWrongKey},
+	}
+	for _, test := range verifyTests {
+		res := ts.verifyStatus(report, test.key)
+		ts.Equal(test.status, res.Status, test.key)
+		ts.Error(res.Err, test.key)
+		ts.False(res.Quarantined)
+	}
+	// the struct does not decrypt to json with the wrong secret
+	cn = NewChestnut(ts.cn.store, WithAES(crypto.Key256, aes.CFB, rekeySecret))
+	report, err = cn.Verify(context.Background())
+	ts.NoError(err)
+	res := ts.verifyStatus(report, "b")
+	ts.Equal(StatusWrongKey, res.Status)
+	// the stream manifest decrypts, but a chunk is missing
+	report, err = ts.cn.Verify(context.Background())
+	ts.NoError(err)
+	res = ts.verifyStatus(report, "stream")
+	ts.Equal(StatusCorrupt, res.Status)
+	ts.Equal(1, report.Count(StatusCorrupt))
+	// the records were not changed
+	has, err := ts.cn.store.Has(verifyName, []byte("header"))
+	ts.NoError(err)
+	ts.True(has)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_VerifyQuarantine() {
+	const quarantine = "quarantine-namespace"
+	ts.putVerify()
+	err := ts.cn.store.Put(verifyName, []byte("header"), []byte("not-ciphertext"))
+	ts.Require().NoError(err)
+	report, err := ts.cn.Verify(context.Background(), VerifyQuarantine(quarantine))
+	ts.NoError(err)
+	bad := report.Bad()
+	ts.Require().Len(bad, 1)
+	ts.Equal(StatusBadHeader, bad[0].Status)
+	ts.True(bad[0].Quarantined)
+	has, err := ts.cn.store.Has(verifyName, []byte("header"))
+	ts.NoError(err)
+	ts.False(has)
+	value, err := ts.cn.store.Get(quarantine, []byte(verifyName+QuarantineSep+"header"))
+	ts.NoError(err)
+	ts.Equal("not-ciphertext", string(value))
+	// the quarantine namespace is not verified
+	report, err = ts.cn.Verify(context.Background(), VerifyQuarantine(quarantine))
+	ts.NoError(err)
+	ts.True(report.OK())
+	// the quarantine namespace must be valid
+	_, err = ts.cn.Verify(context.Background(), VerifyQuarantine(streamNamespace))
+	ts.ErrorIs(err, ErrForbidden)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_VerifyQuarantineIndex() {
+	const quarantine = "quarantine-namespace"
+	cn := NewChestnut(ts.cn.store, encryptorOpt, WithBlindIndex(indexSecret))
+	err := cn.Save(indexName, []byte("1"), &TIndexed{Email: "a@example.com"})
+	ts.Require().NoError(err)
+	ts.Equal([]string{"1"}, ts.findBy(cn, "email", "a@example.com"))
+	entries, err := cn.store.List(indexNamespace)
+	ts.NoError(err)
+	ts.NotEmpty(entries)
+	err = cn.store.Put(indexName, []byte("1"), []byte("not-ciphertext"))
+	ts.Require().NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := cn.Watch(ctx, indexName)
+	report, err := cn.Verify(context.Background(), VerifyQuarantine(quarantine))
+	ts.NoError(err)
+	ts.Require().Len(report.Bad(), 1)
+	// the blind index entries are removed with the record
+	ts.Empty(ts.findBy(cn, "email", "a@example.com"))
+	entries, _ = cn.store.List(indexNamespace)
+	ts.Empty(entries)
+	// watchers see the record deleted
+	e := ts.nextEvent(ch)
+	ts.Equal(OpDelete, e.Op)
+	ts.Equal([]byte("1"), e.Key)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_VerifyCanceled() {
+	ts.putVerify()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := ts.cn.Verify(ctx)
+	ts.ErrorIs(err, context.Canceled)
+	ts.Empty(report.Results)
+}
@@ -0,0 +1,319 @@
+package chestnut
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultWatchHistory is the default number of events kept for replay.
+const DefaultWatchHistory = 1024
+
+// defaultWatchBuffer is the default size of the event channel returned by Watch.
+const defaultWatchBuffer = 16
+
+// Op is the type of change an Event describes.
+type Op int
+
+const (
+	// OpPut a value was stored with Put, Save, or PutStream.
+	OpPut Op = iota + 1
+	// OpDelete a value was deleted.
+	OpDelete
+	// OpMissed events were dropped before they could be delivered. The subscriber
+	// fell too far behind, or the sequence to replay from is no longer in the
+	// history. The subscriber should assume anything in the namespace may have
+	// changed.
+	OpMissed
+)
+
+// String returns the name of the op.
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	case OpMissed:
+		return "missed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change in the storage chest.
+type Event struct {
+	// Seq is the sequence number of the event. Sequence numbers increase by one
+	// with each change and start over when the storage chest is created.
+	Seq uint64
+	// Op is the type of change.
+	Op Op
+	// Namespace is the namespace of the key that changed.
+	Namespace string
+	// Key is the key that changed.
+	Key []byte
+	// Value is the decrypted value of a Put if WatchValues is set, otherwise nil.
+	// Values stored with Save or PutStream are not included.
+	Value []byte
+}
+
+// WatchOptions provides the options for a call to Watch.
+type WatchOptions struct {
+	// from is the sequence number to replay events from.
+	from uint64
+	// values includes the decrypted values in the events.
+	values bool
+	// buffer is the size of the event channel.
+	buffer int
+}
+
+// A WatchOption sets options such as the sequence number to replay from.
+type WatchOption interface {
+	apply(*WatchOptions)
+}
+
+// watchFuncOption wraps a function that modifies WatchOptions
+// into an implementation of the WatchOption interface.
+type watchFuncOption struct {
+	f func(*WatchOptions)
+}
+
+// apply applies an Option to WatchOptions.
+func (fdo *watchFuncOption) apply(do *WatchOptions) {
+	fdo.f(do)
+}
+
+func newWatchFuncOption(f func(*WatchOptions)) *watchFuncOption {
+	return &watchFuncOption{
+		f: f,
+	}
+}
+
+// WatchFrom returns a WatchOption that replays the events in the history starting
+// with the event seq before any new events are sent. A subscriber that reconnects can
+// pass the sequence number after the last event it received so it does not miss any
+// changes. If seq is no longer in the history (SEE: WithWatchHistory), an OpMissed
+// event is sent first.
+func WatchFrom(seq uint64) WatchOption {
+	return newWatchFuncOption(func(o *WatchOptions) {
+		o.from = seq
+	})
+}
+
+// WatchValues returns a WatchOption that includes the decrypted value in put events.
+func WatchValues() WatchOption {
+	return newWatchFuncOption(func(o *WatchOptions) {
+		o.values = true
+	})
+}
+
+// WatchBuffer returns a WatchOption that sets the size of the event channel.
+func WatchBuffer(size int) WatchOption {
+	return newWatchFuncOption(func(o *WatchOptions) {
+		o.buffer = size
+	})
+}
+
+// Watch returns a channel of events for the changes to the namespace. If name is
+// empty, the changes to all namespaces are sent. Events are sent after a change has
+// been committed. The changes made by one writer are sent in the order they were
+// committed, but the changes made by concurrent writers are sent in the order they
+// are published, which may differ from the order they were committed. The writer is
+// never blocked by a subscriber. Instead, if a subscriber falls further behind than
+// the history size (SEE: WithWatchHistory), it is sent an OpMissed event and skips
+// ahead to the oldest event in the history. The channel is closed when ctx is done,
+// or the storage chest is closed.
+func (cn *Chestnut) Watch(ctx context.Context, name string, opt ...WatchOption) <-chan Event {
+	opts := WatchOptions{buffer: defaultWatchBuffer}
+	for _, o := range opt {
+		o.apply(&opts)
+	}
+	if opts.buffer < 0 {
+		opts.buffer = 0
+	}
+	cn.log.Debugf("watch: namespace: %s from: %d", name, opts.from)
+	ch := make(chan Event, opts.buffer)
+	sub := cn.watch.subscribe(name, opts)
+	go func() {
+		defer close(ch)
+		defer cn.watch.unsubscribe(sub)
+		for {
+			for _, we := range cn.watch.pending(sub) {
+				e := we.Event
+				if opts.values && e.Op == OpPut && len(we.ciphertext) > 0 {
+					e.Value = cn.watchValue(we)
+				}
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				case <-sub.done:
+					return
+				}
+			}
+			select {
+			case <-sub.wake:
+			case <-ctx.Done():
+				return
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// watchValue returns the decrypted value of the event.
+func (cn *Chestnut) watchValue(we watchEvent) []byte {
+	plaintext, err := cn.decrypt(we.ciphertext)
+	if err == nil {
+		plaintext, err = cn.decompress(plaintext)
+	}
+	if err != nil {
+		cn.log.Warnf("watch: value of key: %s.%s: %s", we.Namespace, we.Key, err)
+		return nil
+	}
+	return plaintext
+}
+
+// watchEvent is an event in the history. The value is kept encrypted
+// and is only decrypted for subscribers that asked for it.
+type watchEvent struct {
+	Event
+	ciphertext []byte
+}
+
+// putEvent returns a put event for the key. ciphertext is only set for values
+// stored with Put.
+func putEvent(name string, key []byte, ciphertext []byte) watchEvent {
+	return watchEvent{
+		Event: Event{
+			Op:        OpPut,
+			Namespace: name,
+			Key:       append([]byte{}, key...),
+		},
+		ciphertext: ciphertext,
+	}
+}
+
+// deleteEvent returns a delete event for the key.
+func deleteEvent(name string, key []byte) watchEvent {
+	return watchEvent{
+		Event: Event{
+			Op:        OpDelete,
+			Namespace: name,
+			Key:       append([]byte{}, key...),
+		},
+	}
+}
+
+// subscriber is a call to Watch.
+type subscriber struct {
+	name string
+	next uint64
+	wake chan struct{}
+	done chan struct{}
+}
+
+// watcher keeps the history of events in a ring buffer
+// and wakes the subscribers when an event is published.
+type watcher struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []watchEvent
+	subs    map[*subscriber]struct{}
+}
+
+func newWatcher(size int) *watcher {
+	if size <= 0 {
+		size = DefaultWatchHistory
+	}
+	return &watcher{
+		history: make([]watchEvent, size),
+		subs:    map[*subscriber]struct{}{},
+	}
+}
+
+// oldest returns the sequence number of the oldest event in the history.
+func (w *watcher) oldest() uint64 {
+	size := uint64(len(w.history))
+	if w.seq < size {
+		return 1
+	}
+	return w.seq - size + 1
+}
+
+// publish adds the events to the history and wakes the subscribers.
+func (w *watcher) publish(events ...watchEvent) {
+	if len(events) <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range events {
+		w.seq++
+		e.Seq = w.seq
+		w.history[w.seq%uint64(len(w.history))] = e
+	}
+	for sub := range w.subs {
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+			// the subscriber is already awake
+		}
+	}
+}
+
+func (w *watcher) subscribe(name string, opts WatchOptions) *subscriber {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub := &subscriber{
+		name: name,
+		next: w.seq + 1,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if opts.from > 0 && opts.from < sub.next {
+		sub.next = opts.from
+	}
+	w.subs[sub] = struct{}{}
+	return sub
+}
+
+func (w *watcher) unsubscribe(sub *subscriber) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.subs[sub]; ok {
+		delete(w.subs, sub)
+		close(sub.done)
+	}
+}
+
+// pending returns the events the subscriber has not seen yet.
+func (w *watcher) pending(sub *subscriber) []watchEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []watchEvent
+	if oldest := w.oldest(); sub.next < oldest {
+		missed := watchEvent{Event: Event{Seq: sub.next, Op: OpMissed, Namespace: sub.name}}
+		events = append(events, missed)
+		sub.next = oldest
+	}
+	for ; sub.next <= w.seq; sub.next++ {
+		e := w.history[sub.next%uint64(len(w.history))]
+		if sub.name != "" && e.Namespace != sub.name {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// close ends all the subscriptions.
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for sub := range w.subs {
+		delete(w.subs, sub)
+		close(sub.done)
+	}
+}
@@ -0,0 +1,134 @@
+package chestnut
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const watchName = "watch-namespace"
+
+func (ts *ChestnutTestSuite) nextEvent(ch <-chan Event) Event {
+	select {
+	case e, ok := <-ch:
+		ts.Require().True(ok, "channel closed")
+		return e
+	case <-time.After(5 * time.Second):
+		ts.FailNow("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_Watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := ts.cn.Watch(ctx, watchName, WatchValues())
+	all := ts.cn.Watch(ctx, "")
+	err := ts.cn.Put(testName, []byte("other"), []byte(testValue))
+	ts.NoError(err)
+	err = ts.cn.Put(watchName, []byte("a"), []byte(testValue))
+	ts.NoError(err)
+	err = ts.cn.Save(watchName, []byte("b"), &objectSrc)
+	ts.NoError(err)
+	err = ts.cn.Delete(watchName, []byte("a"))
+	ts.NoError(err)
+	// failed writes are not sent
+	err = ts.cn.Put(watchName, []byte("a"), nil)
+	ts.Error(err)
+	e := ts.nextEvent(ch)
+	ts.Equal(OpPut, e.Op)
+	ts.Equal(watchName, e.Namespace)
+	ts.Equal([]byte("a"), e.Key)
+	ts.Equal([]byte(testValue), e.Value)
+	seq := e.Seq
+	e = ts.nextEvent(ch)
+	ts.Equal(OpPut, e.Op)
+	ts.Equal([]byte("b"), e.Key)
+	ts.Nil(e.Value)
+	ts.Equal(seq+1, e.Seq)
+	e = ts.nextEvent(ch)
+	ts.Equal(OpDelete, e.Op)
+	ts.Equal([]byte("a"), e.Key)
+	ts.Equal(seq+2, e.Seq)
+	for _, key := range []string{"other", "a", "b", "a"} {
+		e = ts.nextEvent(all)
+		ts.Equal(key, string(e.Key))
+		ts.Nil(e.Value)
+	}
+	// replay from a sequence
+	replay := ts.cn.Watch(ctx, watchName, WatchFrom(seq+1))
+	e = ts.nextEvent(replay)
+	ts.Equal(seq+1, e.Seq)
+	ts.Equal([]byte("b"), e.Key)
+	e = ts.nextEvent(replay)
+	ts.Equal(OpDelete, e.Op)
+	cancel()
+	for range ch {
+		// drain until closed
+	}
+	_, ok := <-all
+	ts.False(ok)
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_WatchTx() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := ts.cn.Watch(ctx, watchName)
+	errRollback := errors.New("rollback")
+	err := ts.cn.Update(func(tx *Tx) error {
+		if err := tx.Put(watchName, []byte("rollback"), []byte(testValue)); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	ts.ErrorIs(err, errRollback)
+	err = ts.cn.Update(func(tx *Tx) error {
+		if err := tx.Put(watchName, []byte("a"), []byte(testValue)); err != nil {
+			return err
+		}
+		if err := tx.Save(watchName, []byte("b"), &objectSrc); err != nil {
+			return err
+		}
+		return tx.Delete(watchName, []byte("a"))
+	})
+	ts.NoError(err)
+	for _, op := range []Op{OpPut, OpPut, OpDelete} {
+		e := ts.nextEvent(ch)
+		ts.Equal(op, e.Op)
+		ts.NotEqual("rollback", string(e.Key))
+	}
+	select {
+	case e := <-ch:
+		ts.Failf("unexpected event", "%v", e)
+	default:
+	}
+}
+
+func (ts *ChestnutTestSuite) TestChestnut_WatchMissed() {
+	const history = 4
+	store := ts.storeFunc(ts.T(), ts.T().TempDir())
+	cn := NewChestnut(store, encryptorOpt, WithWatchHistory(history))
+	err := cn.Open()
+	ts.Require().NoError(err)
+	for i := 0; i < 10; i++ {
+		err = cn.Put(watchName, []byte(fmt.Sprintf("key-%d", i)), []byte(testValue))
+		ts.NoError(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := cn.Watch(ctx, watchName, WatchFrom(1), WatchBuffer(0))
+	e := ts.nextEvent(ch)
+	ts.Equal(OpMissed, e.Op)
+	ts.Equal(uint64(1), e.Seq)
+	for i := 10 - history; i < 10; i++ {
+		e = ts.nextEvent(ch)
+		ts.Equal(OpPut, e.Op)
+		ts.Equal(fmt.Sprintf("key-%d", i), string(e.Key))
+	}
+	// closing the storage chest ends the subscription
+	err = cn.Close()
+	ts.NoError(err)
+	_, ok := <-ch
+	ts.False(ok)
+}